@@ -7,4 +7,5 @@ require (
 	github.com/aws/aws-sdk-go v1.30.14
 	github.com/pkg/errors v0.9.1
 	golang.org/x/crypto v0.0.0-20200423211502-4bdfaf469ed5
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAddressFamily = "ipv4"
+	defaultAddressMode   = "private-first"
+)
+
+// addressFamilies are values of ADDRESS_FAMILY: preferred family goes first,
+// -only drops addresses of the other family
+var addressFamilies = []string{"ipv4", "ipv6", "ipv4-only", "ipv6-only"}
+
+// addressModes are values of ADDRESS_MODE: which of private and public
+// addresses of discovered instances are contacted and in what order
+var addressModes = []string{"private-only", "public-only", "private-first", "public-first"}
+
+// getAddressMode returns ADDRESS_MODE
+func getAddressMode() (string, error) {
+	mode := getEnv("ADDRESS_MODE", defaultAddressMode)
+	for _, m := range addressModes {
+		if m == mode {
+			return mode, nil
+		}
+	}
+
+	return "", errors.Errorf("Unknown ADDRESS_MODE: '%s' (available: %s)", mode, strings.Join(addressModes, ", "))
+}
+
+// instanceAddrs combines private and public addresses by the mode, empty ones are skipped
+func instanceAddrs(private, public []string, mode string) []string {
+	var groups [][]string
+	switch mode {
+	case "private-only":
+		groups = [][]string{private}
+	case "public-only":
+		groups = [][]string{public}
+	case "public-first":
+		groups = [][]string{public, private}
+	default:
+		groups = [][]string{private, public}
+	}
+
+	addrs := []string{}
+	for _, group := range groups {
+		for _, addr := range group {
+			if addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	return addrs
+}
+
+// getAddressFamily returns ADDRESS_FAMILY
+func getAddressFamily() (string, error) {
+	family := getEnv("ADDRESS_FAMILY", defaultAddressFamily)
+	for _, f := range addressFamilies {
+		if f == family {
+			return family, nil
+		}
+	}
+
+	return "", errors.Errorf("Unknown ADDRESS_FAMILY: '%s' (available: %s)", family, strings.Join(addressFamilies, ", "))
+}
+
+// orderAddrs orders addresses by the family, host names are always kept in place
+// of the preferred family
+func orderAddrs(addrs []string, family string) []string {
+	preferV6 := strings.HasPrefix(family, "ipv6")
+	only := strings.HasSuffix(family, "-only")
+
+	preferred, other := []string{}, []string{}
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil || (ip.To4() == nil) == preferV6 {
+			preferred = append(preferred, addr)
+		} else if !only {
+			other = append(other, addr)
+		}
+	}
+
+	return append(preferred, other...)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+// privateNetworks are RFC 1918, carrier-grade NAT and IPv6 unique local ranges
+var privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"}
+
+// AddressReport lists private addresses shared by several instances and
+// overlapping CIDRs of networks the instances are in
+type AddressReport struct {
+	DuplicateIPs     []DuplicateIP `json:",omitempty"`
+	OverlappingCIDRs []CIDROverlap `json:",omitempty"`
+}
+
+// DuplicateIP is a private address of several instances
+type DuplicateIP struct {
+	IP        string
+	Instances []InstanceRef
+}
+
+// CIDROverlap is a pair of networks having overlapping CIDRs
+type CIDROverlap struct {
+	A NetworkCIDR
+	B NetworkCIDR
+}
+
+// NetworkCIDR is a CIDR block of the network, e.g. VPC
+type NetworkCIDR struct {
+	Account string `json:",omitempty"`
+	Region  string `json:",omitempty"`
+	Network string
+	CIDR    string
+}
+
+// NetworkDescriber is implemented by discovery sources which could describe
+// CIDRs of networks their instances are in
+type NetworkDescriber interface {
+	Networks(instances []*InstanceInfo) ([]NetworkCIDR, error)
+}
+
+// addressReportEnabled tells if ADDRESS_REPORT is set
+func addressReportEnabled() (bool, error) {
+	value := getEnv("ADDRESS_REPORT", "false")
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Errorf("Invalid ADDRESS_REPORT: '%s'", value)
+	}
+
+	return enabled, nil
+}
+
+// buildAddressReport finds duplicate private addresses of instances and
+// overlapping CIDRs of networks described by discovery sources
+func buildAddressReport(instances []*InstanceInfo) *AddressReport {
+	report := &AddressReport{}
+
+	byIP := map[string][]InstanceRef{}
+	for _, inst := range instances {
+		seen := map[string]bool{}
+		for _, addr := range instancePrivateIPs(inst) {
+			if !seen[addr] {
+				seen[addr] = true
+				byIP[addr] = append(byIP[addr], InstanceRef{InstanceId: inst.id, Name: inst.name})
+			}
+		}
+	}
+	for ip, refs := range byIP {
+		if len(refs) > 1 {
+			report.DuplicateIPs = append(report.DuplicateIPs, DuplicateIP{IP: ip, Instances: refs})
+		}
+	}
+	sort.Slice(report.DuplicateIPs, func(i, j int) bool { return report.DuplicateIPs[i].IP < report.DuplicateIPs[j].IP })
+
+	networks := []NetworkCIDR{}
+	for _, name := range discoveryNames() {
+		source, err := newDiscoverySource(name)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		describer, ok := source.(NetworkDescriber)
+		if !ok {
+			continue
+		}
+
+		found, err := describer.Networks(instances)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "Can't describe networks of '%s' source", name))
+			continue
+		}
+		networks = append(networks, found...)
+	}
+	report.OverlappingCIDRs = overlappingCIDRs(networks)
+
+	log.Printf("Address report: %v duplicate IP(s), %v overlapping CIDR pair(s)", len(report.DuplicateIPs), len(report.OverlappingCIDRs))
+
+	return report
+}
+
+// instancePrivateIPs returns the private address of EC2 instances and
+// private addresses of instances of other sources
+func instancePrivateIPs(inst *InstanceInfo) []string {
+	if inst.description != nil {
+		if addr := aws.StringValue(inst.description.PrivateIpAddress); addr != "" {
+			return []string{addr}
+		}
+		return nil
+	}
+
+	addrs := []string{}
+	for _, addr := range inst.addrs {
+		if ip := net.ParseIP(addr); ip != nil && isPrivateIP(ip) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, cidr := range privateNetworks {
+		if _, network, _ := net.ParseCIDR(cidr); network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// overlappingCIDRs returns pairs of different networks with overlapping CIDRs
+func overlappingCIDRs(networks []NetworkCIDR) []CIDROverlap {
+	overlaps := []CIDROverlap{}
+
+	parsed := make([]*net.IPNet, len(networks))
+	for i, n := range networks {
+		_, parsed[i], _ = net.ParseCIDR(n.CIDR)
+	}
+
+	for i := range networks {
+		for j := i + 1; j < len(networks); j++ {
+			a, b := networks[i], networks[j]
+			if parsed[i] == nil || parsed[j] == nil {
+				continue
+			}
+			if a.Account == b.Account && a.Region == b.Region && a.Network == b.Network {
+				continue
+			}
+			if parsed[i].Contains(parsed[j].IP) || parsed[j].Contains(parsed[i].IP) {
+				overlaps = append(overlaps, CIDROverlap{A: a, B: b})
+			}
+		}
+	}
+
+	return overlaps
+}
+
+// Networks describes CIDR blocks of VPCs of the instances in every target
+func (s *ec2Source) Networks(instances []*InstanceInfo) ([]NetworkCIDR, error) {
+	// instances of the own account are described by targets without account
+	assumed := map[string]bool{}
+	for _, target := range s.targets {
+		assumed[target.account] = target.account != ""
+	}
+
+	vpcs := map[string][]string{}
+	for _, inst := range instances {
+		vpc := ""
+		if inst.description != nil {
+			vpc = aws.StringValue(inst.description.VpcId)
+		}
+		if vpc == "" {
+			continue
+		}
+
+		account := inst.account
+		if !assumed[account] {
+			account = ""
+		}
+		key := account + "/" + inst.region
+		vpcs[key] = appendUnique(vpcs[key], vpc)
+	}
+
+	networks := []NetworkCIDR{}
+	for _, target := range s.targets {
+		ids := vpcs[target.account+"/"+target.region]
+		if len(ids) == 0 {
+			continue
+		}
+
+		out, err := target.svc.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: aws.StringSlice(ids)})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't describe VPCs in %s", target.name())
+		}
+
+		for _, vpc := range out.Vpcs {
+			for _, assoc := range vpc.CidrBlockAssociationSet {
+				if assoc == nil {
+					continue
+				}
+				networks = append(networks, NetworkCIDR{
+					Account: aws.StringValue(vpc.OwnerId),
+					Region:  target.region,
+					Network: aws.StringValue(vpc.VpcId),
+					CIDR:    aws.StringValue(assoc.CidrBlock),
+				})
+			}
+		}
+	}
+
+	return networks, nil
+}
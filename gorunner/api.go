@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pkg/errors"
+)
+
+// jsonResponse serializes v into the API response
+func jsonResponse(statusCode int, v interface{}) (Response, error) {
+	body, err := marshalOutput(v)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		StatusCode:      statusCode,
+		IsBase64Encoded: false,
+		Body:            string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}, nil
+}
+
+// textResponse returns rendered body with the given content type
+func textResponse(body, contentType string) Response {
+	return Response{
+		StatusCode:      200,
+		IsBase64Encoded: false,
+		Body:            body,
+		Headers: map[string]string{
+			"Content-Type": contentType,
+		},
+	}
+}
+
+// errorResponse returns the error message to the API caller
+func errorResponse(statusCode int, err error) (Response, error) {
+	return jsonResponse(statusCode, struct{ Error string }{err.Error()})
+}
+
+// handleGetRun serves GET /runs/{id}
+func handleGetRun(request events.APIGatewayProxyRequest) (Response, error) {
+	id := request.PathParameters["id"]
+
+	run, err := mergeRun(id)
+	if err == errRunNotFound {
+		return errorResponse(404, errors.Errorf("Run not found: %s", id))
+	}
+	if err == errHistoryDisabled {
+		return errorResponse(404, err)
+	}
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	return jsonResponse(200, run)
+}
+
+// handleRunDiff serves GET /runs/{idA}/diff/{idB}
+func handleRunDiff(request events.APIGatewayProxyRequest) (Response, error) {
+	runs := []*RunResult{}
+	for _, param := range []string{"idA", "idB"} {
+		run, err := loadRun(request.PathParameters[param])
+		if err == errRunNotFound {
+			return errorResponse(404, errors.Errorf("Run not found: %s", request.PathParameters[param]))
+		}
+		if err == errHistoryDisabled {
+			return errorResponse(404, err)
+		}
+		if err != nil {
+			return errorResponse(500, err)
+		}
+
+		runs = append(runs, run)
+	}
+
+	return jsonResponse(200, diffRuns(runs[0], runs[1]))
+}
+
+// handleInstanceFacts serves GET /instances/{id}/facts: facts are collected
+// from the single instance without sweeping the fleet
+func (h *Handler) handleInstanceFacts(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	id := request.PathParameters["id"]
+
+	res, err := h.deps.Worker(ctx, RunOptions{InstanceIDs: []string{id}})
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	if len(res.Rows) == 0 {
+		return errorResponse(404, errors.Errorf("Instance not found: %s", id))
+	}
+
+	return jsonResponse(200, res.Rows[0])
+}
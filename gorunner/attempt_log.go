@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultAttemptLog = "summary"
+
+// attemptLog collects connection attempts of a single host. Every attempt is
+// logged with ATTEMPT_LOG=all, otherwise a single summary line is logged per host.
+type attemptLog struct {
+	id      string
+	verbose bool
+
+	dials    int
+	failures map[string]int
+}
+
+func newAttemptLog(id string, verbose bool) *attemptLog {
+	return &attemptLog{id: id, verbose: verbose, failures: map[string]int{}}
+}
+
+// verboseAttemptLog tells if every attempt should be logged
+func verboseAttemptLog() (bool, error) {
+	switch mode := getEnv("ATTEMPT_LOG", defaultAttemptLog); mode {
+	case "summary":
+		return false, nil
+	case "all":
+		return true, nil
+	default:
+		return false, errors.Errorf("Unknown ATTEMPT_LOG: '%s' (available: summary, all)", mode)
+	}
+}
+
+func (l *attemptLog) trying(conStr string) {
+	l.dials++
+	if l.verbose {
+		log.Printf("Trying %s... \n", conStr)
+	}
+}
+
+// failed aggregates errors by their text without the address specific prefix
+func (l *attemptLog) failed(conStr string, err error) {
+	if l.verbose {
+		log.Println(errors.Wrap(err, "Failed to connect "+conStr))
+		return
+	}
+
+	l.failures[conStr+": "+err.Error()]++
+}
+
+func (l *attemptLog) found(conStr string, facts map[string]string) {
+	if l.verbose {
+		log.Printf("...[%s] found facts: %v", conStr, facts)
+	}
+}
+
+// flush logs the result of the attempt
+func (l *attemptLog) flush(conStr string, facts map[string]string, err error) {
+	if l.verbose {
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	failures := []string{}
+	for failure, count := range l.failures {
+		if count > 1 {
+			failure = fmt.Sprintf("%s (x%d)", failure, count)
+		}
+		failures = append(failures, failure)
+	}
+	sort.Strings(failures)
+
+	line := fmt.Sprintf("[%s] %d dial(s)", l.id, l.dials)
+	if len(failures) > 0 {
+		line += ", failed: " + strings.Join(failures, "; ")
+	}
+	if conStr != "" {
+		line += fmt.Sprintf(", connected as %s, %d fact(s)", conStr, len(facts))
+	}
+	if err != nil {
+		line += ", error: " + err.Error()
+	}
+
+	log.Println(line)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCandidateSample = "10"
+
+	// candidatePrefix separates candidate fact commands from production ones
+	candidatePrefix = "candidate:"
+
+	variantProduction = "production"
+	variantCandidate  = "candidate"
+)
+
+// candidateRollout runs CANDIDATE_FACTS on CANDIDATE_SAMPLE percent of instances
+// along with production facts
+type candidateRollout struct {
+	facts  map[string]string
+	sample int
+}
+
+// getCandidateRollout returns nil when CANDIDATE_FACTS are not set
+func getCandidateRollout() (*candidateRollout, error) {
+	value := getEnv("CANDIDATE_FACTS", "")
+	if value == "" {
+		return nil, nil
+	}
+
+	r := &candidateRollout{facts: map[string]string{}}
+	if err := json.Unmarshal([]byte(value), &r.facts); err != nil {
+		return nil, errors.Wrap(err, "Can't parse CANDIDATE_FACTS")
+	}
+
+	sample, err := strconv.Atoi(getEnv("CANDIDATE_SAMPLE", defaultCandidateSample))
+	if err != nil || sample < 0 || sample > 100 {
+		return nil, errors.Errorf("Invalid CANDIDATE_SAMPLE: '%s' (should be a percent)", getEnv("CANDIDATE_SAMPLE", ""))
+	}
+	r.sample = sample
+
+	return r, nil
+}
+
+// assign labels every instance with its variant. Sampling is based on
+// instance id hash, so the same instances are picked by every run.
+func (r *candidateRollout) assign(instances []*InstanceInfo) {
+	for _, inst := range instances {
+		inst.variant = variantProduction
+		inst.candidateFacts = nil
+
+		h := fnv.New32a()
+		h.Write([]byte(inst.id))
+		if int(h.Sum32()%100) < r.sample {
+			inst.variant = variantCandidate
+			inst.candidateFacts = r.facts
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAccountsMinUID = "1000"
+	defaultAccountsMaxUID = "60000"
+
+	// accountsSeparator splits passwd and group databases in the output
+	accountsSeparator = "== group"
+)
+
+// Account is a local OS user with its group membership
+type Account struct {
+	Name   string
+	UID    int
+	GID    int
+	Home   string
+	Shell  string
+	Groups []string
+}
+
+// accountsCollector lists OS users with UID in ACCOUNTS_MIN_UID..ACCOUNTS_MAX_UID range
+type accountsCollector struct {
+	minUID int
+	maxUID int
+}
+
+func newAccountsCollector() (Collector, error) {
+	minUID, err := strconv.Atoi(getEnv("ACCOUNTS_MIN_UID", defaultAccountsMinUID))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid ACCOUNTS_MIN_UID")
+	}
+
+	maxUID, err := strconv.Atoi(getEnv("ACCOUNTS_MAX_UID", defaultAccountsMaxUID))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid ACCOUNTS_MAX_UID")
+	}
+
+	return &accountsCollector{minUID: minUID, maxUID: maxUID}, nil
+}
+
+// Command prefers getent to include accounts from NSS sources other than files
+func (c *accountsCollector) Command() string {
+	return `getent passwd 2>/dev/null || cat /etc/passwd; echo "` + accountsSeparator + `"; getent group 2>/dev/null || cat /etc/group`
+}
+
+func (c *accountsCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	accounts := []*Account{}
+	byName := map[string]*Account{}
+	groupNames := map[int]string{}
+	members := map[string][]string{}
+
+	inGroups := false
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == accountsSeparator {
+			inGroups = true
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+
+		if inGroups {
+			// name:password:GID:member1,member2
+			if len(fields) != 4 {
+				return nil, errors.Errorf("Unexpected group entry: '%s'", line)
+			}
+
+			gid, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid GID of group '%s'", fields[0])
+			}
+			groupNames[gid] = fields[0]
+
+			for _, member := range strings.Split(fields[3], ",") {
+				if member != "" {
+					members[member] = append(members[member], fields[0])
+				}
+			}
+			continue
+		}
+
+		// name:password:UID:GID:GECOS:home:shell
+		if len(fields) != 7 {
+			return nil, errors.Errorf("Unexpected passwd entry: '%s'", line)
+		}
+
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid UID of user '%s'", fields[0])
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid GID of user '%s'", fields[0])
+		}
+
+		if uid < c.minUID || uid > c.maxUID || byName[fields[0]] != nil {
+			continue
+		}
+
+		account := &Account{Name: fields[0], UID: uid, GID: gid, Home: fields[5], Shell: fields[6]}
+		accounts = append(accounts, account)
+		byName[account.Name] = account
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := []Account{}
+	for _, account := range accounts {
+		groups := map[string]bool{}
+		if name, ok := groupNames[account.GID]; ok {
+			groups[name] = true
+		}
+		for _, name := range members[account.Name] {
+			groups[name] = true
+		}
+
+		account.Groups = []string{}
+		for name := range groups {
+			account.Groups = append(account.Groups, name)
+		}
+		sort.Strings(account.Groups)
+
+		result = append(result, *account)
+	}
+
+	return result, nil
+}
+
+// Summarize counts accounts across the fleet
+func (c *accountsCollector) Summarize(results []interface{}) map[string]int {
+	count := 0
+	for _, res := range results {
+		if accounts, ok := res.([]Account); ok {
+			count += len(accounts)
+		}
+	}
+
+	return map[string]int{"accounts": count}
+}
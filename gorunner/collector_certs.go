@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCertWarnDays = "30"
+	certDateLayout      = "Jan _2 15:04:05 2006 MST"
+)
+
+// CertInfo describes a certificate found on the remote host
+type CertInfo struct {
+	Source   string
+	Subject  string
+	NotAfter time.Time
+	DaysLeft int
+	Expiring bool
+}
+
+// certCollector inspects certificate files and local TLS ports
+type certCollector struct {
+	paths    []string
+	ports    []int
+	warnDays int
+}
+
+func newCertCollector() (Collector, error) {
+	c := &certCollector{}
+
+	for _, path := range strings.Split(getEnv("CERT_PATHS", ""), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			c.paths = append(c.paths, path)
+		}
+	}
+
+	for _, port := range strings.Split(getEnv("CERT_PORTS", ""), ",") {
+		if port = strings.TrimSpace(port); port == "" {
+			continue
+		}
+
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return nil, errors.Errorf("Invalid port in CERT_PORTS: '%s'", port)
+		}
+		c.ports = append(c.ports, p)
+	}
+
+	if len(c.paths) == 0 && len(c.ports) == 0 {
+		return nil, errors.Errorf("You should provide CERT_PATHS or CERT_PORTS")
+	}
+
+	warnDays, err := strconv.Atoi(getEnv("CERT_WARN_DAYS", defaultCertWarnDays))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid CERT_WARN_DAYS")
+	}
+	c.warnDays = warnDays
+
+	return c, nil
+}
+
+// Command prints a header line followed by subject and expiry for every certificate.
+// Paths are left unquoted on purpose to allow shell globs.
+func (c *certCollector) Command() string {
+	parts := []string{}
+
+	for _, path := range c.paths {
+		parts = append(parts, fmt.Sprintf(
+			`for f in %s; do [ -f "$f" ] && echo "== $f" && openssl x509 -noout -subject -enddate -in "$f" 2>/dev/null; done`,
+			path))
+	}
+
+	for _, port := range c.ports {
+		parts = append(parts, fmt.Sprintf(
+			`echo "== 127.0.0.1:%[1]d"; echo | timeout 5 openssl s_client -connect 127.0.0.1:%[1]d 2>/dev/null | openssl x509 -noout -subject -enddate 2>/dev/null`,
+			port))
+	}
+
+	return strings.Join(parts, "; ") + "; true"
+}
+
+// Parse reads blocks produced by Command
+func (c *certCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	certs := []CertInfo{}
+	now := time.Now()
+
+	var cert *CertInfo
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "== "):
+			cert = &CertInfo{Source: strings.TrimPrefix(line, "== ")}
+		case cert == nil:
+			continue
+		case strings.HasPrefix(line, "subject="):
+			cert.Subject = strings.TrimSpace(strings.TrimPrefix(line, "subject="))
+		case strings.HasPrefix(line, "notAfter="):
+			notAfter, err := time.Parse(certDateLayout, strings.TrimPrefix(line, "notAfter="))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Can't parse expiry date of %s", cert.Source)
+			}
+
+			cert.NotAfter = notAfter
+			cert.DaysLeft = int(notAfter.Sub(now).Hours() / 24)
+			cert.Expiring = cert.DaysLeft < c.warnDays
+			certs = append(certs, *cert)
+			cert = nil
+		}
+	}
+
+	return certs, scanner.Err()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCloudInitLogBytes = "4096"
+
+	// cloudInitOutputLog keeps output of user-data scripts and other modules
+	cloudInitOutputLog = "/var/log/cloud-init-output.log"
+
+	cloudInitStatusSection = "== status"
+	cloudInitResultSection = "== result"
+	cloudInitBootSection   = "== boot-finished"
+	cloudInitLogSection    = "== log"
+)
+
+// CloudInitStatus tells how the instance was bootstrapped by cloud-init
+type CloudInitStatus struct {
+	Installed bool
+	// Status is reported by `cloud-init status`: done, running, error, degraded, disabled or not run
+	Status     string
+	Datasource string   `json:",omitempty"`
+	Errors     []string `json:",omitempty"`
+	// BootFinished is the content of boot-finished marker: uptime, time and version
+	BootFinished string `json:",omitempty"`
+	Failed       bool
+	// LogTail is the end of user-data output log capped at CLOUDINIT_LOG_BYTES,
+	// LogSize is the size of the whole log
+	LogTail      string `json:",omitempty"`
+	LogSize      int64  `json:",omitempty"`
+	LogTruncated bool   `json:",omitempty"`
+}
+
+// cloudInitResult is /run/cloud-init/result.json written once cloud-init finishes
+type cloudInitResult struct {
+	V1 struct {
+		Datasource string   `json:"datasource"`
+		Errors     []string `json:"errors"`
+	} `json:"v1"`
+}
+
+// cloudInitCollector reports cloud-init status, its result and the tail
+// of user-data output log (read with sudo -n if the login user can't read it)
+type cloudInitCollector struct {
+	logBytes int
+}
+
+func newCloudInitCollector() (Collector, error) {
+	logBytes, err := strconv.Atoi(getEnv("CLOUDINIT_LOG_BYTES", defaultCloudInitLogBytes))
+	if err != nil || logBytes < 0 {
+		return nil, errors.Errorf("Invalid CLOUDINIT_LOG_BYTES: '%s'", getEnv("CLOUDINIT_LOG_BYTES", ""))
+	}
+
+	return &cloudInitCollector{logBytes: logBytes}, nil
+}
+
+// Command prints sections of status, result, boot marker and the log: its size
+// followed by the tail, the log section is the last one since it's printed as is
+func (c *cloudInitCollector) Command() string {
+	cmd := `echo "` + cloudInitStatusSection + `"; ` +
+		`if command -v cloud-init >/dev/null 2>&1; then cloud-init status --long 2>&1 || true; else echo "status: not installed"; fi; ` +
+		`echo "` + cloudInitResultSection + `"; cat /run/cloud-init/result.json 2>/dev/null; echo; ` +
+		`echo "` + cloudInitBootSection + `"; cat /var/lib/cloud/instance/boot-finished 2>/dev/null; echo`
+	if c.logBytes == 0 {
+		return cmd
+	}
+
+	return cmd + fmt.Sprintf(`; echo "%s"; f=%s; `+
+		`if [ -r "$f" ]; then stat -c %%s "$f"; tail -c %d "$f"; `+
+		`else sudo -n stat -c %%s "$f" 2>/dev/null && sudo -n tail -c %d "$f"; fi; true`,
+		cloudInitLogSection, cloudInitOutputLog, c.logBytes, c.logBytes)
+}
+
+func (c *cloudInitCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	sections := map[string][]string{}
+	section := ""
+	logTail := ""
+
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch trimmed {
+		case cloudInitStatusSection, cloudInitResultSection, cloudInitBootSection:
+			section = trimmed
+			continue
+		case cloudInitLogSection:
+			section = trimmed
+			logTail = strings.Join(lines[i+1:], "\n")
+		}
+		if section == cloudInitLogSection {
+			break
+		}
+		if section != "" {
+			sections[section] = append(sections[section], line)
+		}
+	}
+
+	if _, ok := sections[cloudInitStatusSection]; !ok {
+		return nil, errors.Errorf("Unexpected output: '%s'", out)
+	}
+
+	status := CloudInitStatus{}
+	parseCloudInitStatus(sections[cloudInitStatusSection], &status)
+
+	if value := strings.TrimSpace(strings.Join(sections[cloudInitResultSection], "\n")); value != "" {
+		result := cloudInitResult{}
+		if err := json.Unmarshal([]byte(value), &result); err != nil {
+			return nil, errors.Wrap(err, "Can't parse cloud-init result.json")
+		}
+		status.Datasource = result.V1.Datasource
+		// result.json lists the same errors as `status --long` of recent versions
+		if len(result.V1.Errors) > 0 {
+			status.Errors = result.V1.Errors
+		}
+	}
+
+	status.BootFinished = strings.TrimSpace(strings.Join(sections[cloudInitBootSection], "\n"))
+	status.Failed = status.Status == "error" || status.Status == "degraded" || len(status.Errors) > 0
+
+	c.parseLog(logTail, &status)
+
+	return status, nil
+}
+
+// parseCloudInitStatus reads `cloud-init status --long` output, the status
+// is kept as is for versions without --long
+func parseCloudInitStatus(lines []string, status *CloudInitStatus) {
+	inErrors := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		kv := strings.SplitN(trimmed, ":", 2)
+
+		switch {
+		case len(kv) == 2 && kv[0] == "status":
+			status.Status = strings.TrimSpace(kv[1])
+		case len(kv) == 2 && kv[0] == "errors":
+			inErrors = true
+		case inErrors && strings.HasPrefix(trimmed, "- "):
+			status.Errors = append(status.Errors, strings.TrimPrefix(trimmed, "- "))
+		case len(kv) == 2 && !strings.HasPrefix(line, " "):
+			inErrors = false
+		}
+	}
+
+	status.Installed = status.Status != "" && status.Status != "not installed"
+	if status.Status == "" {
+		status.Status = "unknown"
+	}
+}
+
+// parseLog reads the log size and its tail. Truncated tails start
+// at the first complete line.
+func (c *cloudInitCollector) parseLog(out string, status *CloudInitStatus) {
+	parts := strings.SplitN(strings.TrimLeft(out, "\n"), "\n", 2)
+	size, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return
+	}
+
+	status.LogSize = size
+	if len(parts) < 2 {
+		return
+	}
+
+	tail := parts[1]
+	if size > int64(c.logBytes) {
+		status.LogTruncated = true
+		if i := strings.Index(tail, "\n"); i >= 0 {
+			tail = tail[i+1:]
+		}
+	}
+	status.LogTail = strings.TrimRight(strings.ToValidUTF8(tail, ""), "\n")
+}
+
+// Summarize counts hosts which failed or are still running bootstrap
+func (c *cloudInitCollector) Summarize(results []interface{}) map[string]int {
+	failed, running := 0, 0
+	for _, res := range results {
+		status, ok := res.(CloudInitStatus)
+		switch {
+		case !ok:
+		case status.Failed:
+			failed++
+		case status.Status == "running":
+			running++
+		}
+	}
+
+	return map[string]int{"cloud_init_failed": failed, "cloud_init_running": running}
+}
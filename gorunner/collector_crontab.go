@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cronSystemSource = "system"
+	cronUserSource   = "user"
+	cronTimersSource = "timers"
+
+	// cronSpoolFind lists crontabs of users: /var/spool/cron/<user> (RHEL) or /var/spool/cron/crontabs/<user> (Debian)
+	cronSpoolFind = `/var/spool/cron -type f -not -path '/var/spool/cron/atjobs/*' -not -path '/var/spool/cron/atspool/*'`
+)
+
+// ScheduledTask is a crontab entry or systemd timer
+type ScheduledTask struct {
+	Source string
+	// Type is cron or timer
+	Type     string
+	User     string `json:",omitempty"`
+	Schedule string `json:",omitempty"`
+	// Command is the cron command or the unit activated by the timer
+	Command string
+	Unit    string `json:",omitempty"`
+}
+
+// crontabCollector enumerates /etc/crontab, /etc/cron.d, crontabs of users and systemd timers.
+// Crontabs are read with non-interactive sudo when the login user can't read them.
+type crontabCollector struct{}
+
+func newCrontabCollector() (Collector, error) {
+	return &crontabCollector{}, nil
+}
+
+func (c *crontabCollector) Command() string {
+	return `for f in /etc/crontab /etc/cron.d/*; do [ -f "$f" ] || continue; echo "== ` + cronSystemSource + ` $f"; ` +
+		`cat "$f" 2>/dev/null || sudo -n cat "$f" 2>/dev/null; done; ` +
+		`for f in $(sudo -n find ` + cronSpoolFind + ` 2>/dev/null || find ` + cronSpoolFind + ` 2>/dev/null); do ` +
+		`echo "== ` + cronUserSource + ` $f"; cat "$f" 2>/dev/null || sudo -n cat "$f" 2>/dev/null; done; ` +
+		`echo "== ` + cronTimersSource + `"; systemctl list-timers --all --no-pager --no-legend 2>/dev/null; true`
+}
+
+func (c *crontabCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	tasks := []ScheduledTask{}
+	kind, source := "", ""
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "== ") {
+			fields := strings.SplitN(strings.TrimPrefix(line, "== "), " ", 2)
+			kind, source = fields[0], ""
+			if len(fields) == 2 {
+				source = fields[1]
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || kind == "" {
+			continue
+		}
+
+		switch kind {
+		case cronSystemSource, cronUserSource:
+			user := ""
+			if kind == cronUserSource {
+				user = path.Base(source)
+			}
+
+			if task, ok := parseCronLine(line, user); ok {
+				task.Source = source
+				tasks = append(tasks, task)
+			}
+		case cronTimersSource:
+			// NEXT LEFT LAST PASSED UNIT ACTIVATES, dates contain spaces
+			fields := strings.Fields(line)
+			if len(fields) < 2 || !strings.HasSuffix(fields[len(fields)-2], ".timer") {
+				continue
+			}
+			tasks = append(tasks, ScheduledTask{
+				Source:  cronTimersSource,
+				Type:    "timer",
+				Unit:    fields[len(fields)-2],
+				Command: fields[len(fields)-1],
+			})
+		default:
+			return nil, errors.Errorf("Unexpected output section: '%s'", kind)
+		}
+	}
+
+	return tasks, scanner.Err()
+}
+
+// parseCronLine parses "schedule [user] command", the user column is present in
+// system crontabs only (user is empty). Variable assignments are skipped.
+func parseCronLine(line, user string) (ScheduledTask, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.Contains(fields[0], "=") {
+		return ScheduledTask{}, false
+	}
+
+	scheduleFields := 5
+	if strings.HasPrefix(fields[0], "@") {
+		scheduleFields = 1
+	}
+
+	rest := scheduleFields
+	if user == "" {
+		rest++
+	}
+	if len(fields) <= rest {
+		return ScheduledTask{}, false
+	}
+
+	task := ScheduledTask{
+		Type:     "cron",
+		User:     user,
+		Schedule: strings.Join(fields[:scheduleFields], " "),
+	}
+	if user == "" {
+		task.User = fields[scheduleFields]
+	}
+
+	// keep the command as is, it may contain repeated spaces
+	command := line
+	for i := 0; i < rest; i++ {
+		command = strings.TrimSpace(command)
+		command = strings.TrimSpace(command[len(fields[i]):])
+	}
+	task.Command = command
+
+	return task, true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerInfoSection       = "info"
+	dockerContainersSection = "containers"
+)
+
+// DockerHost describes the Docker daemon and its containers, e.g. of ECS container instances
+type DockerHost struct {
+	ServerVersion     string
+	StorageDriver     string
+	CgroupDriver      string
+	Images            int
+	ContainersRunning int
+	Containers        []DockerContainer
+}
+
+// DockerContainer is a container reported by docker ps
+type DockerContainer struct {
+	ID     string
+	Image  string
+	Names  string
+	State  string `json:",omitempty"`
+	Status string
+}
+
+// dockerCollector reports the Docker daemon and all containers.
+// docker is run with non-interactive sudo when the login user isn't in the docker group.
+type dockerCollector struct{}
+
+func newDockerCollector() (Collector, error) {
+	return &dockerCollector{}, nil
+}
+
+func (c *dockerCollector) Command() string {
+	return `d() { docker "$@" 2>/dev/null || sudo -n docker "$@" 2>/dev/null; }; ` +
+		`echo "== ` + dockerInfoSection + `"; d info --format '{{json .}}'; ` +
+		`echo "== ` + dockerContainersSection + `"; d ps --all --no-trunc --format '{{json .}}'; true`
+}
+
+func (c *dockerCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	host := DockerHost{Containers: []DockerContainer{}}
+	section, found := "", false
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "== ") {
+			section = strings.TrimPrefix(line, "== ")
+			continue
+		}
+		if line == "" {
+			continue
+		}
+
+		switch section {
+		case dockerInfoSection:
+			info := struct {
+				ServerVersion     string
+				Driver            string
+				CgroupDriver      string
+				Images            int
+				ContainersRunning int
+			}{}
+			if err := json.Unmarshal([]byte(line), &info); err != nil {
+				return nil, errors.Wrap(err, "Unexpected docker info output")
+			}
+
+			found = true
+			host.ServerVersion = info.ServerVersion
+			host.StorageDriver = info.Driver
+			host.CgroupDriver = info.CgroupDriver
+			host.Images = info.Images
+			host.ContainersRunning = info.ContainersRunning
+		case dockerContainersSection:
+			container := DockerContainer{}
+			if err := json.Unmarshal([]byte(line), &container); err != nil {
+				return nil, errors.Wrap(err, "Unexpected docker ps output")
+			}
+			host.Containers = append(host.Containers, container)
+		default:
+			return nil, errors.Errorf("Unexpected output section: '%s'", section)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, errors.Errorf("Docker is not available")
+	}
+
+	return host, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHostnameTag       = "Name"
+	defaultHostnameNormalize = "lower,short"
+)
+
+// hostnameNormalizers are options of HOSTNAME_NORMALIZE applied to both names
+var hostnameNormalizers = map[string]func(string) string{
+	// lower ignores the case
+	"lower": strings.ToLower,
+	// short drops the domain
+	"short": func(name string) string { return strings.SplitN(name, ".", 2)[0] },
+	// alnum drops punctuation, so web_01 matches web-01
+	"alnum": func(name string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, name)
+	},
+}
+
+// HostnameCheck compares the remote hostname with the tag of the instance
+type HostnameCheck struct {
+	Hostname string
+	// Expected is the value of HOSTNAME_TAG, empty if the instance has no tag
+	Expected string
+	Match    bool
+}
+
+// hostnameCollector flags hosts whose hostname drifted from the Name tag
+type hostnameCollector struct {
+	tag         string
+	normalizers []func(string) string
+}
+
+func newHostnameCollector() (Collector, error) {
+	c := &hostnameCollector{tag: getEnv("HOSTNAME_TAG", defaultHostnameTag)}
+
+	for _, name := range splitList(getEnv("HOSTNAME_NORMALIZE", defaultHostnameNormalize)) {
+		normalize, ok := hostnameNormalizers[name]
+		if !ok {
+			return nil, errors.Errorf("Unknown HOSTNAME_NORMALIZE option: '%s' (available: alnum, lower, short)", name)
+		}
+		c.normalizers = append(c.normalizers, normalize)
+	}
+
+	return c, nil
+}
+
+func (c *hostnameCollector) Command() string {
+	return "hostname -f 2>/dev/null || hostname"
+}
+
+func (c *hostnameCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	check := HostnameCheck{
+		Hostname: strings.TrimSpace(out),
+		Expected: instance.tags[c.tag],
+	}
+	if check.Hostname == "" {
+		return nil, errors.Errorf("Empty hostname")
+	}
+
+	check.Match = check.Expected == "" || c.normalize(check.Hostname) == c.normalize(check.Expected)
+
+	return check, nil
+}
+
+func (c *hostnameCollector) normalize(name string) string {
+	for _, normalize := range c.normalizers {
+		name = normalize(name)
+	}
+
+	return name
+}
+
+// Summarize counts hosts whose hostname doesn't match the tag
+func (c *hostnameCollector) Summarize(results []interface{}) map[string]int {
+	count := 0
+	for _, res := range results {
+		if check, ok := res.(HostnameCheck); ok && !check.Match {
+			count++
+		}
+	}
+
+	return map[string]int{"hostname_mismatch": count}
+}
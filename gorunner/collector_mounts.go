@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMountHardenedPaths   = "/tmp,/var/tmp,/dev/shm"
+	defaultMountRequiredOptions = "noexec,nosuid,nodev"
+
+	// mountsSeparator splits /proc/mounts and lsblk output
+	mountsSeparator = "== lsblk"
+)
+
+// pseudoFilesystems are not reported as mounts
+var pseudoFilesystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true,
+	"configfs": true, "debugfs": true, "devpts": true, "fusectl": true, "hugetlbfs": true,
+	"mqueue": true, "proc": true, "pstore": true, "rpc_pipefs": true, "securityfs": true,
+	"selinuxfs": true, "sysfs": true, "tracefs": true,
+}
+
+// Mount is a mounted filesystem. MissingOptions lists hardening options
+// required for the mount point but not set.
+type Mount struct {
+	Device         string
+	MountPoint     string
+	Type           string
+	Options        []string
+	Size           int64    `json:",omitempty"`
+	MissingOptions []string `json:",omitempty"`
+}
+
+// BlockDevice is a disk or partition reported by lsblk
+type BlockDevice struct {
+	Name       string
+	Type       string
+	Size       int64
+	FSType     string `json:",omitempty"`
+	MountPoint string `json:",omitempty"`
+	Parent     string `json:",omitempty"`
+}
+
+// Filesystems contains mounts and block devices of the host
+type Filesystems struct {
+	Mounts  []Mount
+	Devices []BlockDevice
+}
+
+// mountsCollector inventories mounts and checks that MOUNT_HARDENED_PATHS
+// are mounted with MOUNT_REQUIRED_OPTIONS
+type mountsCollector struct {
+	hardenedPaths   map[string]bool
+	requiredOptions []string
+}
+
+func newMountsCollector() (Collector, error) {
+	c := &mountsCollector{hardenedPaths: map[string]bool{}}
+
+	for _, path := range strings.Split(getEnv("MOUNT_HARDENED_PATHS", defaultMountHardenedPaths), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			c.hardenedPaths[path] = true
+		}
+	}
+
+	for _, opt := range strings.Split(getEnv("MOUNT_REQUIRED_OPTIONS", defaultMountRequiredOptions), ",") {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			c.requiredOptions = append(c.requiredOptions, opt)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *mountsCollector) Command() string {
+	return `cat /proc/mounts; echo "` + mountsSeparator + `"; lsblk -J -b -o NAME,TYPE,SIZE,FSTYPE,MOUNTPOINT 2>/dev/null; true`
+}
+
+func (c *mountsCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	parts := strings.SplitN(out, mountsSeparator, 2)
+
+	fs := Filesystems{Mounts: []Mount{}, Devices: []BlockDevice{}}
+	if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+		devices, err := parseLsblk(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		fs.Devices = devices
+	}
+
+	sizes := map[string]int64{}
+	for _, dev := range fs.Devices {
+		sizes["/dev/"+dev.Name] = dev.Size
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(parts[0]))
+	for scanner.Scan() {
+		// device mountpoint type options dump pass
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if pseudoFilesystems[fields[2]] {
+			continue
+		}
+
+		mount := Mount{
+			Device:     fields[0],
+			MountPoint: unescapeMountPath(fields[1]),
+			Type:       fields[2],
+			Options:    strings.Split(fields[3], ","),
+			Size:       sizes[fields[0]],
+		}
+		if c.hardenedPaths[mount.MountPoint] {
+			mount.MissingOptions = missingOptions(mount.Options, c.requiredOptions)
+		}
+
+		fs.Mounts = append(fs.Mounts, mount)
+	}
+
+	return fs, scanner.Err()
+}
+
+// Summarize counts hardened mount points missing required options
+func (c *mountsCollector) Summarize(results []interface{}) map[string]int {
+	count := 0
+	for _, res := range results {
+		fs, ok := res.(Filesystems)
+		if !ok {
+			continue
+		}
+
+		for _, mount := range fs.Mounts {
+			if len(mount.MissingOptions) > 0 {
+				count++
+			}
+		}
+	}
+
+	return map[string]int{"insecure_mounts": count}
+}
+
+func missingOptions(options, required []string) []string {
+	set := map[string]bool{}
+	for _, opt := range options {
+		set[opt] = true
+	}
+
+	missing := []string{}
+	for _, opt := range required {
+		if !set[opt] {
+			missing = append(missing, opt)
+		}
+	}
+
+	return missing
+}
+
+// unescapeMountPath decodes octal escapes used by /proc/mounts for spaces and tabs
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+
+	if unquoted, err := strconv.Unquote(`"` + path + `"`); err == nil {
+		return unquoted
+	}
+
+	return path
+}
+
+// lsblkDevice matches lsblk -J output, SIZE is a string in older versions
+type lsblkDevice struct {
+	Name       string        `json:"name"`
+	Type       string        `json:"type"`
+	Size       interface{}   `json:"size"`
+	FSType     string        `json:"fstype"`
+	MountPoint string        `json:"mountpoint"`
+	Children   []lsblkDevice `json:"children"`
+}
+
+func parseLsblk(out string) ([]BlockDevice, error) {
+	tree := struct {
+		BlockDevices []lsblkDevice `json:"blockdevices"`
+	}{}
+	if err := json.Unmarshal([]byte(out), &tree); err != nil {
+		return nil, errors.Wrap(err, "Can't parse lsblk output")
+	}
+
+	devices := []BlockDevice{}
+
+	var walk func(devs []lsblkDevice, parent string)
+	walk = func(devs []lsblkDevice, parent string) {
+		for _, dev := range devs {
+			size := int64(0)
+			switch v := dev.Size.(type) {
+			case float64:
+				size = int64(v)
+			case string:
+				size, _ = strconv.ParseInt(v, 10, 64)
+			}
+
+			devices = append(devices, BlockDevice{
+				Name:       dev.Name,
+				Type:       dev.Type,
+				Size:       size,
+				FSType:     dev.FSType,
+				MountPoint: dev.MountPoint,
+				Parent:     parent,
+			})
+			walk(dev.Children, dev.Name)
+		}
+	}
+	walk(tree.BlockDevices, "")
+
+	return devices, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RebootStatus tells if the remote host is waiting for reboot
+type RebootStatus struct {
+	Required bool
+	Method   string
+}
+
+// rebootCollector detects pending reboots across distro families:
+// needs-restarting (RHEL/CentOS/Amazon), zypper (SUSE) and /var/run/reboot-required (Debian/Ubuntu)
+type rebootCollector struct{}
+
+func newRebootCollector() (Collector, error) {
+	return &rebootCollector{}, nil
+}
+
+func (c *rebootCollector) Command() string {
+	return `if [ -e /var/run/reboot-required ]; then echo "reboot-required 1"; ` +
+		`elif command -v needs-restarting >/dev/null 2>&1; then needs-restarting -r >/dev/null 2>&1; echo "needs-restarting $?"; ` +
+		`elif command -v zypper >/dev/null 2>&1; then zypper -q needs-rebooting >/dev/null 2>&1; echo "zypper $?"; ` +
+		`else echo "reboot-required 0"; fi`
+}
+
+func (c *rebootCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return nil, errors.Errorf("Unexpected output: '%s'", out)
+	}
+
+	method, code := fields[0], fields[1]
+
+	var required bool
+	switch {
+	case method == "reboot-required" && code == "1":
+		required = true
+	case method == "needs-restarting" && code == "1":
+		required = true
+	case method == "zypper" && code == "102":
+		required = true
+	case code != "0":
+		return nil, errors.Errorf("%s failed with exit code %s", method, code)
+	}
+
+	return RebootStatus{Required: required, Method: method}, nil
+}
+
+// Summarize counts hosts waiting for reboot
+func (c *rebootCollector) Summarize(results []interface{}) map[string]int {
+	count := 0
+	for _, res := range results {
+		if status, ok := res.(RebootStatus); ok && status.Required {
+			count++
+		}
+	}
+
+	return map[string]int{"reboot_required": count}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+)
+
+// sudoListSource marks rules of the login user reported by sudo -l
+const sudoListSource = "sudo -l"
+
+// SudoRule is a privilege specification from sudoers
+type SudoRule struct {
+	Source string
+	// Principal is a user, %group or alias the rule is granted to
+	Principal  string
+	Hosts      string `json:",omitempty"`
+	RunAs      string `json:",omitempty"`
+	NoPassword bool
+	Commands   []string
+}
+
+// sudoersCollector parses /etc/sudoers, /etc/sudoers.d and sudo -l output of the login user.
+// Sudoers files are read with non-interactive sudo when the login user can't read them.
+type sudoersCollector struct{}
+
+func newSudoersCollector() (Collector, error) {
+	return &sudoersCollector{}, nil
+}
+
+func (c *sudoersCollector) Command() string {
+	return `for f in /etc/sudoers /etc/sudoers.d/*; do [ -f "$f" ] || continue; echo "== $f"; ` +
+		`cat "$f" 2>/dev/null || sudo -n cat "$f" 2>/dev/null; done; ` +
+		`echo "== ` + sudoListSource + `"; sudo -n -l 2>/dev/null; true`
+}
+
+func (c *sudoersCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	rules := []SudoRule{}
+	source := ""
+	listing := false
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "== ") {
+			source = strings.TrimPrefix(line, "== ")
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || source == "" {
+			continue
+		}
+
+		if source == sudoListSource {
+			// rules follow "User x may run the following commands on y:"
+			if strings.Contains(line, "may run the following commands") {
+				listing = true
+				continue
+			}
+			if listing && strings.HasPrefix(line, "(") {
+				rule := parseSudoCommands(line)
+				rule.Source, rule.Principal = source, instance.user
+				rules = append(rules, rule)
+			}
+			continue
+		}
+
+		if rule, ok := parseSudoersLine(line); ok {
+			rule.Source = source
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, scanner.Err()
+}
+
+// parseSudoersLine parses "principal hosts = (runas) TAG: commands",
+// defaults and alias definitions are skipped
+func parseSudoersLine(line string) (SudoRule, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] == "Defaults" || strings.HasPrefix(fields[0], "Defaults") ||
+		strings.HasSuffix(fields[0], "_Alias") {
+		return SudoRule{}, false
+	}
+
+	eq := strings.Index(line, "=")
+	if eq < 0 {
+		return SudoRule{}, false
+	}
+
+	spec := strings.Fields(line[:eq])
+	if len(spec) != 2 {
+		return SudoRule{}, false
+	}
+
+	rule := parseSudoCommands(strings.TrimSpace(line[eq+1:]))
+	rule.Principal, rule.Hosts = spec[0], spec[1]
+
+	return rule, true
+}
+
+// parseSudoCommands parses "(runas) TAG: command, command"
+func parseSudoCommands(spec string) SudoRule {
+	rule := SudoRule{}
+
+	if strings.HasPrefix(spec, "(") {
+		if end := strings.Index(spec, ")"); end > 0 {
+			rule.RunAs = spec[1:end]
+			spec = strings.TrimSpace(spec[end+1:])
+		}
+	}
+
+	// tags like NOPASSWD: or SETENV: precede commands
+	for {
+		colon := strings.Index(spec, ":")
+		if colon < 0 {
+			break
+		}
+
+		tag := strings.TrimSpace(spec[:colon])
+		if tag == "" || strings.ContainsAny(tag, " /,") || strings.ToUpper(tag) != tag {
+			break
+		}
+		if tag == "NOPASSWD" {
+			rule.NoPassword = true
+		}
+		spec = strings.TrimSpace(spec[colon+1:])
+	}
+
+	rule.Commands = []string{}
+	for _, cmd := range strings.Split(spec, ",") {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			rule.Commands = append(rule.Commands, cmd)
+		}
+	}
+
+	return rule
+}
+
+// Summarize counts hosts granting any command without password
+func (c *sudoersCollector) Summarize(results []interface{}) map[string]int {
+	count := 0
+	for _, res := range results {
+		rules, _ := res.([]SudoRule)
+		if hasNoPasswordAll(rules) {
+			count++
+		}
+	}
+
+	return map[string]int{"sudo_nopasswd_all": count}
+}
+
+func hasNoPasswordAll(rules []SudoRule) bool {
+	for _, rule := range rules {
+		if !rule.NoPassword {
+			continue
+		}
+		for _, cmd := range rule.Commands {
+			if cmd == "ALL" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// UnitStatus is a state of the systemd unit
+type UnitStatus struct {
+	LoadState     string
+	ActiveState   string
+	SubState      string
+	UnitFileState string
+	Active        bool
+	Enabled       bool
+}
+
+// systemdCollector reports state of SYSTEMD_UNITS
+type systemdCollector struct {
+	units []string
+}
+
+func newSystemdCollector() (Collector, error) {
+	c := &systemdCollector{}
+
+	for _, unit := range strings.Split(getEnv("SYSTEMD_UNITS", ""), ",") {
+		if unit = strings.TrimSpace(unit); unit != "" {
+			c.units = append(c.units, unit)
+		}
+	}
+
+	if len(c.units) == 0 {
+		return nil, errors.Errorf("You should provide SYSTEMD_UNITS")
+	}
+
+	return c, nil
+}
+
+// Command prints a header line followed by properties of every unit
+func (c *systemdCollector) Command() string {
+	parts := []string{}
+	for _, unit := range c.units {
+		parts = append(parts, fmt.Sprintf(
+			`echo %s; systemctl show --property=LoadState,ActiveState,SubState,UnitFileState %s`,
+			shellQuote("== "+unit), shellQuote(unit)))
+	}
+
+	return strings.Join(parts, "; ") + "; true"
+}
+
+func (c *systemdCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	units := map[string]UnitStatus{}
+
+	unit := ""
+	status := UnitStatus{}
+	flush := func() {
+		if unit != "" {
+			status.Active = status.ActiveState == "active"
+			status.Enabled = status.UnitFileState == "enabled" || status.UnitFileState == "static"
+			units[unit] = status
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "== ") {
+			flush()
+			unit, status = strings.TrimPrefix(line, "== "), UnitStatus{}
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if unit == "" || len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "LoadState":
+			status.LoadState = kv[1]
+		case "ActiveState":
+			status.ActiveState = kv[1]
+		case "SubState":
+			status.SubState = kv[1]
+		case "UnitFileState":
+			status.UnitFileState = kv[1]
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, u := range c.units {
+		if _, ok := units[u]; !ok {
+			return nil, errors.Errorf("No status of '%s' unit, is systemd available?", u)
+		}
+	}
+
+	return units, nil
+}
+
+// Summarize counts units which are not active across the fleet
+func (c *systemdCollector) Summarize(results []interface{}) map[string]int {
+	count := 0
+	for _, res := range results {
+		units, ok := res.(map[string]UnitStatus)
+		if !ok {
+			continue
+		}
+
+		for _, status := range units {
+			if !status.Active {
+				count++
+			}
+		}
+	}
+
+	return map[string]int{"units_inactive": count}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultTimeDriftThreshold = "5"
+
+// TimeDrift compares the remote host clock with the Lambda clock
+type TimeDrift struct {
+	RemoteTime   time.Time
+	DriftSeconds float64
+	Exceeded     bool
+}
+
+// timeDriftCollector reports clock drift of the remote host.
+// Drift is measured against the moment the facts were collected, so it includes
+// the time spent by the slowest fact on the host and shouldn't be too strict.
+type timeDriftCollector struct {
+	threshold float64
+}
+
+func newTimeDriftCollector() (Collector, error) {
+	threshold, err := strconv.ParseFloat(getEnv("TIME_DRIFT_THRESHOLD", defaultTimeDriftThreshold), 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid TIME_DRIFT_THRESHOLD")
+	}
+
+	return &timeDriftCollector{threshold: threshold}, nil
+}
+
+func (c *timeDriftCollector) Command() string {
+	return "date +%s"
+}
+
+func (c *timeDriftCollector) Parse(out string, instance *InstanceInfo) (interface{}, error) {
+	sec, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unexpected remote time: '%s'", out)
+	}
+
+	remote := time.Unix(sec, 0).UTC()
+	drift := remote.Sub(instance.collectedAt.Truncate(time.Second)).Seconds()
+
+	return TimeDrift{
+		RemoteTime:   remote,
+		DriftSeconds: drift,
+		Exceeded:     math.Abs(drift) > c.threshold,
+	}, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// collectorPrefix separates collector commands from user defined facts
+const collectorPrefix = "collector:"
+
+// Collector is a built-in fact which returns a structured result
+type Collector interface {
+	// Command returns the shell command to run on the remote host
+	Command() string
+	// Parse converts the command output into a structured result
+	Parse(out string, instance *InstanceInfo) (interface{}, error)
+}
+
+// collectors contains constructors for all built-in collectors
+var collectors = map[string]func() (Collector, error){
+	"accounts":  newAccountsCollector,
+	"certs":     newCertCollector,
+	"cloudinit": newCloudInitCollector,
+	"crontab":   newCrontabCollector,
+	"docker":    newDockerCollector,
+	"hostname":  newHostnameCollector,
+	"mounts":    newMountsCollector,
+	"reboot":    newRebootCollector,
+	"sudoers":   newSudoersCollector,
+	"systemd":   newSystemdCollector,
+	"timedrift": newTimeDriftCollector,
+}
+
+// Summarizer is implemented by collectors contributing to the run summary
+type Summarizer interface {
+	Summarize(results []interface{}) map[string]int
+}
+
+// getCollectors returns collectors with given names, COLLECTORS variable by default
+func getCollectors(names []string) (map[string]Collector, error) {
+	enabled := map[string]Collector{}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		newCollector, ok := collectors[name]
+		if !ok {
+			return nil, errors.Errorf("Unknown collector: '%s' (available: %s)", name, strings.Join(collectorNames(), ", "))
+		}
+
+		c, err := newCollector()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't setup '%s' collector", name)
+		}
+
+		enabled[name] = c
+	}
+
+	return enabled, nil
+}
+
+func collectorNames() []string {
+	names := []string{}
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// collectorCommands adds collector commands to the facts map
+func collectorCommands(factsToCollect map[string]string, enabled map[string]Collector) map[string]string {
+	commands := map[string]string{}
+	for name, cmd := range factsToCollect {
+		commands[name] = cmd
+	}
+
+	for name, c := range enabled {
+		commands[collectorPrefix+name] = c.Command()
+	}
+
+	return commands
+}
+
+// parseCollected converts raw collector outputs of the instance into results
+func parseCollected(instance *InstanceInfo, enabled map[string]Collector) {
+	if len(enabled) == 0 || instance.facts == nil {
+		return
+	}
+
+	instance.collected = map[string]interface{}{}
+	for name, c := range enabled {
+		out, ok := instance.facts[collectorPrefix+name]
+		if !ok {
+			continue
+		}
+
+		res, err := c.Parse(out, instance)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "Can't parse '%s' collector output", name))
+			res = map[string]string{"error": err.Error()}
+		}
+
+		instance.collected[name] = res
+	}
+}
+
+// summarizeCollected merges summaries of all collectors supporting it
+func summarizeCollected(summary map[string]int, instances []*InstanceInfo, enabled map[string]Collector) {
+	for name, c := range enabled {
+		s, ok := c.(Summarizer)
+		if !ok {
+			continue
+		}
+
+		results := []interface{}{}
+		for _, inst := range instances {
+			if res, ok := inst.collected[name]; ok {
+				results = append(results, res)
+			}
+		}
+
+		for k, v := range s.Summarize(results) {
+			summary[k] += v
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// commandScheduler caps simultaneous remote commands across all hosts and
+// hands freed slots out fairly: the waiting host running the fewest commands
+// goes first, ties are broken round-robin. So commands of connected hosts
+// are interleaved instead of the first hosts taking all slots till they finish.
+type commandScheduler struct {
+	sync.Mutex
+	free int
+
+	// order lists hosts with waiting commands, the host is moved to the end
+	// every time its command is started
+	order   []string
+	waiting map[string][]chan struct{}
+	running map[string]int
+}
+
+// defaultMaxCommands caps simultaneous commands at MAX_SESSIONS, so a run
+// doesn't execute MAX_SESSIONS × number of facts commands at once
+func defaultMaxCommands() string {
+	return getEnv("MAX_SESSIONS", defaultMaxSessions)
+}
+
+func newCommandScheduler(slots int) *commandScheduler {
+	return &commandScheduler{
+		free:    slots,
+		waiting: map[string][]chan struct{}{},
+		running: map[string]int{},
+	}
+}
+
+// acquire blocks till the command of the host is allowed to start or ctx is
+// cancelled, the slot isn't taken in the latter case
+func (s *commandScheduler) acquire(ctx context.Context, host string) error {
+	s.Lock()
+	if s.free > 0 && len(s.order) == 0 {
+		s.free--
+		s.running[host]++
+		s.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	if len(s.waiting[host]) == 0 {
+		s.order = append(s.order, host)
+	}
+	s.waiting[host] = append(s.waiting[host], ready)
+	s.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.Lock()
+	select {
+	case <-ready:
+		// the slot was handed out meanwhile, pass it to the next command
+		s.Unlock()
+		s.release(host)
+		return ctx.Err()
+	default:
+	}
+	defer s.Unlock()
+
+	for i, c := range s.waiting[host] {
+		if c == ready {
+			s.waiting[host] = append(s.waiting[host][:i], s.waiting[host][i+1:]...)
+			break
+		}
+	}
+	if len(s.waiting[host]) == 0 {
+		delete(s.waiting, host)
+		for i, h := range s.order {
+			if h == host {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// release frees the slot of the finished command and starts the next one
+func (s *commandScheduler) release(host string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.running[host]--
+	if s.running[host] <= 0 {
+		delete(s.running, host)
+	}
+
+	if len(s.order) == 0 {
+		s.free++
+		return
+	}
+
+	next := 0
+	for i, h := range s.order {
+		if s.running[h] < s.running[s.order[next]] {
+			next = i
+		}
+	}
+
+	h := s.order[next]
+	ready := s.waiting[h][0]
+	s.waiting[h] = s.waiting[h][1:]
+	s.order = append(s.order[:next], s.order[next+1:]...)
+	if len(s.waiting[h]) > 0 {
+		s.order = append(s.order, h)
+	} else {
+		delete(s.waiting, h)
+	}
+
+	s.running[h]++
+	close(ready)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// queue starts acquire of the host and waits till its command is queued
+func queue(ctx context.Context, s *commandScheduler, host string, started chan<- string, errs chan<- error) {
+	queued := func() (n int) {
+		s.Lock()
+		defer s.Unlock()
+		for _, w := range s.waiting {
+			n += len(w)
+		}
+		return n
+	}
+	before := queued()
+
+	go func() {
+		if err := s.acquire(ctx, host); err != nil {
+			errs <- err
+			return
+		}
+		started <- host
+	}()
+
+	for queued() == before {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCommandSchedulerFairness(t *testing.T) {
+	tests := []struct {
+		name    string
+		slots   int
+		holding []string
+		queued  []string
+		want    []string
+	}{
+		{"ties are broken round-robin", 1, []string{"a"}, []string{"a", "a", "b", "b"}, []string{"a", "b", "a", "b"}},
+		{"host running fewest commands goes first", 2, []string{"a", "a"}, []string{"a", "b"}, []string{"b", "a"}},
+		{"hosts are moved to the end when started", 1, []string{"a"}, []string{"b", "a", "a"}, []string{"b", "a", "a"}},
+	}
+
+	for _, tt := range tests {
+		s := newCommandScheduler(tt.slots)
+		started, errs := make(chan string), make(chan error)
+
+		for _, host := range tt.holding {
+			if err := s.acquire(context.Background(), host); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		for _, host := range tt.queued {
+			queue(context.Background(), s, host, started, errs)
+		}
+
+		got := []string{}
+		running := tt.holding
+		for range tt.queued {
+			s.release(running[0])
+			host := <-started
+			got = append(got, host)
+			running = append(running[1:], host)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommandSchedulerCancel(t *testing.T) {
+	tests := []struct {
+		name      string
+		queued    []string
+		cancelled int
+		want      string
+	}{
+		{"slot is freed", []string{"b"}, 0, ""},
+		{"next host starts", []string{"b", "c"}, 0, "c"},
+		{"next command of the host starts", []string{"b", "b"}, 0, "b"},
+		{"earlier commands are kept", []string{"b", "c"}, 1, "b"},
+	}
+
+	for _, tt := range tests {
+		s := newCommandScheduler(1)
+		started, errs := make(chan string, len(tt.queued)), make(chan error, len(tt.queued))
+
+		if err := s.acquire(context.Background(), "a"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		for i, host := range tt.queued {
+			if i == tt.cancelled {
+				queue(ctx, s, host, started, errs)
+			} else {
+				queue(context.Background(), s, host, started, errs)
+			}
+		}
+
+		cancel()
+		if err := <-errs; err != context.Canceled {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, context.Canceled)
+		}
+
+		s.release("a")
+		if tt.want == "" {
+			if s.free != 1 || len(s.order) != 0 || len(s.waiting) != 0 {
+				t.Errorf("%s: slot is not freed: free %d, order %v", tt.name, s.free, s.order)
+			}
+			continue
+		}
+		if host := <-started; host != tt.want {
+			t.Errorf("%s: got %s started, want %s", tt.name, host, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is read from YAML file set with CONFIG_FILE
+type Config struct {
+	// Pipeline replaces the default discover, collect, sinks steps of the run
+	Pipeline []PipelineStep `yaml:"pipeline"`
+	// OutputTemplate renders API responses, OUTPUT_TEMPLATE wins over it
+	OutputTemplate string `yaml:"output_template"`
+}
+
+// loadConfig returns empty config when CONFIG_FILE is not set
+func loadConfig() (*Config, error) {
+	config := &Config{}
+
+	path := getEnv("CONFIG_FILE", "")
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't read config file %s", path)
+	}
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, errors.Wrapf(err, "Can't parse config file %s", path)
+	}
+
+	return config, nil
+}
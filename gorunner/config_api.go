@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// redacted replaces values of secret settings
+const redacted = "<redacted>"
+
+// settingDefaults are defaults of settings, settings without default are empty
+var settingDefaults = map[string]string{
+	"ACCOUNTS_MAX_UID":            defaultAccountsMaxUID,
+	"ADDRESS_FAMILY":              defaultAddressFamily,
+	"ADDRESS_REPORT":              "false",
+	"ADDRESS_MODE":                defaultAddressMode,
+	"DIAL_NAME":                   "",
+	"DNS_RESOLVER":                "",
+	"ACCOUNTS_MIN_UID":            defaultAccountsMinUID,
+	"ACCOUNT_CONCURRENCY":         defaultAccountConcurrency,
+	"API_ENDPOINTS":               "",
+	"ASG_NAMES":                   "",
+	"ASSUME_ROLES":                "",
+	"ATTEMPT_LOG":                 defaultAttemptLog,
+	"CANDIDATE_FACTS":             "",
+	"CANDIDATE_SAMPLE":            defaultCandidateSample,
+	"IMMUTABLE_FACTS":             "",
+	"CERT_PATHS":                  "",
+	"CERT_PORTS":                  "",
+	"CLOUDINIT_LOG_BYTES":         defaultCloudInitLogBytes,
+	"CERT_WARN_DAYS":              defaultCertWarnDays,
+	"COLLECTORS":                  "",
+	"CONFIG_AGGREGATOR":           "",
+	"CONFIG_FILE":                 "",
+	"CREDENTIALS":                 "",
+	"DIGEST_EMAIL_FROM":           "",
+	"DIGEST_EMAIL_TO":             "",
+	"DIGEST_HOURS":                defaultDigestHours,
+	"DIGEST_SLACK_URL":            "",
+	"DISCOVERY":                   defaultDiscovery,
+	"EC2_FILTERS":                 "",
+	"ECS_CLUSTERS":                "",
+	"EKS_CLUSTERS":                "",
+	"EXCLUDE_TAGS":                "",
+	"SELECTOR":                    "",
+	"NAME_INCLUDE":                "",
+	"NAME_EXCLUDE":                "",
+	"MIN_AGE_MINUTES":             "0",
+	"MAX_AGE_MINUTES":             "0",
+	"INSTANCE_LIFECYCLE":          "",
+	"MAX_INSTANCES":               defaultMaxInstances,
+	"SAMPLE":                      defaultSample,
+	"FACTS":                       defaultFacts,
+	"FACT_OPTIONS":                "",
+	"STRICT_READONLY":             "false",
+	"FACT_ORDER":                  defaultFactOrder,
+	"FACT_PROFILES":               "",
+	"HISTORY_BUCKET":              "",
+	"HISTORY_PREFIX":              defaultHistoryPrefix,
+	"HISTORY_RETENTION_DAYS":      "0",
+	"HISTORY_RETENTION_RUNS":      "0",
+	"HOSTNAME_NORMALIZE":          defaultHostnameNormalize,
+	"HOSTNAME_TAG":                defaultHostnameTag,
+	"HOSTS":                       "",
+	"INSTANCE_IDS":                "",
+	"INSTANCE_STATES":             defaultInstanceStates,
+	"INVENTORY_CACHE_SECONDS":     defaultInventoryCacheSeconds,
+	"INVENTORY_CACHE_TABLE":       "",
+	"STALE_INVENTORY_SECONDS":     defaultStaleInventorySeconds,
+	"INVENTORY_CHECK_MAX_RESULTS": defaultInventoryCheckMaxResults,
+	"INVENTORY_S3_URI":            "",
+	"INVENTORY_TABLE":             "",
+	"RELAY_TABLE":                 "",
+	"RELAY_HOST":                  "",
+	"JOBS_TABLE":                  "",
+	"JOB_POLL_SECONDS":            defaultJobPollSeconds,
+	"MAX_ATTEMPTS":                defaultMaxAttempts,
+	"MAX_CONNECTIONS":             defaultMaxConnections,
+	"MAX_DIAL_ATTEMPTS":           defaultMaxDialAttempts,
+	"MAX_SESSIONS":                defaultMaxSessions,
+	"METRICS_NAMESPACE":           "",
+	"MOUNT_HARDENED_PATHS":        defaultMountHardenedPaths,
+	"MOUNT_REQUIRED_OPTIONS":      defaultMountRequiredOptions,
+	"OMIT_EMPTY":                  "",
+	"ORGANIZATION_ROLE_NAME":      "",
+	"OUTPUT_CASE":                 defaultOutputCase,
+	"OUTPUT_FORMAT":               defaultOutputFormat,
+	"GROUP_BY":                    "",
+	"DISPLAY_TIMEZONE":            defaultDisplayTimezone,
+	"OUTPUT_TEMPLATE":             "",
+	"OUTPUT_TEMPLATE_TYPE":        defaultOutputTemplateType,
+	"PENDING_WARMUP":              defaultPendingWarmup,
+	"RESPONSE_MAX_BYTES":          defaultResponseMaxBytes,
+	"RESULTS_TABLE":               "",
+	"RESULT_URL_EXPIRY":           defaultResultURLExpiry,
+	"ROUTE53_ZONES":               "",
+	"RUN_LABELS":                  "",
+	"SESSION_FINGERPRINT":         "",
+	"SNS_TOPIC_ARN":               "",
+	"SSH_KEY":                     "",
+	"SSH_KEY_PATH":                "",
+	"SSH_KEYS":                    "",
+	"SSH_KEY_PASSPHRASE":          "",
+	"SSH_KEY_SECRET_ARN":          "",
+	"SSH_KEY_SSM_PARAM":           "",
+	"SSH_KEY_S3_URI":              "",
+	"SYSTEMD_UNITS":               "",
+	"TAG_FILTERS":                 "",
+	"TEAM_TAG":                    "",
+	"TIMEOUT":                     defaultTimeout,
+	"HOST_KEY_SOCKET":             "",
+	"HOST_KEY_TIMEOUT":            defaultHostKeyTimeout,
+	"TIME_DRIFT_THRESHOLD":        defaultTimeDriftThreshold,
+	"USERS":                       defaultUsers,
+	"VERIFY_DEPLOYMENT":           "",
+	"WEBHOOK_URL":                 "",
+}
+
+// secretSettings are reported as set or not only, webhook URLs contain tokens
+var secretSettings = map[string]bool{
+	"SSH_KEY":            true,
+	"SSH_KEYS":           true,
+	"SSH_KEY_PASSPHRASE": true,
+	"WEBHOOK_URL":        true,
+	"DIGEST_SLACK_URL":   true,
+}
+
+// EffectiveConfig is the configuration the function runs with
+type EffectiveConfig struct {
+	// Settings are environment variables with defaults applied
+	Settings map[string]string
+	Pipeline []PipelineStep `json:",omitempty"`
+	// Errors lists settings which can't be applied
+	Errors []string `json:",omitempty"`
+}
+
+// effectiveConfig resolves all settings, secrets are redacted
+func effectiveConfig() *EffectiveConfig {
+	config := &EffectiveConfig{Settings: map[string]string{}}
+
+	for name, fallback := range settingDefaults {
+		value := getEnv(name, fallback)
+		if secretSettings[name] && value != "" {
+			value = redacted
+		}
+		config.Settings[name] = value
+	}
+
+	// defaults depending on other settings
+	config.Settings["REGIONS"] = strings.Join(getRegions(aws.StringValue(awsSession().Config.Region)), ",")
+	config.Settings["SINKS"] = strings.Join(sinkList(), ",")
+	config.Settings["MAX_COMMANDS"] = getEnv("MAX_COMMANDS", defaultMaxCommands())
+
+	pipeline, err := getPipeline()
+	if err != nil {
+		config.Errors = append(config.Errors, err.Error())
+	}
+	config.Pipeline = pipeline
+
+	// sources aren't set up since they call AWS APIs
+	for _, name := range discoveryNames() {
+		if _, ok := discoverySources[name]; !ok {
+			config.Errors = append(config.Errors, fmt.Sprintf("Unknown discovery source: '%s'", name))
+		}
+	}
+	if _, err := getCollectors(splitList(config.Settings["COLLECTORS"])); err != nil {
+		config.Errors = append(config.Errors, err.Error())
+	}
+
+	return config
+}
+
+// handleConfig serves GET /config
+func handleConfig(request events.APIGatewayProxyRequest) (Response, error) {
+	return jsonResponse(200, effectiveConfig())
+}
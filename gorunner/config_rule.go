@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/pkg/errors"
+)
+
+// configEvaluationsLimit is the maximum number of evaluations of a single PutEvaluations call
+const configEvaluationsLimit = 100
+
+// configRuleParameters are parameters of the custom AWS Config rule
+type configRuleParameters struct {
+	// Rules are comma separated names of pipeline rules reported by the Config rule, all by default
+	Rules string `json:"rules"`
+}
+
+// isConfigRuleEvent tells if the function is invoked by a custom AWS Config rule
+func isConfigRuleEvent(event json.RawMessage) (events.ConfigEvent, bool) {
+	configEvent := events.ConfigEvent{}
+	if err := json.Unmarshal(event, &configEvent); err != nil {
+		return configEvent, false
+	}
+
+	return configEvent, configEvent.ResultToken != "" && configEvent.ConfigRuleName != ""
+}
+
+// handleConfigRule collects facts and reports results of pipeline rules
+// as evaluations of EC2 instances of the rule account
+func (h *Handler) handleConfigRule(ctx context.Context, configEvent events.ConfigEvent) error {
+	params := configRuleParameters{}
+	if configEvent.RuleParameters != "" {
+		if err := json.Unmarshal([]byte(configEvent.RuleParameters), &params); err != nil {
+			return errors.Wrapf(err, "Can't parse parameters of %s rule", configEvent.ConfigRuleName)
+		}
+	}
+
+	startTime := h.deps.Now()
+	res, err := h.deps.Worker(ctx, RunOptions{})
+	if err != nil {
+		return err
+	}
+
+	evaluations := configEvaluations(res.Rows, splitList(params.Rules), configEvent.AccountID, startTime)
+	log.Printf("AWS Config: reporting %v evaluation(s) to %s rule", len(evaluations), configEvent.ConfigRuleName)
+
+	svc := configservice.New(awsSession())
+	for len(evaluations) > 0 {
+		n := configEvaluationsLimit
+		if len(evaluations) < n {
+			n = len(evaluations)
+		}
+
+		out, err := svc.PutEvaluationsWithContext(ctx, &configservice.PutEvaluationsInput{
+			ResultToken: aws.String(configEvent.ResultToken),
+			Evaluations: evaluations[:n],
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Can't put evaluations of %s rule", configEvent.ConfigRuleName)
+		}
+		if len(out.FailedEvaluations) > 0 {
+			return errors.Errorf("AWS Config rejected %d evaluation(s) of %s rule", len(out.FailedEvaluations), configEvent.ConfigRuleName)
+		}
+
+		evaluations = evaluations[n:]
+	}
+
+	return nil
+}
+
+// configEvaluations converts compliance of EC2 instances of the account. The instance is
+// noncompliant when any of the rules failed, instances without rule results are skipped.
+func configEvaluations(rows []ResRow, rules []string, account string, timestamp time.Time) []*configservice.Evaluation {
+	evaluations := []*configservice.Evaluation{}
+
+	for _, row := range rows {
+		if !strings.HasPrefix(row.InstanceId, "i-") || (row.Account != "" && row.Account != account) {
+			continue
+		}
+
+		names := rules
+		if len(names) == 0 {
+			for name := range row.Compliance {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+
+		evaluated, failed := false, []string{}
+		for _, name := range names {
+			passed, ok := row.Compliance[name]
+			if !ok {
+				continue
+			}
+			evaluated = true
+			if !passed {
+				failed = append(failed, name)
+			}
+		}
+		if !evaluated {
+			continue
+		}
+
+		evaluation := &configservice.Evaluation{
+			ComplianceResourceId:   aws.String(row.InstanceId),
+			ComplianceResourceType: aws.String("AWS::EC2::Instance"),
+			ComplianceType:         aws.String(configservice.ComplianceTypeCompliant),
+			OrderingTimestamp:      aws.Time(timestamp),
+		}
+		if len(failed) > 0 {
+			evaluation.ComplianceType = aws.String(configservice.ComplianceTypeNonCompliant)
+			evaluation.Annotation = aws.String(truncate("Failed rules: "+strings.Join(failed, ", "), 256))
+		}
+
+		evaluations = append(evaluations, evaluation)
+	}
+
+	return evaluations
+}
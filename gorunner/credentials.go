@@ -0,0 +1,762 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// CredentialProvider resolves ssh credentials
+type CredentialProvider interface {
+	// Configured tells if the provider has enough settings to be used
+	Configured() bool
+	// Version identifies current credentials: changed version invalidates
+	// the cached auth method, empty version disables caching
+	Version() (string, error)
+	// AuthMethod loads credentials
+	AuthMethod() (ssh.AuthMethod, error)
+}
+
+// InstanceAuthorizer is implemented by providers which have to authorize
+// the key on every instance right before connecting to it
+type InstanceAuthorizer interface {
+	Authorize(instance *InstanceInfo, users []string) error
+}
+
+// KeySetProvider is implemented by providers of several keys. Every key is
+// tried with every user in its own connection, since ssh servers try a single
+// public key method per connection and cap authentication attempts.
+type KeySetProvider interface {
+	AuthMethods() ([]ssh.AuthMethod, error)
+}
+
+// credentialProviders contains constructors for all registered providers
+var credentialProviders = map[string]func() CredentialProvider{
+	"env":    newEnvCredentials,
+	"file":   newFileCredentials,
+	"keys":   newKeysCredentials,
+	"secret": newSecretCredentials,
+	"ssm":    newSSMCredentials,
+	"s3":     newS3Credentials,
+	"agent":  newAgentCredentials,
+	"eic":    newEICCredentials,
+}
+
+// credentialProvidersOrder is used to pick the provider when CREDENTIALS is not set
+var credentialProvidersOrder = []string{"env", "file", "keys", "secret", "ssm", "s3", "agent", "eic"}
+
+// remoteCredentialProviders fetch keys from AWS APIs, they are loaded
+// during the function init
+var remoteCredentialProviders = map[string]bool{"secret": true, "ssm": true, "s3": true}
+
+// credentialCache keeps the auth method between warm invocations
+var credentialCache struct {
+	sync.Mutex
+	provider    string
+	version     string
+	authMethods []ssh.AuthMethod
+	authorizer  InstanceAuthorizer
+
+	// keys are raw private keys behind the cached auth methods,
+	// they are wiped once the auth methods are replaced
+	keys []interface{}
+	// parsed collects keys parsed by the provider being loaded
+	parsed []interface{}
+}
+
+// getCredentialProvider returns the provider set with CREDENTIALS variable
+// or the first configured one
+func getCredentialProvider() (string, CredentialProvider, error) {
+	name := getEnv("CREDENTIALS", "")
+
+	if name != "" {
+		newProvider, ok := credentialProviders[name]
+		if !ok {
+			return "", nil, errors.Errorf("Unknown credentials provider: '%s' (available: %s)", name, strings.Join(credentialProvidersOrder, ", "))
+		}
+		return name, newProvider(), nil
+	}
+
+	for _, n := range credentialProvidersOrder {
+		if p := credentialProviders[n](); p.Configured() {
+			return n, p, nil
+		}
+	}
+
+	return "", nil, errors.Errorf("You should provide ssh key or launch SSH agent")
+}
+
+// getAuthMethods loads credentials of the provider, providers of several keys
+// return a method per key
+func getAuthMethods() ([]ssh.AuthMethod, error) {
+	name, provider, err := getCredentialProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := provider.Version()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't check '%s' credentials", name)
+	}
+
+	credentialCache.Lock()
+	defer credentialCache.Unlock()
+
+	if version != "" && credentialCache.provider == name && credentialCache.version == version {
+		log.Printf("Using cached '%s' credentials", name)
+		return credentialCache.authMethods, nil
+	}
+
+	credentialCache.parsed = nil
+	var authMethods []ssh.AuthMethod
+	if keySet, ok := provider.(KeySetProvider); ok {
+		authMethods, err = keySet.AuthMethods()
+	} else {
+		var authMethod ssh.AuthMethod
+		authMethod, err = provider.AuthMethod()
+		authMethods = []ssh.AuthMethod{authMethod}
+	}
+	if err != nil {
+		wipePrivateKeys(credentialCache.parsed)
+		return nil, errors.Wrapf(err, "Can't load '%s' credentials", name)
+	}
+
+	// the previous auth method is never used again
+	wipePrivateKeys(credentialCache.keys)
+
+	credentialCache.provider = name
+	credentialCache.version = version
+	credentialCache.authMethods = authMethods
+	credentialCache.authorizer, _ = provider.(InstanceAuthorizer)
+	credentialCache.keys, credentialCache.parsed = credentialCache.parsed, nil
+
+	return authMethods, nil
+}
+
+// authorizeInstance lets the current provider authorize the key on the instance
+func authorizeInstance(instance *InstanceInfo) error {
+	credentialCache.Lock()
+	authorizer := credentialCache.authorizer
+	credentialCache.Unlock()
+
+	if authorizer == nil {
+		return nil
+	}
+
+	return authorizer.Authorize(instance, getUsers())
+}
+
+// preloadCredentials fetches and decrypts keys of remote providers during
+// the function init, so the first invocation doesn't wait for them. Errors
+// are reported again by the run.
+func preloadCredentials() {
+	name, _, err := getCredentialProvider()
+	if err != nil || !remoteCredentialProviders[name] {
+		return
+	}
+
+	if _, err := getAuthMethods(); err != nil {
+		log.Printf("Can't preload '%s' credentials: %v", name, err)
+	}
+}
+
+// parseKey builds the signer and wipes the key buffer. Parse errors
+// never include the key material, so they are safe to log. Providers call it
+// with credentialCache locked, the parsed key is wiped once the auth method
+// is replaced.
+func parseKey(secret *secretBuffer) (ssh.AuthMethod, error) {
+	key, err := parseSigner(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.PublicKeys(key), nil
+}
+
+// parseSigner parses the key and wipes the buffer, encrypted keys are
+// decrypted with SSH_KEY_PASSPHRASE
+func parseSigner(secret *secretBuffer) (ssh.Signer, error) {
+	defer secret.Wipe()
+
+	key, err := ssh.ParseRawPrivateKey(secret.Bytes())
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		passphrase := keyPassphrase()
+		if passphrase == nil {
+			return nil, errors.Errorf("Can't parse ssh key: the key is passphrase protected, set SSH_KEY_PASSPHRASE")
+		}
+
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(secret.Bytes(), passphrase.Bytes())
+		if err == x509.IncorrectPasswordError {
+			return nil, errors.Errorf("Can't decrypt ssh key: wrong SSH_KEY_PASSPHRASE")
+		}
+	}
+	if err != nil {
+		return nil, errors.Errorf("Can't parse ssh key, it's malformed or unsupported: %s", err.Error())
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		wipePrivateKey(key)
+		return nil, errors.Wrap(err, "Can't use ssh key")
+	}
+	credentialCache.parsed = append(credentialCache.parsed, key)
+
+	return signer, nil
+}
+
+// envPassphrase holds SSH_KEY_PASSPHRASE moved out of the environment on the
+// first use. It stays in locked memory, since keys are decrypted on every reload.
+var envPassphrase struct {
+	sync.Once
+	secret *secretBuffer
+}
+
+// keyPassphrase returns SSH_KEY_PASSPHRASE or nil if it's not set
+func keyPassphrase() *secretBuffer {
+	envPassphrase.Do(func() {
+		envPassphrase.secret = takeEnvSecret("SSH_KEY_PASSPHRASE")
+	})
+
+	return envPassphrase.secret
+}
+
+func keyVersion(pemBytes []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(pemBytes))
+}
+
+// envKey holds SSH_KEY moved out of the environment on the first use
+var envKey struct {
+	sync.Once
+	secret  *secretBuffer
+	version string
+}
+
+// envCredentials reads the key from SSH_KEY variable. The variable is wiped
+// and unset once it's read, so the key is parsed once per process.
+type envCredentials struct{}
+
+func newEnvCredentials() CredentialProvider {
+	envKey.Do(func() {
+		envKey.secret = takeEnvSecret("SSH_KEY")
+		if envKey.secret != nil {
+			envKey.version = keyVersion(envKey.secret.Bytes())
+		}
+	})
+
+	return &envCredentials{}
+}
+
+func (c *envCredentials) Configured() bool {
+	return envKey.secret != nil
+}
+
+func (c *envCredentials) Version() (string, error) {
+	return envKey.version, nil
+}
+
+func (c *envCredentials) AuthMethod() (ssh.AuthMethod, error) {
+	if envKey.secret == nil || envKey.secret.Bytes() == nil {
+		return nil, errors.Errorf("SSH_KEY is not set or already wiped")
+	}
+
+	return parseKey(envKey.secret)
+}
+
+// fileCredentials reads the key from SSH_KEY_PATH file
+type fileCredentials struct {
+	path string
+}
+
+func newFileCredentials() CredentialProvider {
+	return &fileCredentials{path: os.Getenv("SSH_KEY_PATH")}
+}
+
+func (c *fileCredentials) Configured() bool {
+	return c.path != ""
+}
+
+func (c *fileCredentials) Version() (string, error) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%d:%d", c.path, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+func (c *fileCredentials) AuthMethod() (ssh.AuthMethod, error) {
+	secret, err := readSecretFile(c.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't open ssh key file")
+	}
+
+	return parseKey(secret)
+}
+
+// keysCredentials reads several keys listed in SSH_KEYS: JSON array or
+// comma separated list of key file paths. Entries of JSON array could
+// also be keys themselves.
+type keysCredentials struct{}
+
+// keyEntry is the key file path or the inline key of SSH_KEYS
+type keyEntry struct {
+	path    string
+	secret  *secretBuffer
+	version string
+}
+
+// envKeys holds SSH_KEYS moved out of the environment on the first use.
+// Inline keys stay in locked memory, since they are parsed again once
+// key files listed next to them change.
+var envKeys struct {
+	sync.Once
+	entries []keyEntry
+	err     error
+}
+
+func newKeysCredentials() CredentialProvider {
+	envKeys.Do(func() {
+		value := takeEnvSecret("SSH_KEYS")
+		if value == nil {
+			return
+		}
+		defer value.Wipe()
+
+		var entries []string
+		if text := bytes.TrimSpace(value.Bytes()); bytes.HasPrefix(text, []byte("[")) {
+			if err := json.Unmarshal(text, &entries); err != nil {
+				// the value may contain keys, so it's never included in the error
+				envKeys.err = errors.Errorf("Can't parse SSH_KEYS: should be JSON array of strings")
+				return
+			}
+		} else {
+			entries = splitList(string(value.Bytes()))
+		}
+
+		for i := range entries {
+			if !isInlineKey(entries[i]) {
+				envKeys.entries = append(envKeys.entries, keyEntry{path: entries[i]})
+				continue
+			}
+
+			secret := moveSecretString(&entries[i])
+			envKeys.entries = append(envKeys.entries, keyEntry{secret: secret, version: keyVersion(secret.Bytes())})
+		}
+	})
+
+	return &keysCredentials{}
+}
+
+// isInlineKey tells if the entry of SSH_KEYS is the key itself
+func isInlineKey(entry string) bool {
+	return strings.Contains(entry, "PRIVATE KEY-----")
+}
+
+func (c *keysCredentials) Configured() bool {
+	return len(envKeys.entries) > 0 || envKeys.err != nil
+}
+
+func (c *keysCredentials) Version() (string, error) {
+	if envKeys.err != nil {
+		return "", envKeys.err
+	}
+
+	versions := []string{}
+	for _, entry := range envKeys.entries {
+		if entry.secret != nil {
+			versions = append(versions, entry.version)
+			continue
+		}
+
+		info, err := os.Stat(entry.path)
+		if err != nil {
+			return "", err
+		}
+		versions = append(versions, fmt.Sprintf("%s:%d:%d", entry.path, info.Size(), info.ModTime().UnixNano()))
+	}
+
+	return keyVersion([]byte(strings.Join(versions, "\n"))), nil
+}
+
+func (c *keysCredentials) AuthMethod() (ssh.AuthMethod, error) {
+	signers, err := c.signers()
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.PublicKeys(signers...), nil
+}
+
+func (c *keysCredentials) AuthMethods() ([]ssh.AuthMethod, error) {
+	signers, err := c.signers()
+	if err != nil {
+		return nil, err
+	}
+
+	methods := []ssh.AuthMethod{}
+	for _, signer := range signers {
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	return methods, nil
+}
+
+func (c *keysCredentials) signers() ([]ssh.Signer, error) {
+	if envKeys.err != nil {
+		return nil, envKeys.err
+	}
+
+	signers := []ssh.Signer{}
+	for i, entry := range envKeys.entries {
+		var secret *secretBuffer
+		if entry.secret != nil {
+			// parseSigner wipes the buffer, the inline key is kept for reloads
+			secret = newSecretBuffer(len(entry.secret.Bytes()))
+			copy(secret.Bytes(), entry.secret.Bytes())
+		} else {
+			var err error
+			if secret, err = readSecretFile(entry.path); err != nil {
+				return nil, errors.Wrapf(err, "Can't open ssh key file %s", entry.path)
+			}
+		}
+
+		signer, err := parseSigner(secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Key #%d of SSH_KEYS", i+1)
+		}
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+// secretCredentials reads the key from Secrets Manager secret SSH_KEY_SECRET_ARN.
+// Version is the id of the AWSCURRENT version, so the key is fetched again
+// only once the secret is rotated.
+type secretCredentials struct {
+	secretID  string
+	versionID string
+}
+
+func newSecretCredentials() CredentialProvider {
+	return &secretCredentials{secretID: os.Getenv("SSH_KEY_SECRET_ARN")}
+}
+
+func (c *secretCredentials) Configured() bool {
+	return c.secretID != ""
+}
+
+// client calls Secrets Manager of the secret region, names are looked up
+// in the function region
+func (c *secretCredentials) client() *secretsmanager.SecretsManager {
+	config := aws.NewConfig()
+	if parsed, err := arn.Parse(c.secretID); err == nil {
+		config = config.WithRegion(parsed.Region)
+	}
+
+	return secretsmanager.New(awsSession(), config)
+}
+
+func (c *secretCredentials) Version() (string, error) {
+	out, err := c.client().DescribeSecret(&secretsmanager.DescribeSecretInput{SecretId: aws.String(c.secretID)})
+	if err != nil {
+		return "", errors.Wrap(err, "Can't describe SSH_KEY_SECRET_ARN")
+	}
+
+	for id, stages := range out.VersionIdsToStages {
+		for _, stage := range aws.StringValueSlice(stages) {
+			if stage == "AWSCURRENT" {
+				c.versionID = id
+				return aws.StringValue(out.ARN) + ":" + id, nil
+			}
+		}
+	}
+
+	return "", errors.Errorf("Secret SSH_KEY_SECRET_ARN has no AWSCURRENT version")
+}
+
+func (c *secretCredentials) AuthMethod() (ssh.AuthMethod, error) {
+	params := &secretsmanager.GetSecretValueInput{SecretId: aws.String(c.secretID)}
+	if c.versionID != "" {
+		params.VersionId = aws.String(c.versionID)
+	}
+
+	out, err := c.client().GetSecretValue(params)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't get SSH_KEY_SECRET_ARN value")
+	}
+
+	// the value is moved into the buffer, the response isn't referenced elsewhere
+	if len(out.SecretBinary) == 0 {
+		if out.SecretString == nil {
+			return nil, errors.Errorf("Secret SSH_KEY_SECRET_ARN has no value")
+		}
+		return parseKey(moveSecretString(out.SecretString))
+	}
+
+	secret := newSecretBuffer(len(out.SecretBinary))
+	copy(secret.Bytes(), out.SecretBinary)
+	for i := range out.SecretBinary {
+		out.SecretBinary[i] = 0
+	}
+
+	return parseKey(secret)
+}
+
+// ssmCredentials reads the key from SecureString parameter SSH_KEY_SSM_PARAM
+// decrypted with KMS. Version is the parameter version, so the key is
+// fetched again only once the parameter is updated.
+type ssmCredentials struct {
+	name    string
+	version int64
+	svc     *ssm.SSM
+}
+
+func newSSMCredentials() CredentialProvider {
+	return &ssmCredentials{name: os.Getenv("SSH_KEY_SSM_PARAM")}
+}
+
+func (c *ssmCredentials) Configured() bool {
+	return c.name != ""
+}
+
+func (c *ssmCredentials) client() *ssm.SSM {
+	if c.svc == nil {
+		c.svc = ssm.New(awsSession())
+	}
+
+	return c.svc
+}
+
+// Version reads the parameter metadata, the value stays encrypted
+func (c *ssmCredentials) Version() (string, error) {
+	out, err := c.client().GetParameter(&ssm.GetParameterInput{Name: aws.String(c.name)})
+	if err != nil {
+		return "", errors.Wrap(err, "Can't get SSH_KEY_SSM_PARAM")
+	}
+	if aws.StringValue(out.Parameter.Type) != ssm.ParameterTypeSecureString {
+		return "", errors.Errorf("SSH_KEY_SSM_PARAM should be SecureString parameter, got %s", aws.StringValue(out.Parameter.Type))
+	}
+
+	c.version = aws.Int64Value(out.Parameter.Version)
+	return fmt.Sprintf("%s:%d", aws.StringValue(out.Parameter.ARN), c.version), nil
+}
+
+func (c *ssmCredentials) AuthMethod() (ssh.AuthMethod, error) {
+	name := c.name
+	if c.version > 0 {
+		name = fmt.Sprintf("%s:%d", c.name, c.version)
+	}
+
+	out, err := c.client().GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't decrypt SSH_KEY_SSM_PARAM")
+	}
+
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return nil, errors.Errorf("Parameter SSH_KEY_SSM_PARAM has no value")
+	}
+
+	// the value is moved into the buffer, the response isn't referenced elsewhere
+	return parseKey(moveSecretString(out.Parameter.Value))
+}
+
+// maxS3KeyBytes guards the buffer against objects which can't be keys
+const maxS3KeyBytes = 64 * 1024
+
+// s3Credentials reads the key from SSH_KEY_S3_URI object. Version is the ETag
+// and the version id of the object, so the key is downloaded again only once
+// the object is replaced. The key is never written to disk.
+type s3Credentials struct {
+	location  string
+	versionID string
+}
+
+func newS3Credentials() CredentialProvider {
+	return &s3Credentials{location: os.Getenv("SSH_KEY_S3_URI")}
+}
+
+func (c *s3Credentials) Configured() bool {
+	return c.location != ""
+}
+
+func (c *s3Credentials) Version() (string, error) {
+	bucket, key, err := parseS3Location(c.location)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid SSH_KEY_S3_URI")
+	}
+
+	out, err := s3.New(awsSession()).HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return "", errors.Wrapf(err, "Can't check %s", c.location)
+	}
+
+	c.versionID = aws.StringValue(out.VersionId)
+	return fmt.Sprintf("%s:%s:%s", c.location, c.versionID, aws.StringValue(out.ETag)), nil
+}
+
+// AuthMethod reads the object directly into the key buffer
+func (c *s3Credentials) AuthMethod() (ssh.AuthMethod, error) {
+	bucket, key, err := parseS3Location(c.location)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid SSH_KEY_S3_URI")
+	}
+
+	params := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if c.versionID != "" {
+		params.VersionId = aws.String(c.versionID)
+	}
+
+	out, err := s3.New(awsSession()).GetObject(params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't download %s", c.location)
+	}
+	defer out.Body.Close()
+
+	size := aws.Int64Value(out.ContentLength)
+	if size <= 0 || size > maxS3KeyBytes {
+		return nil, errors.Errorf("Can't use %s as ssh key: unexpected size %d bytes", c.location, size)
+	}
+
+	secret := newSecretBuffer(int(size))
+	if _, err := io.ReadFull(out.Body, secret.Bytes()); err != nil {
+		secret.Wipe()
+		return nil, errors.Wrapf(err, "Can't download %s", c.location)
+	}
+
+	return parseKey(secret)
+}
+
+// agentCredentials uses keys of the SSH agent listening on SSH_AUTH_SOCK.
+// The agent connection is not cached since it could be closed between invocations.
+type agentCredentials struct {
+	sock string
+}
+
+func newAgentCredentials() CredentialProvider {
+	return &agentCredentials{sock: os.Getenv("SSH_AUTH_SOCK")}
+}
+
+func (c *agentCredentials) Configured() bool {
+	return c.sock != ""
+}
+
+func (c *agentCredentials) Version() (string, error) {
+	return "", nil
+}
+
+func (c *agentCredentials) AuthMethod() (ssh.AuthMethod, error) {
+	agentConn, err := net.Dial("unix", c.sock)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't open connection to SSH agent: "+c.sock)
+	}
+
+	agentClient := agent.NewClient(agentConn)
+	return ssh.PublicKeysCallback(agentClient.Signers), nil
+}
+
+// eicKey is an ephemeral key generated once per process for EC2 Instance Connect
+var eicKey struct {
+	sync.Once
+	signer ssh.Signer
+	err    error
+}
+
+// eicCredentials pushes the ephemeral public key to every instance with
+// EC2 Instance Connect. The key is accepted by the instance for 60 seconds.
+type eicCredentials struct{}
+
+func newEICCredentials() CredentialProvider {
+	return &eicCredentials{}
+}
+
+// Configured is false since EC2 Instance Connect should be enabled explicitly
+func (c *eicCredentials) Configured() bool {
+	return false
+}
+
+func (c *eicCredentials) signer() (ssh.Signer, error) {
+	eicKey.Do(func() {
+		// EC2 Instance Connect accepts RSA keys only
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			eicKey.err = errors.Wrap(err, "Can't generate ephemeral key")
+			return
+		}
+
+		eicKey.signer, eicKey.err = ssh.NewSignerFromKey(key)
+	})
+
+	return eicKey.signer, eicKey.err
+}
+
+func (c *eicCredentials) Version() (string, error) {
+	signer, err := c.signer()
+	if err != nil {
+		return "", err
+	}
+
+	return ssh.FingerprintSHA256(signer.PublicKey()), nil
+}
+
+func (c *eicCredentials) AuthMethod() (ssh.AuthMethod, error) {
+	signer, err := c.signer()
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.PublicKeys(signer), nil
+}
+
+// Authorize sends the public key for every user since the instance
+// doesn't know which one will be accepted
+func (c *eicCredentials) Authorize(instance *InstanceInfo, users []string) error {
+	if instance.description == nil || instance.description.Placement == nil {
+		return errors.Errorf("Can't use EC2 Instance Connect for %s: instance is not discovered with EC2 API", instance.id)
+	}
+
+	signer, err := c.signer()
+	if err != nil {
+		return err
+	}
+
+	svc := ec2instanceconnect.New(awsSession(), aws.NewConfig().WithRegion(instance.region))
+	publicKey := string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+
+	for _, user := range users {
+		_, err := svc.SendSSHPublicKey(&ec2instanceconnect.SendSSHPublicKeyInput{
+			AvailabilityZone: instance.description.Placement.AvailabilityZone,
+			InstanceId:       aws.String(instance.id),
+			InstanceOSUser:   aws.String(user),
+			SSHPublicKey:     aws.String(publicKey),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Can't send ssh public key to %s for %s user", instance.id, user)
+		}
+	}
+
+	return nil
+}
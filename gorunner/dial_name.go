@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// dialNamePrivateDNS is DIAL_NAME value dialing ec2 instances by PrivateDnsName
+const dialNamePrivateDNS = "private-dns"
+
+// dialNamer builds the DNS name hosts are dialed by before their addresses
+type dialNamer struct {
+	privateDNS bool
+	tmpl       *template.Template
+}
+
+// getDialNamer returns the namer of DIAL_NAME: private-dns or a template
+// rendered with tags of the instance, e.g. {{.Name}}.prod.internal.
+// Nil is returned when it's not set.
+func getDialNamer() (*dialNamer, error) {
+	value := getEnv("DIAL_NAME", "")
+	if value == "" {
+		return nil, nil
+	}
+	if value == dialNamePrivateDNS {
+		return &dialNamer{privateDNS: true}, nil
+	}
+
+	tmpl, err := template.New("dial").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't parse DIAL_NAME")
+	}
+
+	return &dialNamer{tmpl: tmpl}, nil
+}
+
+// name returns the DNS name of the instance, empty if it has none
+// or the template refers to missing tags
+func (n *dialNamer) name(instance *InstanceInfo) string {
+	if n.privateDNS {
+		if instance.description == nil {
+			return ""
+		}
+		return aws.StringValue(instance.description.PrivateDnsName)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := n.tmpl.Execute(buf, instance.tags); err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+// getDNSResolver returns the resolver querying DNS_RESOLVER, e.g. the VPC resolver
+// 10.0.0.2, nil means the system resolver
+func getDNSResolver() (*net.Resolver, error) {
+	server := getEnv("DNS_RESOLVER", "")
+	if server == "" {
+		return nil, nil
+	}
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	if host, _, _ := net.SplitHostPort(server); net.ParseIP(host) == nil {
+		return nil, errors.Errorf("Invalid DNS_RESOLVER: '%s', IP address is expected", getEnv("DNS_RESOLVER", ""))
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server)
+		},
+	}, nil
+}
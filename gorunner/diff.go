@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+)
+
+// InstanceRef identifies an instance in the diff
+type InstanceRef struct {
+	InstanceId string
+	Name       string
+}
+
+// FactChange contains old and new values of the fact,
+// empty value means the fact was missing in the run
+type FactChange struct {
+	Old string
+	New string
+}
+
+// InstanceChange lists facts changed between two runs
+type InstanceChange struct {
+	InstanceRef
+	Facts map[string]FactChange
+}
+
+// RunDiff is a change report between two runs
+type RunDiff struct {
+	From    string
+	To      string
+	Added   []InstanceRef
+	Removed []InstanceRef
+	Changed []InstanceChange
+}
+
+// diffRuns compares instances and their facts in two runs
+func diffRuns(from, to *RunResult) *RunDiff {
+	diff := &RunDiff{
+		From:    from.RunID,
+		To:      to.RunID,
+		Added:   []InstanceRef{},
+		Removed: []InstanceRef{},
+		Changed: []InstanceChange{},
+	}
+
+	fromRows := rowsByID(from.Rows)
+	toRows := rowsByID(to.Rows)
+
+	for _, id := range sortedRowIDs(toRows) {
+		row := toRows[id]
+		old, ok := fromRows[id]
+		if !ok {
+			diff.Added = append(diff.Added, InstanceRef{InstanceId: id, Name: row.Name})
+			continue
+		}
+
+		// facts of unreachable instances are unknown rather than removed
+		if (old.Error != "" && len(old.Facts) == 0) || (row.Error != "" && len(row.Facts) == 0) {
+			continue
+		}
+
+		changes := diffFacts(old.Facts, row.Facts)
+		if len(changes) > 0 {
+			diff.Changed = append(diff.Changed, InstanceChange{
+				InstanceRef: InstanceRef{InstanceId: id, Name: row.Name},
+				Facts:       changes,
+			})
+		}
+	}
+
+	for _, id := range sortedRowIDs(fromRows) {
+		if _, ok := toRows[id]; !ok {
+			diff.Removed = append(diff.Removed, InstanceRef{InstanceId: id, Name: fromRows[id].Name})
+		}
+	}
+
+	return diff
+}
+
+func diffFacts(old, new map[string]string) map[string]FactChange {
+	changes := map[string]FactChange{}
+
+	for name, value := range new {
+		if oldValue, ok := old[name]; !ok || oldValue != value {
+			changes[name] = FactChange{Old: oldValue, New: value}
+		}
+	}
+
+	for name, oldValue := range old {
+		if _, ok := new[name]; !ok {
+			changes[name] = FactChange{Old: oldValue}
+		}
+	}
+
+	return changes
+}
+
+func rowsByID(rows []ResRow) map[string]ResRow {
+	byID := map[string]ResRow{}
+	for _, row := range rows {
+		byID[row.InstanceId] = row
+	}
+
+	return byID
+}
+
+func sortedRowIDs(rows map[string]ResRow) []string {
+	ids := []string{}
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
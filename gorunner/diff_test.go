@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestDiffRuns(t *testing.T) {
+	from := &RunResult{RunMeta: RunMeta{RunID: "a"}, Rows: []ResRow{
+		{InstanceId: "i-1", Name: "web-1", Facts: map[string]string{"kernel": "4.14", "host": "web-1"}},
+		{InstanceId: "i-2", Name: "web-2", Facts: map[string]string{"kernel": "4.14"}},
+		{InstanceId: "i-3", Name: "db-1", Facts: map[string]string{"kernel": "4.14"}},
+		{InstanceId: "i-4", Name: "db-2", Error: "timeout"},
+	}}
+	to := &RunResult{RunMeta: RunMeta{RunID: "b"}, Rows: []ResRow{
+		{InstanceId: "i-1", Name: "web-1", Facts: map[string]string{"kernel": "5.4", "uptime": "1"}},
+		{InstanceId: "i-2", Name: "web-2", Facts: map[string]string{"kernel": "4.14"}},
+		{InstanceId: "i-4", Name: "db-2", Facts: map[string]string{"kernel": "5.4"}},
+		{InstanceId: "i-5", Name: "db-3", Facts: map[string]string{"kernel": "5.4"}},
+	}}
+
+	want := &RunDiff{
+		From:    "a",
+		To:      "b",
+		Added:   []InstanceRef{{InstanceId: "i-5", Name: "db-3"}},
+		Removed: []InstanceRef{{InstanceId: "i-3", Name: "db-1"}},
+		Changed: []InstanceChange{{
+			InstanceRef: InstanceRef{InstanceId: "i-1", Name: "web-1"},
+			Facts: map[string]FactChange{
+				"kernel": {Old: "4.14", New: "5.4"},
+				"host":   {Old: "web-1"},
+				"uptime": {New: "1"},
+			},
+		}},
+	}
+
+	if got := diffRuns(from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffRuns() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffRunsSame(t *testing.T) {
+	run := &RunResult{RunMeta: RunMeta{RunID: "a"}, Rows: []ResRow{
+		{InstanceId: "i-1", Facts: map[string]string{"kernel": "4.14"}},
+	}}
+
+	diff := diffRuns(run, run)
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) != 0 {
+		t.Errorf("diffRuns() of the same run = %+v, want no changes", diff)
+	}
+}
+
+func TestRunDiffErrors(t *testing.T) {
+	defer os.Unsetenv("HISTORY_BUCKET")
+
+	tests := []struct {
+		name   string
+		bucket string
+		idA    string
+		status int
+	}{
+		{"history disabled", "", "20200101T000000Z", 404},
+		{"invalid run id", "runs", "../secret", 404},
+		{"empty run id", "runs", "", 404},
+	}
+
+	for _, tt := range tests {
+		os.Setenv("HISTORY_BUCKET", tt.bucket)
+
+		res, err := handleRunDiff(events.APIGatewayProxyRequest{
+			PathParameters: map[string]string{"idA": tt.idA, "idB": tt.idA},
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: status = %d, want %d (%s)", tt.name, res.StatusCode, tt.status, res.Body)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultDigestHours = "24"
+	digestNoTeam       = "unassigned"
+)
+
+// Digest aggregates changes and failures of stored runs for the period
+type Digest struct {
+	Since time.Time
+	Runs  []string
+	Teams []*TeamDigest
+}
+
+// TeamDigest is a section of instances having the same Team
+type TeamDigest struct {
+	Team    string
+	Added   []InstanceRef
+	Removed []InstanceRef
+	Changed []InstanceChange
+	// Failed lists the last error of instances failed in any run
+	Failed []FailedInstance
+}
+
+// FailedInstance is an instance failed in some runs of the period
+type FailedInstance struct {
+	InstanceRef
+	Error string
+	Runs  int
+}
+
+// isDigestEvent tells if the function is invoked to send the digest,
+// e.g. by a schedule event with {"digest": {}} input
+func isDigestEvent(event json.RawMessage) bool {
+	digest := struct {
+		Digest json.RawMessage `json:"digest"`
+	}{}
+
+	return json.Unmarshal(event, &digest) == nil && len(digest.Digest) > 0
+}
+
+// handleDigest sends the digest of runs stored for the last DIGEST_HOURS
+func (h *Handler) handleDigest(ctx context.Context) error {
+	hours, err := strconv.Atoi(getEnv("DIGEST_HOURS", defaultDigestHours))
+	if err != nil || hours <= 0 {
+		return errors.Errorf("Invalid DIGEST_HOURS: '%s'", getEnv("DIGEST_HOURS", ""))
+	}
+
+	digest, err := buildDigest(h.deps.Now().Add(-time.Duration(hours) * time.Hour))
+	if err != nil {
+		return err
+	}
+
+	return sendDigest(renderDigest(digest))
+}
+
+// buildDigest compares every run of the period with the previous one,
+// the latest run before the period is the baseline
+func buildDigest(since time.Time) (*Digest, error) {
+	ids, err := listRunIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	digest := &Digest{Since: since.UTC(), Runs: []string{}}
+	teams := map[string]*TeamDigest{}
+	team := func(name string) *TeamDigest {
+		if name == "" {
+			name = digestNoTeam
+		}
+		if teams[name] == nil {
+			teams[name] = &TeamDigest{Team: name}
+		}
+		return teams[name]
+	}
+
+	// only the latest run before the period is loaded
+	first := len(ids)
+	for i, id := range ids {
+		if started, err := runStartTime(id); err == nil && !started.Before(since) {
+			first = i
+			break
+		}
+	}
+
+	var prev *RunResult
+	if first > 0 {
+		if prev, err = loadRun(ids[first-1]); err != nil {
+			return nil, err
+		}
+	}
+
+	failed := map[string]*FailedInstance{}
+	failedTeams := map[string]string{}
+	for _, id := range ids[first:] {
+		run, err := loadRun(id)
+		if err != nil {
+			return nil, err
+		}
+		digest.Runs = append(digest.Runs, id)
+
+		teamsByID := map[string]string{}
+		for _, row := range run.Rows {
+			teamsByID[row.InstanceId] = row.Team
+
+			if row.Error == "" {
+				continue
+			}
+			f, ok := failed[row.InstanceId]
+			if !ok {
+				f = &FailedInstance{InstanceRef: InstanceRef{InstanceId: row.InstanceId, Name: row.Name}}
+				failed[row.InstanceId] = f
+			}
+			f.Error = row.Error
+			f.Runs++
+			failedTeams[row.InstanceId] = row.Team
+		}
+
+		if prev != nil {
+			diff := diffRuns(prev, run)
+			prevTeams := map[string]string{}
+			for _, row := range prev.Rows {
+				prevTeams[row.InstanceId] = row.Team
+			}
+
+			for _, ref := range diff.Added {
+				t := team(teamsByID[ref.InstanceId])
+				t.Added = append(t.Added, ref)
+			}
+			for _, ref := range diff.Removed {
+				t := team(prevTeams[ref.InstanceId])
+				t.Removed = append(t.Removed, ref)
+			}
+			for _, change := range diff.Changed {
+				t := team(teamsByID[change.InstanceId])
+				t.Changed = append(t.Changed, change)
+			}
+		}
+
+		prev = run
+	}
+
+	for id, f := range failed {
+		t := team(failedTeams[id])
+		t.Failed = append(t.Failed, *f)
+	}
+
+	for _, t := range teams {
+		sort.Slice(t.Failed, func(i, j int) bool { return t.Failed[i].InstanceId < t.Failed[j].InstanceId })
+		digest.Teams = append(digest.Teams, t)
+	}
+	sort.Slice(digest.Teams, func(i, j int) bool { return digest.Teams[i].Team < digest.Teams[j].Team })
+
+	return digest, nil
+}
+
+// renderDigest formats the digest as plain text readable in Slack and email
+func renderDigest(d *Digest) string {
+	loc, err := getDisplayLocation()
+	if err != nil {
+		loc = time.UTC
+	}
+
+	lines := []string{fmt.Sprintf("Fact collection digest since %s: %d run(s)", displayTime(d.Since, loc), len(d.Runs))}
+	if len(d.Teams) == 0 {
+		lines = append(lines, "No changes or failures")
+	}
+
+	for _, t := range d.Teams {
+		lines = append(lines, "", fmt.Sprintf("*%s*", t.Team))
+
+		for _, ref := range t.Added {
+			lines = append(lines, fmt.Sprintf("+ %s", instanceLabel(ref)))
+		}
+		for _, ref := range t.Removed {
+			lines = append(lines, fmt.Sprintf("- %s", instanceLabel(ref)))
+		}
+		for _, change := range t.Changed {
+			facts := []string{}
+			for name := range change.Facts {
+				facts = append(facts, name)
+			}
+			sort.Strings(facts)
+
+			for _, name := range facts {
+				c := change.Facts[name]
+				lines = append(lines, fmt.Sprintf("~ %s %s: %q -> %q", instanceLabel(change.InstanceRef), name, c.Old, c.New))
+			}
+		}
+		for _, f := range t.Failed {
+			lines = append(lines, fmt.Sprintf("! %s failed in %d run(s): %s", instanceLabel(f.InstanceRef), f.Runs, f.Error))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func instanceLabel(ref InstanceRef) string {
+	if ref.Name == "" {
+		return ref.InstanceId
+	}
+
+	return fmt.Sprintf("%s (%s)", ref.InstanceId, ref.Name)
+}
+
+// sendDigest posts the digest to DIGEST_SLACK_URL and emails it
+// from DIGEST_EMAIL_FROM to comma separated DIGEST_EMAIL_TO with SES
+func sendDigest(text string) error {
+	slackURL := getEnv("DIGEST_SLACK_URL", "")
+	from, to := getEnv("DIGEST_EMAIL_FROM", ""), splitList(getEnv("DIGEST_EMAIL_TO", ""))
+	if slackURL == "" && len(to) == 0 {
+		return errors.Errorf("You should provide DIGEST_SLACK_URL or DIGEST_EMAIL_TO")
+	}
+
+	if slackURL != "" {
+		body, _ := json.Marshal(map[string]string{"text": text})
+		resp, err := (&http.Client{Timeout: webhookTimeout}).Post(slackURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, "Can't post digest to Slack")
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return errors.Errorf("Slack responded with %s", resp.Status)
+		}
+	}
+
+	if len(to) > 0 {
+		if from == "" {
+			return errors.Errorf("You should provide DIGEST_EMAIL_FROM")
+		}
+
+		_, err := ses.New(awsSession()).SendEmail(&ses.SendEmailInput{
+			Source:      aws.String(from),
+			Destination: &ses.Destination{ToAddresses: aws.StringSlice(to)},
+			Message: &ses.Message{
+				Subject: &ses.Content{Data: aws.String("Fact collection digest")},
+				Body:    &ses.Body{Text: &ses.Content{Data: aws.String(text)}},
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "Can't email digest")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultDiscovery = "ec2"
+
+	// factsTag contains extra facts of the instance
+	factsTag = "gorunner:facts"
+)
+
+// InstanceInfo conatains host addresses, collected facts and source description
+type InstanceInfo struct {
+	id      string
+	name    string
+	source  string
+	account string
+	region  string
+	tags    map[string]string
+	addrs   []string
+
+	// description is set for instances discovered with EC2 API
+	description *ec2.Instance
+
+	facts       map[string]string
+	collected   map[string]interface{}
+	startedAt   time.Time
+	collectedAt time.Time
+	attempts    int
+
+	// dialAttempts counts connection attempts across all retries
+	dialAttempts int
+	err          error
+
+	// stateChanged is the state of the instance stopped during the run
+	stateChanged string
+
+	// sshUser and sshPort are set by static hosts and inventories
+	sshUser string
+	sshPort string
+
+	// user is the ssh user facts were collected with
+	user    string
+	session *SessionInfo
+
+	// variant is set when candidate facts are rolled out
+	variant        string
+	candidateFacts map[string]string
+
+	// cachedFacts are values of IMMUTABLE_FACTS reused from instances with the same AMI
+	cachedFacts map[string]string
+	// factHashes are hashes of commands of IMMUTABLE_FACTS the instance runs
+	factHashes map[string]string
+}
+
+// withTagFacts merges facts defined in the facts tag of the instance with
+// the global ones, global facts win on label conflicts
+func (inst *InstanceInfo) withTagFacts(factsToCollect map[string]string) map[string]string {
+	value, ok := inst.tags[factsTag]
+	if !ok {
+		return factsToCollect
+	}
+
+	tagFacts := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &tagFacts); err != nil {
+		log.Println(errors.Wrapf(err, "Can't parse %s tag of %s", factsTag, inst.id))
+		return factsToCollect
+	}
+
+	merged := map[string]string{}
+	for name, cmd := range tagFacts {
+		merged[name] = cmd
+	}
+	for name, cmd := range factsToCollect {
+		merged[name] = cmd
+	}
+
+	return merged
+}
+
+// port returns ssh port of the instance
+func (inst *InstanceInfo) port() string {
+	if inst.sshPort == "" {
+		return "22"
+	}
+
+	return inst.sshPort
+}
+
+// state returns EC2 state of the instance, empty for other sources
+func (inst *InstanceInfo) state() string {
+	if inst.description == nil || inst.description.State == nil {
+		return ""
+	}
+
+	return aws.StringValue(inst.description.State.Name)
+}
+
+// launchTime returns EC2 launch time of the instance, zero for other sources
+func (inst *InstanceInfo) launchTime() time.Time {
+	if inst.description == nil {
+		return time.Time{}
+	}
+
+	return aws.TimeValue(inst.description.LaunchTime)
+}
+
+// availabilityZone returns EC2 availability zone of the instance, empty for other sources
+func (inst *InstanceInfo) availabilityZone() string {
+	if inst.description == nil || inst.description.Placement == nil {
+		return ""
+	}
+
+	return aws.StringValue(inst.description.Placement.AvailabilityZone)
+}
+
+// network returns VPC and subnet of EC2 instance, empty for other sources
+func (inst *InstanceInfo) network() (string, string) {
+	if inst.description == nil {
+		return "", ""
+	}
+
+	return aws.StringValue(inst.description.VpcId), aws.StringValue(inst.description.SubnetId)
+}
+
+// lifecycle returns EC2 lifecycle of the instance: spot, scheduled or on-demand,
+// empty for other sources
+func (inst *InstanceInfo) lifecycle() string {
+	if inst.description == nil {
+		return ""
+	}
+	if lifecycle := aws.StringValue(inst.description.InstanceLifecycle); lifecycle != "" {
+		return lifecycle
+	}
+
+	return lifecycleOnDemand
+}
+
+// offline tells if EC2 instance is discovered in a state which doesn't allow
+// to connect, e.g. stopped. Such instances are reported with description only.
+func (inst *InstanceInfo) offline() bool {
+	state := inst.state()
+	return state != "" && state != ec2.InstanceStateNameRunning && state != ec2.InstanceStateNamePending
+}
+
+// onlineInstances drops offline instances
+func onlineInstances(instances []*InstanceInfo) []*InstanceInfo {
+	online := []*InstanceInfo{}
+	for _, inst := range instances {
+		if !inst.offline() {
+			online = append(online, inst)
+		}
+	}
+
+	return online
+}
+
+// pending tells if EC2 instance is still booting
+func (inst *InstanceInfo) pending() bool {
+	return inst.state() == ec2.InstanceStateNamePending
+}
+
+// commands returns fact commands of the instance including candidate
+// facts and session fingerprint
+func (inst *InstanceInfo) commands(factsToCollect map[string]string) map[string]string {
+	facts := inst.withTagFacts(factsToCollect)
+	if len(inst.candidateFacts) == 0 && len(inst.cachedFacts) == 0 && !sessionFingerprintEnabled() {
+		return facts
+	}
+
+	merged := map[string]string{}
+	for name, cmd := range facts {
+		if _, cached := inst.cachedFacts[name]; !cached {
+			merged[name] = cmd
+		}
+	}
+	for name, cmd := range inst.candidateFacts {
+		merged[candidatePrefix+name] = cmd
+	}
+	if sessionFingerprintEnabled() {
+		merged[sessionFact] = sessionCommand
+	}
+
+	return merged
+}
+
+// DiscoverySource finds instances to collect facts from
+type DiscoverySource interface {
+	Discover() ([]*InstanceInfo, error)
+}
+
+// discoverySources contains constructors for all registered sources
+var discoverySources = map[string]func() (DiscoverySource, error){
+	"ansible":   newAnsibleSource,
+	"config":    newConfigSource,
+	"dynamodb":  newDynamoDBSource,
+	"ec2":       newEC2Source,
+	hostsSource: newHostsSource,
+	"lightsail": newLightsailSource,
+	relaySource: newRelaySource,
+	"route53":   newRoute53Source,
+	"ssm":       newSSMSource,
+}
+
+// AccountError reports the account which failed to be discovered,
+// instances of other accounts are still collected
+type AccountError struct {
+	Account string
+	Region  string
+	Error   string
+}
+
+// PartialDiscovery is implemented by sources tolerating failures of some accounts,
+// AccountErrors are read after Discover or Lookup
+type PartialDiscovery interface {
+	AccountErrors() []AccountError
+}
+
+// discoverInstances finds instances using all sources listed in DISCOVERY,
+// instances found by several sources are collected once
+func discoverInstances() (instances []*InstanceInfo, accountErrors []AccountError, err error) {
+	instances = []*InstanceInfo{}
+	seen := map[string]bool{}
+
+	for _, name := range discoveryNames() {
+		source, err := newDiscoverySource(name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		found, err := source.Discover()
+		if err != nil {
+			return nil, nil, err
+		}
+		if partial, ok := source.(PartialDiscovery); ok {
+			accountErrors = append(accountErrors, partial.AccountErrors()...)
+		}
+
+		for _, inst := range found {
+			inst.source = name
+			if seen[inst.id] {
+				log.Printf("%s: %s is already discovered, skipping", name, inst.id)
+				continue
+			}
+
+			seen[inst.id] = true
+			instances = append(instances, inst)
+		}
+	}
+
+	return instances, accountErrors, nil
+}
+
+// InstanceLookup is implemented by discovery sources able to describe given instances only
+type InstanceLookup interface {
+	Lookup(ids []string) ([]*InstanceInfo, error)
+}
+
+// lookupInstances describes given instances with all discovery sources,
+// ok is false if some source doesn't support lookups
+func lookupInstances(ids []string) (instances []*InstanceInfo, accountErrors []AccountError, ok bool, err error) {
+	instances = []*InstanceInfo{}
+	seen := map[string]bool{}
+
+	for _, name := range discoveryNames() {
+		source, err := newDiscoverySource(name)
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		lookup, ok := source.(InstanceLookup)
+		if !ok {
+			return nil, nil, false, nil
+		}
+
+		found, err := lookup.Lookup(ids)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if partial, ok := source.(PartialDiscovery); ok {
+			accountErrors = append(accountErrors, partial.AccountErrors()...)
+		}
+
+		for _, inst := range found {
+			inst.source = name
+			if !seen[inst.id] {
+				seen[inst.id] = true
+				instances = append(instances, inst)
+			}
+		}
+	}
+
+	return instances, accountErrors, true, nil
+}
+
+// StateChecker is implemented by discovery sources able to tell the current
+// state of instances, e.g. running or terminated
+type StateChecker interface {
+	States(instances []*InstanceInfo) (map[string]string, error)
+}
+
+// recheckStates checks instances which failed to connect with their sources.
+// Instances stopped or terminated during the run are labeled, so they are not
+// counted as unreachable.
+func recheckStates(instances []*InstanceInfo) {
+	bySource := map[string][]*InstanceInfo{}
+	for _, inst := range instances {
+		if inst.err != nil && inst.facts == nil {
+			bySource[inst.source] = append(bySource[inst.source], inst)
+		}
+	}
+
+	for name, failed := range bySource {
+		source, err := newDiscoverySource(name)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		checker, ok := source.(StateChecker)
+		if !ok {
+			continue
+		}
+
+		states, err := checker.States(failed)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "Can't recheck state of instances from '%s' source", name))
+			continue
+		}
+
+		for _, inst := range failed {
+			if state, ok := states[inst.id]; ok && state != "running" {
+				inst.stateChanged = state
+				inst.err = errors.Errorf("State changed during run: %s", state)
+			}
+		}
+	}
+}
+
+// discoveryNames returns sources listed in DISCOVERY
+func discoveryNames() []string {
+	names := []string{}
+	for _, name := range strings.Split(getEnv("DISCOVERY", defaultDiscovery), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func newDiscoverySource(name string) (DiscoverySource, error) {
+	newSource, ok := discoverySources[name]
+	if !ok {
+		return nil, errors.Errorf("Unknown discovery source: '%s' (available: %s)", name, strings.Join(discoverySourceNames(), ", "))
+	}
+
+	source, err := newSource()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't setup '%s' discovery source", name)
+	}
+
+	return source, nil
+}
+
+func discoverySourceNames() []string {
+	names := []string{}
+	for name := range discoverySources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ansibleGroupTag prefixes tags of groups the host is a member of,
+// e.g. tag_filters={"group:web": "true"}
+const ansibleGroupTag = "group:"
+
+// ansibleGroup is a group of the inventory
+type ansibleGroup struct {
+	hosts    []string
+	vars     map[string]string
+	children []string
+}
+
+// ansibleInventory contains groups and vars of hosts in the order hosts are listed
+type ansibleInventory struct {
+	hosts    []string
+	hostVars map[string]map[string]string
+	groups   map[string]*ansibleGroup
+}
+
+// ansibleSource reads Ansible INI or YAML inventory from INVENTORY_S3_URI
+type ansibleSource struct {
+	location string
+}
+
+func newAnsibleSource() (DiscoverySource, error) {
+	location := getEnv("INVENTORY_S3_URI", "")
+	if location == "" {
+		return nil, errors.Errorf("You should provide INVENTORY_S3_URI")
+	}
+
+	return &ansibleSource{location: location}, nil
+}
+
+// Discover returns inventory hosts, the inventory hostname is the id of the host.
+// Hosts are contacted at ansible_host as ansible_user on ansible_port when set.
+func (s *ansibleSource) Discover() ([]*InstanceInfo, error) {
+	content, err := readS3Object(s.location)
+	if err != nil {
+		return nil, err
+	}
+
+	var inventory *ansibleInventory
+	switch path.Ext(s.location) {
+	case ".yml", ".yaml":
+		inventory, err = parseAnsibleYAML(content)
+	default:
+		inventory, err = parseAnsibleINI(content)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't parse inventory %s", s.location)
+	}
+
+	instances, err := inventory.instances()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Ansible: found %v host(s) in %s", len(instances), s.location)
+
+	return instances, nil
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		hostVars: map[string]map[string]string{},
+		groups:   map[string]*ansibleGroup{},
+	}
+}
+
+func (inv *ansibleInventory) group(name string) *ansibleGroup {
+	if inv.groups[name] == nil {
+		inv.groups[name] = &ansibleGroup{vars: map[string]string{}}
+	}
+
+	return inv.groups[name]
+}
+
+// addHost adds the host to the group, vars of the host are merged
+func (inv *ansibleInventory) addHost(group, host string, vars map[string]string) {
+	if _, ok := inv.hostVars[host]; !ok {
+		inv.hosts = append(inv.hosts, host)
+		inv.hostVars[host] = map[string]string{}
+	}
+	for k, v := range vars {
+		inv.hostVars[host][k] = v
+	}
+
+	if group != "" {
+		g := inv.group(group)
+		g.hosts = append(g.hosts, host)
+	}
+}
+
+// hostGroups returns groups of the host with their parents
+func (inv *ansibleInventory) hostGroups(host string) map[string]bool {
+	parents := map[string][]string{}
+	for name, g := range inv.groups {
+		for _, child := range g.children {
+			parents[child] = append(parents[child], name)
+		}
+	}
+
+	groups := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		if groups[name] {
+			return
+		}
+		groups[name] = true
+		for _, parent := range parents[name] {
+			visit(parent)
+		}
+	}
+
+	for name, g := range inv.groups {
+		for _, h := range g.hosts {
+			if h == host {
+				visit(name)
+			}
+		}
+	}
+
+	return groups
+}
+
+// depth is the length of the longest path from top level groups,
+// vars of deeper groups win like in Ansible
+func (inv *ansibleInventory) depth(name string, seen map[string]bool) int {
+	if seen[name] {
+		return 0
+	}
+	seen[name] = true
+	defer delete(seen, name)
+
+	depth := 0
+	for parent, g := range inv.groups {
+		for _, child := range g.children {
+			if child == name {
+				if d := inv.depth(parent, seen) + 1; d > depth {
+					depth = d
+				}
+			}
+		}
+	}
+
+	return depth
+}
+
+// vars merges vars of the all group, other groups of the host and the host itself
+func (inv *ansibleInventory) vars(host string) map[string]string {
+	groups := []string{}
+	for name := range inv.hostGroups(host) {
+		if name != "all" {
+			groups = append(groups, name)
+		}
+	}
+
+	depths := map[string]int{}
+	for _, name := range groups {
+		depths[name] = inv.depth(name, map[string]bool{})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if depths[groups[i]] != depths[groups[j]] {
+			return depths[groups[i]] < depths[groups[j]]
+		}
+		return groups[i] < groups[j]
+	})
+
+	vars := map[string]string{}
+	for _, name := range append([]string{"all"}, groups...) {
+		if g, ok := inv.groups[name]; ok {
+			for k, v := range g.vars {
+				vars[k] = v
+			}
+		}
+	}
+	for k, v := range inv.hostVars[host] {
+		vars[k] = v
+	}
+
+	return vars
+}
+
+// instances converts inventory hosts, vars and group tags are tags of the instance
+func (inv *ansibleInventory) instances() ([]*InstanceInfo, error) {
+	instances := []*InstanceInfo{}
+
+	for _, host := range inv.hosts {
+		inst := &InstanceInfo{id: host, name: host, tags: inv.vars(host)}
+		inst.tags[ansibleGroupTag+"all"] = "true"
+		for group := range inv.hostGroups(host) {
+			inst.tags[ansibleGroupTag+group] = "true"
+		}
+
+		address := host
+		if value := inst.tags["ansible_host"]; value != "" {
+			address = value
+		}
+		inst.addrs = []string{address}
+		inst.sshUser = inst.tags["ansible_user"]
+
+		if port := inst.tags["ansible_port"]; port != "" {
+			if !validPort(port) {
+				return nil, errors.Errorf("Invalid ansible_port of host %s: '%s'", host, port)
+			}
+			inst.sshPort = port
+		}
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
+
+// parseAnsibleINI parses INI inventory: hosts with inline vars in [group] sections,
+// [group:vars] and [group:children]. Hosts before the first section are ungrouped.
+func parseAnsibleINI(content string) (*ansibleInventory, error) {
+	inv := newAnsibleInventory()
+	group, kind := "ungrouped", ""
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, errors.Errorf("Invalid section: '%s'", line)
+			}
+
+			section := strings.Split(strings.Trim(line, "[]"), ":")
+			if len(section) > 2 || section[0] == "" {
+				return nil, errors.Errorf("Invalid section: '%s'", line)
+			}
+			group, kind = section[0], ""
+			if len(section) == 2 {
+				kind = section[1]
+			}
+			if kind != "" && kind != "vars" && kind != "children" {
+				return nil, errors.Errorf("Unknown section type: '%s'", line)
+			}
+
+			inv.group(group)
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.Errorf("Invalid variable: '%s'", line)
+			}
+			inv.group(group).vars[strings.TrimSpace(kv[0])] = unquote(strings.TrimSpace(kv[1]))
+		case "children":
+			g := inv.group(group)
+			g.children = append(g.children, line)
+			inv.group(line)
+		default:
+			fields := splitQuoted(line)
+			vars := map[string]string{}
+			for _, field := range fields[1:] {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					return nil, errors.Errorf("Invalid host variable: '%s'", field)
+				}
+				vars[kv[0]] = unquote(kv[1])
+			}
+
+			hosts, err := expandHostPattern(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			for _, host := range hosts {
+				inv.addHost(group, host, vars)
+			}
+		}
+	}
+
+	return inv, scanner.Err()
+}
+
+// yamlAnsibleGroup is a group of YAML inventory
+type yamlAnsibleGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*yamlAnsibleGroup      `yaml:"children"`
+}
+
+// parseAnsibleYAML parses YAML inventory: top level groups (usually all)
+// with hosts, vars and nested children groups
+func parseAnsibleYAML(content string) (*ansibleInventory, error) {
+	groups := map[string]*yamlAnsibleGroup{}
+	if err := yaml.Unmarshal([]byte(content), &groups); err != nil {
+		return nil, err
+	}
+
+	inv := newAnsibleInventory()
+
+	var add func(name string, g *yamlAnsibleGroup) error
+	add = func(name string, g *yamlAnsibleGroup) error {
+		group := inv.group(name)
+		if g == nil {
+			return nil
+		}
+
+		for k, v := range g.Vars {
+			group.vars[k] = fmt.Sprint(v)
+		}
+
+		patterns := []string{}
+		for pattern := range g.Hosts {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+
+		for _, pattern := range patterns {
+			vars := map[string]string{}
+			for k, v := range g.Hosts[pattern] {
+				vars[k] = fmt.Sprint(v)
+			}
+
+			hosts, err := expandHostPattern(pattern)
+			if err != nil {
+				return err
+			}
+			for _, host := range hosts {
+				inv.addHost(name, host, vars)
+			}
+		}
+
+		for child, cg := range g.Children {
+			group.children = append(group.children, child)
+			if err := add(child, cg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for name, g := range groups {
+		if err := add(name, g); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(inv.hosts)
+
+	return inv, nil
+}
+
+// expandHostPattern expands numeric and alphabetic ranges, e.g. web[01:03] or db-[a:c]
+func expandHostPattern(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "]")
+	if end < 0 {
+		return nil, errors.Errorf("Invalid host pattern: '%s'", pattern)
+	}
+	end += start
+
+	bounds := strings.Split(pattern[start+1:end], ":")
+	if len(bounds) != 2 || bounds[0] == "" || bounds[1] == "" {
+		return nil, errors.Errorf("Invalid host range: '%s'", pattern)
+	}
+
+	values := []string{}
+	from, errFrom := strconv.Atoi(bounds[0])
+	to, errTo := strconv.Atoi(bounds[1])
+	switch {
+	case errFrom == nil && errTo == nil && from <= to:
+		// leading zeros of the start keep the width
+		format := "%d"
+		if len(bounds[0]) > 1 && strings.HasPrefix(bounds[0], "0") {
+			format = fmt.Sprintf("%%0%dd", len(bounds[0]))
+		}
+		for i := from; i <= to; i++ {
+			values = append(values, fmt.Sprintf(format, i))
+		}
+	case errFrom != nil && errTo != nil && len(bounds[0]) == 1 && len(bounds[1]) == 1 && bounds[0] <= bounds[1]:
+		for c := bounds[0][0]; c <= bounds[1][0]; c++ {
+			values = append(values, string(c))
+		}
+	default:
+		return nil, errors.Errorf("Invalid host range: '%s'", pattern)
+	}
+
+	hosts := []string{}
+	for _, value := range values {
+		rest, err := expandHostPattern(pattern[end+1:])
+		if err != nil {
+			return nil, err
+		}
+		for _, suffix := range rest {
+			hosts = append(hosts, pattern[:start]+value+suffix)
+		}
+	}
+
+	return hosts, nil
+}
+
+// splitQuoted splits the line by spaces outside of quotes
+func splitQuoted(line string) []string {
+	fields := []string{}
+	field, quote := "", rune(0)
+
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			field += string(c)
+		case c == '"' || c == '\'':
+			quote = c
+			field += string(c)
+		case c == ' ' || c == '\t':
+			if field != "" {
+				fields = append(fields, field)
+			}
+			field = ""
+		default:
+			field += string(c)
+		}
+	}
+	if field != "" {
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
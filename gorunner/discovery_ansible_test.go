@@ -0,0 +1,164 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ansibleHost is what a test expects of an inventory host
+type ansibleHost struct {
+	addr   string
+	user   string
+	port   string
+	groups []string
+	vars   map[string]string
+}
+
+func ansibleHosts(instances []*InstanceInfo) map[string]ansibleHost {
+	hosts := map[string]ansibleHost{}
+	for _, inst := range instances {
+		h := ansibleHost{addr: inst.addrs[0], user: inst.sshUser, port: inst.sshPort, vars: map[string]string{}}
+		for k, v := range inst.tags {
+			if strings.HasPrefix(k, ansibleGroupTag) {
+				h.groups = append(h.groups, strings.TrimPrefix(k, ansibleGroupTag))
+			} else if !strings.HasPrefix(k, "ansible_") {
+				h.vars[k] = v
+			}
+		}
+		sort.Strings(h.groups)
+		hosts[inst.id] = h
+	}
+
+	return hosts
+}
+
+func TestParseAnsibleINI(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		hosts   map[string]ansibleHost
+		err     string
+	}{
+		{
+			name:    "ungrouped hosts",
+			content: "web-1\n# comment\n; comment\n\nweb-2\n",
+			hosts: map[string]ansibleHost{
+				"web-1": {addr: "web-1", groups: []string{"all", "ungrouped"}, vars: map[string]string{}},
+				"web-2": {addr: "web-2", groups: []string{"all", "ungrouped"}, vars: map[string]string{}},
+			},
+		},
+		{
+			name:    "connection vars",
+			content: "[web]\nweb-1 ansible_host=10.0.0.1 ansible_user=centos ansible_port=2222\n",
+			hosts: map[string]ansibleHost{
+				"web-1": {addr: "10.0.0.1", user: "centos", port: "2222", groups: []string{"all", "web"}, vars: map[string]string{}},
+			},
+		},
+		{
+			name:    "quoted vars",
+			content: "[web]\nweb-1 role=\"front end\" tier='1'\n",
+			hosts: map[string]ansibleHost{
+				"web-1": {addr: "web-1", groups: []string{"all", "web"}, vars: map[string]string{"role": "front end", "tier": "1"}},
+			},
+		},
+		{
+			name:    "host ranges",
+			content: "[web]\nweb-[01:02]\ndb-[a:b]\n",
+			hosts: map[string]ansibleHost{
+				"web-01": {addr: "web-01", groups: []string{"all", "web"}, vars: map[string]string{}},
+				"web-02": {addr: "web-02", groups: []string{"all", "web"}, vars: map[string]string{}},
+				"db-a":   {addr: "db-a", groups: []string{"all", "web"}, vars: map[string]string{}},
+				"db-b":   {addr: "db-b", groups: []string{"all", "web"}, vars: map[string]string{}},
+			},
+		},
+		{
+			name: "children inherit vars of parents, deeper groups win",
+			content: "[web]\nweb-1\n[prod:children]\nweb\n[all:vars]\nenv=dev\nansible_user=ec2-user\n" +
+				"[prod:vars]\nenv=prod\nteam=ops\n[web:vars]\nteam=web\n",
+			hosts: map[string]ansibleHost{
+				"web-1": {addr: "web-1", user: "ec2-user", groups: []string{"all", "prod", "web"}, vars: map[string]string{"env": "prod", "team": "web"}},
+			},
+		},
+		{
+			name:    "host vars win over group vars",
+			content: "[web]\nweb-1 team=edge ansible_port=22\n[web:vars]\nteam=web\nansible_port=2222\n",
+			hosts: map[string]ansibleHost{
+				"web-1": {addr: "web-1", port: "22", groups: []string{"all", "web"}, vars: map[string]string{"team": "edge"}},
+			},
+		},
+		{name: "unclosed section", content: "[web\nweb-1\n", err: "Invalid section: '[web'"},
+		{name: "empty section", content: "[]\nweb-1\n", err: "Invalid section: '[]'"},
+		{name: "unknown section type", content: "[web:hosts]\nweb-1\n", err: "Unknown section type: '[web:hosts]'"},
+		{name: "variable without value", content: "[web:vars]\nteam\n", err: "Invalid variable: 'team'"},
+		{name: "host variable without value", content: "[web]\nweb-1 team\n", err: "Invalid host variable: 'team'"},
+		{name: "invalid host range", content: "[web]\nweb-[1:a]\n", err: "Invalid host range: 'web-[1:a]'"},
+		{name: "invalid port", content: "[web]\nweb-1 ansible_port=ssh\n", err: "Invalid ansible_port of host web-1: 'ssh'"},
+	}
+
+	for _, tt := range tests {
+		inv, err := parseAnsibleINI(tt.content)
+		var instances []*InstanceInfo
+		if err == nil {
+			instances, err = inv.instances()
+		}
+
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		if hosts := ansibleHosts(instances); !reflect.DeepEqual(hosts, tt.hosts) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, hosts, tt.hosts)
+		}
+	}
+}
+
+func TestParseAnsibleYAML(t *testing.T) {
+	content := `
+all:
+  vars:
+    ansible_user: ec2-user
+  children:
+    prod:
+      vars:
+        env: prod
+      children:
+        web:
+          hosts:
+            web-[1:2]:
+              ansible_port: 2222
+            web-3:
+              ansible_host: 10.0.0.3
+`
+
+	inv, err := parseAnsibleYAML(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, err := inv.instances()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := []string{"all", "prod", "web"}
+	want := map[string]ansibleHost{
+		"web-1": {addr: "web-1", user: "ec2-user", port: "2222", groups: groups, vars: map[string]string{"env": "prod"}},
+		"web-2": {addr: "web-2", user: "ec2-user", port: "2222", groups: groups, vars: map[string]string{"env": "prod"}},
+		"web-3": {addr: "10.0.0.3", user: "ec2-user", groups: groups, vars: map[string]string{"env": "prod"}},
+	}
+	if hosts := ansibleHosts(instances); !reflect.DeepEqual(hosts, want) {
+		t.Errorf("got %+v, want %+v", hosts, want)
+	}
+
+	if _, err := parseAnsibleYAML("all: [web-1"); err == nil {
+		t.Error("malformed YAML is parsed")
+	}
+}
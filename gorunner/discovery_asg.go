@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+// asgMembers resolves ids of instances of Auto Scaling Groups in the target
+func (t ec2Target) asgMembers(names []string) ([]string, error) {
+	ids := []string{}
+	found := map[string]bool{}
+
+	params := &autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: aws.StringSlice(names)}
+	err := t.asg.DescribeAutoScalingGroupsPages(params, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		for _, group := range page.AutoScalingGroups {
+			found[aws.StringValue(group.AutoScalingGroupName)] = true
+			for _, instance := range group.Instances {
+				ids = append(ids, aws.StringValue(instance.InstanceId))
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't fetch auto scaling groups in %s", t.name())
+	}
+
+	for _, name := range names {
+		if !found[name] {
+			log.Printf("AWS: auto scaling group %s is not found in %s", name, t.name())
+		}
+	}
+
+	return ids, nil
+}
+
+// ec2MaxFilterValues is the maximum number of values of a DescribeInstances filter
+const ec2MaxFilterValues = 200
+
+// targetInputs limits params to members of ASG_NAMES, container instances
+// of ECS_CLUSTERS and nodes of EKS_CLUSTERS in the target. Members are split
+// into batches fitting the instance-id filter, one input per batch. The target
+// should be skipped if there are no inputs.
+func (s *ec2Source) targetInputs(target ec2Target, params *ec2.DescribeInstancesInput) ([]*ec2.DescribeInstancesInput, error) {
+	if len(s.asgNames) == 0 && len(s.ecsClusters) == 0 && len(s.eksClusters) == 0 {
+		return []*ec2.DescribeInstancesInput{params}, nil
+	}
+
+	ids := []string{}
+	if len(s.asgNames) > 0 {
+		asgIDs, err := target.asgMembers(s.asgNames)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, asgIDs...)
+	}
+	if len(s.ecsClusters) > 0 {
+		ecsIDs, err := target.ecsMembers(s.ecsClusters)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, ecsIDs...)
+	}
+	if len(s.eksClusters) > 0 {
+		eksIDs, err := target.eksMembers(s.eksClusters)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, eksIDs...)
+	}
+
+	members := map[string]bool{}
+	for _, id := range ids {
+		members[id] = true
+	}
+
+	filters := []*ec2.Filter{}
+	for _, f := range params.Filters {
+		if aws.StringValue(f.Name) != "instance-id" {
+			filters = append(filters, f)
+			continue
+		}
+
+		// intersect with ids looked up explicitly
+		requested := map[string]bool{}
+		for _, id := range aws.StringValueSlice(f.Values) {
+			requested[id] = true
+		}
+		for id := range members {
+			if !requested[id] {
+				delete(members, id)
+			}
+		}
+	}
+
+	ids = []string{}
+	for id := range members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	inputs := []*ec2.DescribeInstancesInput{}
+	for start := 0; start < len(ids); start += ec2MaxFilterValues {
+		end := start + ec2MaxFilterValues
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		limited := *params
+		limited.Filters = append(append([]*ec2.Filter{}, filters...),
+			&ec2.Filter{Name: aws.String("instance-id"), Values: aws.StringSlice(ids[start:end])})
+		inputs = append(inputs, &limited)
+	}
+
+	return inputs, nil
+}
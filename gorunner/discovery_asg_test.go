@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakeASG returns a single group with the given members
+type fakeASG struct {
+	name string
+	ids  []string
+}
+
+func (f *fakeASG) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	group := &autoscaling.Group{AutoScalingGroupName: aws.String(f.name)}
+	for _, id := range f.ids {
+		group.Instances = append(group.Instances, &autoscaling.Instance{InstanceId: aws.String(id)})
+	}
+	fn(&autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{group}}, true)
+
+	return nil
+}
+
+func TestTargetInputs(t *testing.T) {
+	ids := []string{}
+	for i := 0; i < 2*ec2MaxFilterValues+1; i++ {
+		ids = append(ids, fmt.Sprintf("i-%04d", i))
+	}
+
+	running := &ec2.Filter{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"running"})}
+
+	tests := []struct {
+		name      string
+		asgNames  []string
+		members   []string
+		requested []string
+		batches   [][]string
+	}{
+		{"without groups", nil, nil, nil, [][]string{nil}},
+		{"single batch", []string{"web"}, []string{"i-2", "i-1"}, nil, [][]string{{"i-1", "i-2"}}},
+		{"batches of max filter values", []string{"web"}, ids, nil, [][]string{ids[:200], ids[200:400], ids[400:]}},
+		{"intersected with requested ids", []string{"web"}, []string{"i-1", "i-2"}, []string{"i-2", "i-3"}, [][]string{{"i-2"}}},
+		{"no requested members", []string{"web"}, []string{"i-1"}, []string{"i-3"}, [][]string{}},
+		{"empty group", []string{"web"}, nil, nil, [][]string{}},
+	}
+
+	for _, tt := range tests {
+		s := &ec2Source{asgNames: tt.asgNames}
+		target := ec2Target{region: "us-east-1", asg: &fakeASG{name: "web", ids: tt.members}}
+
+		params := &ec2.DescribeInstancesInput{Filters: []*ec2.Filter{running}}
+		if tt.requested != nil {
+			params.Filters = append(params.Filters, &ec2.Filter{Name: aws.String("instance-id"), Values: aws.StringSlice(tt.requested)})
+		}
+
+		inputs, err := s.targetInputs(target, params)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		batches := [][]string{}
+		for _, input := range inputs {
+			var batch []string
+			for _, f := range input.Filters {
+				if aws.StringValue(f.Name) == "instance-id" {
+					batch = aws.StringValueSlice(f.Values)
+				}
+			}
+			if input.Filters[0] != running {
+				t.Errorf("%s: other filters are not kept: %v", tt.name, input.Filters)
+			}
+			batches = append(batches, batch)
+		}
+		if !reflect.DeepEqual(batches, tt.batches) {
+			t.Errorf("%s: got batches %v, want %v", tt.name, batches, tt.batches)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/pkg/errors"
+)
+
+// configInstancesQuery selects running EC2 instances recorded by AWS Config
+const configInstancesQuery = "SELECT resourceId, accountId, awsRegion, tags, configuration.privateIpAddress, configuration.publicIpAddress " +
+	"WHERE resourceType = 'AWS::EC2::Instance' AND configuration.state.name = 'running'"
+
+// configResource is a result of the aggregator query
+type configResource struct {
+	ResourceID    string `json:"resourceId"`
+	AccountID     string `json:"accountId"`
+	AWSRegion     string `json:"awsRegion"`
+	Configuration struct {
+		PrivateIPAddress string `json:"privateIpAddress"`
+		PublicIPAddress  string `json:"publicIpAddress"`
+	} `json:"configuration"`
+	Tags []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"tags"`
+}
+
+// configSource finds EC2 instances of all accounts and regions of
+// the AWS Config aggregator CONFIG_AGGREGATOR, no role is assumed
+type configSource struct {
+	aggregator  string
+	addressMode string
+	svc         *configservice.ConfigService
+}
+
+func newConfigSource() (DiscoverySource, error) {
+	aggregator := getEnv("CONFIG_AGGREGATOR", "")
+	if aggregator == "" {
+		return nil, errors.Errorf("You should provide CONFIG_AGGREGATOR")
+	}
+
+	mode, err := getAddressMode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &configSource{aggregator: aggregator, addressMode: mode, svc: configservice.New(awsSession())}, nil
+}
+
+// Discover returns running instances recorded by the aggregator,
+// instances opted out with the exclude tag are skipped
+func (s *configSource) Discover() ([]*InstanceInfo, error) {
+	instances, err := s.query(configInstancesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("AWS Config: found %v running instance(s) in %s aggregator", len(instances), s.aggregator)
+
+	return instances, nil
+}
+
+// Lookup selects given instances only
+func (s *configSource) Lookup(ids []string) ([]*InstanceInfo, error) {
+	quoted := []string{}
+	for _, id := range ids {
+		quoted = append(quoted, "'"+strings.Replace(id, "'", "", -1)+"'")
+	}
+
+	return s.query(fmt.Sprintf("%s AND resourceId IN (%s)", configInstancesQuery, strings.Join(quoted, ", ")))
+}
+
+func (s *configSource) query(expression string) ([]*InstanceInfo, error) {
+	instances := []*InstanceInfo{}
+
+	var parseErr error
+	params := &configservice.SelectAggregateResourceConfigInput{
+		ConfigurationAggregatorName: aws.String(s.aggregator),
+		Expression:                  aws.String(expression),
+	}
+	err := s.svc.SelectAggregateResourceConfigPages(params, func(page *configservice.SelectAggregateResourceConfigOutput, lastPage bool) bool {
+		for _, result := range page.Results {
+			resource := configResource{}
+			if parseErr = json.Unmarshal([]byte(aws.StringValue(result)), &resource); parseErr != nil {
+				return false
+			}
+
+			inst := resource.instance(s.addressMode)
+			if strings.EqualFold(strings.TrimSpace(inst.tags[excludeTag]), "true") {
+				continue
+			}
+			instances = append(instances, inst)
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't query %s aggregator", s.aggregator)
+	}
+	if parseErr != nil {
+		return nil, errors.Wrapf(parseErr, "Can't parse result of %s aggregator", s.aggregator)
+	}
+
+	return instances, nil
+}
+
+// instance converts the resource, addresses are ordered by ADDRESS_MODE
+func (r configResource) instance(addressMode string) *InstanceInfo {
+	inst := &InstanceInfo{
+		id:      r.ResourceID,
+		account: r.AccountID,
+		region:  r.AWSRegion,
+		tags:    map[string]string{},
+	}
+
+	for _, tag := range r.Tags {
+		inst.tags[tag.Key] = tag.Value
+	}
+	inst.name = inst.tags["Name"]
+
+	inst.addrs = instanceAddrs([]string{r.Configuration.PrivateIPAddress}, []string{r.Configuration.PublicIPAddress}, addressMode)
+
+	return inst
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/pkg/errors"
+)
+
+// inventoryItem is a target stored in INVENTORY_TABLE, Id defaults to Host
+// and Name tag names the host
+type inventoryItem struct {
+	Id   string
+	Host string
+	User string
+	Port string
+	Tags map[string]string
+}
+
+// dynamoDBSource reads targets maintained by teams in INVENTORY_TABLE,
+// so no EC2 API is called
+type dynamoDBSource struct {
+	table string
+	svc   *dynamodb.DynamoDB
+}
+
+func newDynamoDBSource() (DiscoverySource, error) {
+	table := getEnv("INVENTORY_TABLE", "")
+	if table == "" {
+		return nil, errors.Errorf("You should provide INVENTORY_TABLE")
+	}
+
+	return &dynamoDBSource{table: table, svc: dynamodb.New(awsSession())}, nil
+}
+
+// Discover scans the table, invalid items are skipped
+func (s *dynamoDBSource) Discover() ([]*InstanceInfo, error) {
+	instances := []*InstanceInfo{}
+
+	err := s.svc.ScanPages(&dynamodb.ScanInput{TableName: aws.String(s.table)}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			inst, err := parseInventoryItem(item)
+			if err != nil {
+				log.Println(errors.Wrapf(err, "Skipping item of %s", s.table))
+				continue
+			}
+
+			instances = append(instances, inst)
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't scan %s", s.table)
+	}
+
+	log.Printf("DynamoDB: found %v host(s) in %s", len(instances), s.table)
+
+	return instances, nil
+}
+
+func parseInventoryItem(attrs map[string]*dynamodb.AttributeValue) (*InstanceInfo, error) {
+	item := inventoryItem{}
+	if err := dynamodbattribute.UnmarshalMap(attrs, &item); err != nil {
+		return nil, err
+	}
+
+	if item.Host == "" {
+		return nil, errors.Errorf("Host is missing")
+	}
+	if item.Id == "" {
+		item.Id = item.Host
+	}
+	if item.Port != "" && !validPort(item.Port) {
+		return nil, errors.Errorf("Invalid port of %s: '%s'", item.Id, item.Port)
+	}
+	if item.Tags == nil {
+		item.Tags = map[string]string{}
+	}
+
+	name := item.Tags["Name"]
+	if name == "" {
+		name = item.Host
+	}
+
+	return &InstanceInfo{
+		id:      item.Id,
+		name:    name,
+		tags:    item.Tags,
+		addrs:   []string{item.Host},
+		sshUser: item.User,
+		sshPort: item.Port,
+	}, nil
+}
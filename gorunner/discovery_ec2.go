@@ -0,0 +1,571 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/pkg/errors"
+)
+
+const (
+	// excludeTag opts the instance out from discovery
+	excludeTag = "gorunner:exclude"
+
+	defaultInventoryCheckMaxResults = "1000"
+	defaultInstanceStates           = "running,pending"
+)
+
+// ec2Source finds and describes (aws describe) all running instances
+// in every region listed in REGIONS of the own account, accounts of ASSUME_ROLES
+// and accounts of the organization when ORGANIZATION_ROLE_NAME is set.
+// Other states could be listed in INSTANCE_STATES.
+type ec2Source struct {
+	targets []ec2Target
+
+	// tagFilters are set with TAG_FILTERS, values may contain * wildcards
+	tagFilters map[string]string
+
+	// filters are network filters set with VPC_IDS, SUBNET_IDS and SECURITY_GROUP_IDS
+	// and raw filters of EC2_FILTERS
+	filters []*ec2.Filter
+
+	// asgNames limits instances to members of Auto Scaling Groups listed in ASG_NAMES
+	asgNames []string
+
+	// ecsClusters limits instances to container instances of ECS clusters listed in ECS_CLUSTERS
+	ecsClusters []string
+
+	// eksClusters limits instances to worker nodes of EKS clusters listed in EKS_CLUSTERS
+	eksClusters []string
+
+	// states are instance states listed in INSTANCE_STATES
+	states []string
+
+	// addressMode is ADDRESS_MODE
+	addressMode string
+
+	// concurrency limits accounts and regions described at once
+	concurrency int
+
+	// accountErrors are failures of assumed accounts of the last Discover or Lookup
+	accountErrors []AccountError
+
+	// newClient creates EC2 clients of targets
+	newClient ec2ClientFactory
+}
+
+// ec2Target is a region of the account, account is empty for the own one
+type ec2Target struct {
+	account string
+	region  string
+	svc     ec2API
+
+	// asg is set when ASG_NAMES or EKS_CLUSTERS are given
+	asg asgAPI
+
+	// ecs is set when ECS_CLUSTERS are given
+	ecs *ecs.ECS
+
+	// eks is set when EKS_CLUSTERS are given
+	eks *eks.EKS
+}
+
+// asgAPI is the part of Auto Scaling API used to resolve group members
+type asgAPI interface {
+	DescribeAutoScalingGroupsPages(*autoscaling.DescribeAutoScalingGroupsInput, func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error
+}
+
+// ec2API is the part of EC2 API used by discovery
+type ec2API interface {
+	DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+	DescribeVpcs(*ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error)
+}
+
+// ec2ClientFactory creates the EC2 client of the target account and region
+type ec2ClientFactory func(sess *session.Session, config *aws.Config) ec2API
+
+func newEC2Source() (DiscoverySource, error) {
+	s, err := newEC2SourceWithClients(func(sess *session.Session, config *aws.Config) ec2API {
+		return ec2.New(sess, config)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// newEC2SourceWithClients sets up the source describing instances with clients
+// of the factory, e.g. fakes returning canned reservations
+func newEC2SourceWithClients(newClient ec2ClientFactory) (*ec2Source, error) {
+	s := &ec2Source{newClient: newClient}
+
+	if value := getEnv("TAG_FILTERS", ""); value != "" {
+		if err := json.Unmarshal([]byte(value), &s.tagFilters); err != nil {
+			return nil, errors.Wrap(err, "Can't parse TAG_FILTERS")
+		}
+	}
+
+	for _, f := range [][2]string{
+		{"vpc-id", "VPC_IDS"},
+		{"subnet-id", "SUBNET_IDS"},
+		{"instance.group-id", "SECURITY_GROUP_IDS"},
+	} {
+		if ids := splitList(getEnv(f[1], "")); len(ids) > 0 {
+			s.filters = append(s.filters, &ec2.Filter{Name: aws.String(f[0]), Values: aws.StringSlice(ids)})
+		}
+	}
+
+	if value := getEnv("EC2_FILTERS", ""); value != "" {
+		filters := []*ec2.Filter{}
+		if err := json.Unmarshal([]byte(value), &filters); err != nil {
+			return nil, errors.Wrap(err, "Can't parse EC2_FILTERS")
+		}
+		for _, f := range filters {
+			if aws.StringValue(f.Name) == "" || len(f.Values) == 0 {
+				return nil, errors.Errorf("Invalid filter in EC2_FILTERS: every filter should have name and values")
+			}
+		}
+		s.filters = append(s.filters, filters...)
+	}
+
+	s.asgNames = splitList(getEnv("ASG_NAMES", ""))
+	s.ecsClusters = splitList(getEnv("ECS_CLUSTERS", ""))
+	s.eksClusters = splitList(getEnv("EKS_CLUSTERS", ""))
+
+	mode, err := getAddressMode()
+	if err != nil {
+		return nil, err
+	}
+	s.addressMode = mode
+
+	s.states = splitList(getEnv("INSTANCE_STATES", defaultInstanceStates))
+	for _, state := range s.states {
+		if !validInstanceState(state) {
+			return nil, errors.Errorf("Unknown state in INSTANCE_STATES: '%s' (available: %s)", state, strings.Join(instanceStates, ", "))
+		}
+	}
+
+	s.concurrency, _ = strconv.Atoi(getEnv("ACCOUNT_CONCURRENCY", defaultAccountConcurrency))
+	if s.concurrency <= 0 {
+		s.concurrency = 1
+	}
+
+	sess := awsSession()
+
+	// ASSUME_ROLES win over roles of the organization
+	roles, err := getOrganizationRoles(sess)
+	if err != nil {
+		return nil, err
+	}
+	assumeRoles, err := getAssumeRoles()
+	if err != nil {
+		return nil, err
+	}
+	for account, role := range assumeRoles {
+		roles[account] = role
+	}
+
+	for _, region := range getRegions(aws.StringValue(sess.Config.Region)) {
+		s.targets = append(s.targets, s.newTarget(sess, "", region, aws.NewConfig().WithRegion(region)))
+
+		for account, role := range roles {
+			config := aws.NewConfig().WithRegion(region).WithCredentials(stscreds.NewCredentials(sess, role))
+			s.targets = append(s.targets, s.newTarget(sess, account, region, config))
+		}
+	}
+
+	return s, nil
+}
+
+func (s *ec2Source) newTarget(sess *session.Session, account, region string, config *aws.Config) ec2Target {
+	t := ec2Target{account: account, region: region, svc: s.newClient(sess, config)}
+	if len(s.asgNames) > 0 || len(s.eksClusters) > 0 {
+		t.asg = autoscaling.New(sess, config)
+	}
+	if len(s.ecsClusters) > 0 {
+		t.ecs = ecs.New(sess, config)
+	}
+	if len(s.eksClusters) > 0 {
+		t.eks = eks.New(sess, config)
+	}
+
+	return t
+}
+
+// getAssumeRoles returns roles listed in ASSUME_ROLES by their account ids
+func getAssumeRoles() (map[string]string, error) {
+	roles := map[string]string{}
+	for _, role := range strings.Split(getEnv("ASSUME_ROLES", ""), ",") {
+		if role = strings.TrimSpace(role); role == "" {
+			continue
+		}
+
+		parsed, err := arn.Parse(role)
+		if err != nil || parsed.AccountID == "" {
+			return nil, errors.Errorf("Invalid role ARN in ASSUME_ROLES: '%s'", role)
+		}
+		roles[parsed.AccountID] = role
+	}
+
+	return roles, nil
+}
+
+// name identifies the target in logs and errors
+func (t ec2Target) name() string {
+	if t.account == "" {
+		return t.region
+	}
+
+	return t.account + "/" + t.region
+}
+
+// instanceStates are all EC2 instance states
+var instanceStates = []string{
+	ec2.InstanceStateNamePending,
+	ec2.InstanceStateNameRunning,
+	ec2.InstanceStateNameShuttingDown,
+	ec2.InstanceStateNameTerminated,
+	ec2.InstanceStateNameStopping,
+	ec2.InstanceStateNameStopped,
+}
+
+func validInstanceState(state string) bool {
+	for _, name := range instanceStates {
+		if state == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lifecycleOnDemand is the lifecycle of instances without InstanceLifecycle
+const lifecycleOnDemand = "on-demand"
+
+// instanceLifecycles are all EC2 instance lifecycles
+var instanceLifecycles = []string{
+	ec2.InstanceLifecycleTypeSpot,
+	ec2.InstanceLifecycleTypeScheduled,
+	lifecycleOnDemand,
+}
+
+func validLifecycle(lifecycle string) bool {
+	for _, name := range instanceLifecycles {
+		if lifecycle == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getRegions returns regions listed in REGIONS or the session region
+// when the list is empty
+func getRegions(sessionRegion string) []string {
+	if regions := splitList(getEnv("REGIONS", "")); len(regions) > 0 {
+		return regions
+	}
+
+	return []string{sessionRegion}
+}
+
+func (s *ec2Source) input() *ec2.DescribeInstancesInput {
+	params := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice(s.states),
+			},
+		},
+	}
+
+	params.Filters = append(params.Filters, s.filters...)
+
+	for key, value := range s.tagFilters {
+		params.Filters = append(params.Filters, &ec2.Filter{
+			Name:   aws.String("tag:" + key),
+			Values: []*string{aws.String(value)},
+		})
+	}
+
+	return params
+}
+
+func (s *ec2Source) Discover() ([]*InstanceInfo, error) {
+	instancesInfo, err := s.describe(s.input())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("AWS: found %v instance(s) in %s state...", len(instancesInfo), strings.Join(s.states, ", "))
+
+	return instancesInfo, nil
+}
+
+// Lookup describes given instances only. Filter is used instead of InstanceIds
+// parameter, since the latter fails in regions not having the instance.
+func (s *ec2Source) Lookup(ids []string) ([]*InstanceInfo, error) {
+	params := s.input()
+	params.Filters = append(params.Filters, &ec2.Filter{
+		Name:   aws.String("instance-id"),
+		Values: aws.StringSlice(ids),
+	})
+
+	return s.describe(params)
+}
+
+// States describes instances in their accounts and regions regardless of the state.
+// Instances missing in the response are not known to EC2 anymore.
+func (s *ec2Source) States(instances []*InstanceInfo) (map[string]string, error) {
+	states := map[string]string{}
+
+	for _, target := range s.targets {
+		ids := []string{}
+		for _, inst := range instances {
+			if inst.region == target.region && (inst.account == target.account || target.account == "" && !s.assumed(inst.account)) {
+				ids = append(ids, inst.id)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		for _, id := range ids {
+			states[id] = "terminated"
+		}
+
+		params := &ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice(ids)}},
+		}
+		err := target.svc.DescribeInstancesPages(params, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			eachInstance(page.Reservations, func(reservation *ec2.Reservation, instance *ec2.Instance) {
+				states[aws.StringValue(instance.InstanceId)] = instanceState(instance)
+			})
+
+			return true
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't fetch ec2 instances state in %s", target.name())
+		}
+	}
+
+	return states, nil
+}
+
+// assumed tells if the account is accessed with one of ASSUME_ROLES
+func (s *ec2Source) assumed(account string) bool {
+	for _, target := range s.targets {
+		if target.account != "" && target.account == account {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describe queries targets concurrently. Failures of assumed accounts are
+// reported in accountErrors, failures of the own account fail the discovery.
+func (s *ec2Source) describe(params *ec2.DescribeInstancesInput) ([]*InstanceInfo, error) {
+	found := make([][]*InstanceInfo, len(s.targets))
+	errs := make([]error, len(s.targets))
+
+	limiter := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+	for i := range s.targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+
+			found[i], errs[i] = s.describeTarget(s.targets[i], params)
+		}(i)
+	}
+	wg.Wait()
+
+	s.accountErrors = nil
+	instancesInfo := []*InstanceInfo{}
+	for i, target := range s.targets {
+		if errs[i] == nil {
+			instancesInfo = append(instancesInfo, found[i]...)
+			continue
+		}
+
+		if target.account == "" {
+			return nil, errs[i]
+		}
+
+		log.Println(errs[i])
+		s.accountErrors = append(s.accountErrors, AccountError{Account: target.account, Region: target.region, Error: errs[i].Error()})
+	}
+
+	return instancesInfo, nil
+}
+
+// AccountErrors reports assumed accounts which failed to be described
+func (s *ec2Source) AccountErrors() []AccountError {
+	return s.accountErrors
+}
+
+func (s *ec2Source) describeTarget(target ec2Target, params *ec2.DescribeInstancesInput) ([]*InstanceInfo, error) {
+	instancesInfo := []*InstanceInfo{}
+
+	inputs, err := s.targetInputs(target, params)
+	if err != nil {
+		return instancesInfo, err
+	}
+
+	for _, input := range inputs {
+		err = target.svc.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			eachInstance(page.Reservations, func(reservation *ec2.Reservation, instance *ec2.Instance) {
+				if isExcluded(instance) {
+					log.Printf("AWS: %s is excluded with %s tag", aws.StringValue(instance.InstanceId), excludeTag)
+					return
+				}
+
+				iInfo := newEC2InstanceInfo(instance, s.addressMode)
+				iInfo.account = aws.StringValue(reservation.OwnerId)
+				iInfo.region = target.region
+				instancesInfo = append(instancesInfo, iInfo)
+			})
+
+			return true
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't fetch ec2 instances list in %s", target.name())
+		}
+	}
+
+	return instancesInfo, nil
+}
+
+// Fingerprint hashes ids, states, addresses and tags of instances fetched with a single
+// DescribeInstances call per region or batch of group members, fleets not fitting into
+// one page are never fingerprinted
+func (s *ec2Source) Fingerprint() (string, error) {
+	maxResults, _ := strconv.ParseInt(getEnv("INVENTORY_CHECK_MAX_RESULTS", defaultInventoryCheckMaxResults), 10, 64)
+
+	lines := []string{}
+	for _, target := range s.targets {
+		inputs, err := s.targetInputs(target, s.input())
+		if err != nil {
+			return "", err
+		}
+
+		for _, params := range inputs {
+			params.MaxResults = aws.Int64(maxResults)
+
+			out, err := target.svc.DescribeInstances(params)
+			if err != nil {
+				return "", errors.Wrapf(err, "Can't fetch ec2 instances list in %s", target.name())
+			}
+
+			if aws.StringValue(out.NextToken) != "" {
+				return "", nil
+			}
+
+			eachInstance(out.Reservations, func(reservation *ec2.Reservation, instance *ec2.Instance) {
+				fields := []string{
+					target.name(),
+					aws.StringValue(instance.InstanceId),
+					instanceState(instance),
+					aws.StringValue(instance.PrivateIpAddress),
+					aws.StringValue(instance.PublicIpAddress),
+				}
+
+				tags := []string{}
+				for _, tag := range instance.Tags {
+					tags = append(tags, aws.StringValue(tag.Key)+"="+aws.StringValue(tag.Value))
+				}
+				sort.Strings(tags)
+
+				lines = append(lines, strings.Join(append(fields, tags...), " "))
+			})
+		}
+	}
+	sort.Strings(lines)
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(lines, "\n")))), nil
+}
+
+// newEC2InstanceInfo converts the instance, IPv6 addresses of network interfaces
+// are private ones as they aren't NATed
+func newEC2InstanceInfo(instance *ec2.Instance, addressMode string) *InstanceInfo {
+	iInfo := &InstanceInfo{
+		id:          aws.StringValue(instance.InstanceId),
+		description: instance,
+		tags:        map[string]string{},
+	}
+
+	for _, tag := range instance.Tags {
+		if tag != nil {
+			iInfo.tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	iInfo.name = iInfo.tags["Name"]
+
+	private := []string{aws.StringValue(instance.PrivateIpAddress)}
+	for _, ni := range instance.NetworkInterfaces {
+		if ni == nil {
+			continue
+		}
+		for _, addr := range ni.Ipv6Addresses {
+			if addr != nil {
+				private = append(private, aws.StringValue(addr.Ipv6Address))
+			}
+		}
+	}
+	iInfo.addrs = instanceAddrs(private, []string{aws.StringValue(instance.PublicIpAddress)}, addressMode)
+
+	return iInfo
+}
+
+// eachInstance calls fn for every instance of the reservations, nil entries are skipped
+func eachInstance(reservations []*ec2.Reservation, fn func(reservation *ec2.Reservation, instance *ec2.Instance)) {
+	for _, reservation := range reservations {
+		if reservation == nil {
+			continue
+		}
+		for _, instance := range reservation.Instances {
+			if instance != nil {
+				fn(reservation, instance)
+			}
+		}
+	}
+}
+
+// instanceState returns the state name, empty if it's missing
+func instanceState(instance *ec2.Instance) string {
+	if instance.State == nil {
+		return ""
+	}
+
+	return aws.StringValue(instance.State.Name)
+}
+
+// isExcluded tells if the instance opted out from discovery with the exclude tag
+func isExcluded(instance *ec2.Instance) bool {
+	for _, tag := range instance.Tags {
+		if tag != nil && aws.StringValue(tag.Key) == excludeTag {
+			return strings.EqualFold(strings.TrimSpace(aws.StringValue(tag.Value)), "true")
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fakeEC2 returns canned pages of reservations
+type fakeEC2 struct {
+	pages  [][]*ec2.Reservation
+	inputs []*ec2.DescribeInstancesInput
+}
+
+func (f *fakeEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	f.inputs = append(f.inputs, input)
+	out := &ec2.DescribeInstancesOutput{}
+	if len(f.pages) > 0 {
+		out.Reservations = f.pages[0]
+	}
+	if len(f.pages) > 1 {
+		out.NextToken = aws.String("next")
+	}
+
+	return out, nil
+}
+
+func (f *fakeEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	f.inputs = append(f.inputs, input)
+	for i, page := range f.pages {
+		if !fn(&ec2.DescribeInstancesOutput{Reservations: page}, i == len(f.pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeEC2) DescribeVpcs(*ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	return &ec2.DescribeVpcsOutput{}, nil
+}
+
+// ec2Instance is what a test expects of a discovered instance
+type ec2Instance struct {
+	name    string
+	account string
+	addrs   []string
+	tags    map[string]string
+}
+
+func TestEC2Discover(t *testing.T) {
+	tag := func(key, value string) *ec2.Tag { return &ec2.Tag{Key: aws.String(key), Value: aws.String(value)} }
+
+	tests := []struct {
+		name  string
+		pages [][]*ec2.Reservation
+		want  map[string]ec2Instance
+	}{
+		{
+			name: "reservations of all pages",
+			pages: [][]*ec2.Reservation{
+				{{OwnerId: aws.String("111111111111"), Instances: []*ec2.Instance{
+					{InstanceId: aws.String("i-1"), PrivateIpAddress: aws.String("10.0.0.1"), Tags: []*ec2.Tag{tag("Name", "web-1")}},
+				}}},
+				{{OwnerId: aws.String("222222222222"), Instances: []*ec2.Instance{
+					{InstanceId: aws.String("i-2"), PrivateIpAddress: aws.String("10.0.0.2"), PublicIpAddress: aws.String("3.3.3.3")},
+				}}},
+			},
+			want: map[string]ec2Instance{
+				"i-1": {name: "web-1", account: "111111111111", addrs: []string{"10.0.0.1"}, tags: map[string]string{"Name": "web-1"}},
+				"i-2": {account: "222222222222", addrs: []string{"10.0.0.2", "3.3.3.3"}, tags: map[string]string{}},
+			},
+		},
+		{
+			name: "excluded instances are skipped",
+			pages: [][]*ec2.Reservation{{{Instances: []*ec2.Instance{
+				{InstanceId: aws.String("i-1"), Tags: []*ec2.Tag{tag(excludeTag, " True ")}},
+				{InstanceId: aws.String("i-2"), Tags: []*ec2.Tag{tag(excludeTag, "false")}},
+			}}}},
+			want: map[string]ec2Instance{
+				"i-2": {addrs: []string{}, tags: map[string]string{excludeTag: "false"}},
+			},
+		},
+		{
+			name: "ipv6 addresses of all interfaces",
+			pages: [][]*ec2.Reservation{{{Instances: []*ec2.Instance{{
+				InstanceId:       aws.String("i-1"),
+				PrivateIpAddress: aws.String("10.0.0.1"),
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+					{Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::1")}}},
+					{Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::2")}}},
+				},
+			}}}}},
+			want: map[string]ec2Instance{
+				"i-1": {addrs: []string{"10.0.0.1", "2001:db8::1", "2001:db8::2"}, tags: map[string]string{}},
+			},
+		},
+		{
+			name: "nil entries are skipped",
+			pages: [][]*ec2.Reservation{{nil, {Instances: []*ec2.Instance{nil, {
+				InstanceId: aws.String("i-1"),
+				Tags:       []*ec2.Tag{nil, {Key: aws.String("Name")}},
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+					nil,
+					{Ipv6Addresses: []*ec2.InstanceIpv6Address{nil}},
+				},
+			}}}}},
+			want: map[string]ec2Instance{
+				"i-1": {addrs: []string{}, tags: map[string]string{"Name": ""}},
+			},
+		},
+	}
+
+	os.Setenv("REGIONS", "us-east-1")
+	defer os.Unsetenv("REGIONS")
+
+	for _, tt := range tests {
+		fake := &fakeEC2{pages: tt.pages}
+		s, err := newEC2SourceWithClients(func(*session.Session, *aws.Config) ec2API { return fake })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		instances, err := s.Discover()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		got := map[string]ec2Instance{}
+		for _, inst := range instances {
+			if inst.region != "us-east-1" {
+				t.Errorf("%s: %s region is %q", tt.name, inst.id, inst.region)
+			}
+			got[inst.id] = ec2Instance{name: inst.name, account: inst.account, addrs: inst.addrs, tags: inst.tags}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEC2InstanceStates(t *testing.T) {
+	tests := []struct {
+		states string
+		want   []string
+	}{
+		{"", []string{"running", "pending"}},
+		{"stopped", []string{"stopped"}},
+		{"running,stopped", []string{"running", "stopped"}},
+	}
+
+	os.Setenv("REGIONS", "us-east-1")
+	defer os.Unsetenv("REGIONS")
+	defer os.Unsetenv("INSTANCE_STATES")
+
+	for _, tt := range tests {
+		os.Unsetenv("INSTANCE_STATES")
+		if tt.states != "" {
+			os.Setenv("INSTANCE_STATES", tt.states)
+		}
+
+		fake := &fakeEC2{}
+		s, err := newEC2SourceWithClients(func(*session.Session, *aws.Config) ec2API { return fake })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Discover(); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, f := range fake.inputs[0].Filters {
+			if aws.StringValue(f.Name) == "instance-state-name" {
+				got = aws.StringValueSlice(f.Values)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("INSTANCE_STATES=%q: got state filter %v, want %v", tt.states, got, tt.want)
+		}
+	}
+}
+
+func TestEC2AddressModes(t *testing.T) {
+	instance := &ec2.Instance{
+		InstanceId:       aws.String("i-1"),
+		PrivateIpAddress: aws.String("10.0.0.1"),
+		PublicIpAddress:  aws.String("3.3.3.3"),
+	}
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{"private-first", []string{"10.0.0.1", "3.3.3.3"}},
+		{"public-first", []string{"3.3.3.3", "10.0.0.1"}},
+		{"private-only", []string{"10.0.0.1"}},
+		{"public-only", []string{"3.3.3.3"}},
+	}
+
+	for _, tt := range tests {
+		if got := newEC2InstanceInfo(instance, tt.mode).addrs; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestEC2Fingerprint(t *testing.T) {
+	instance := &ec2.Instance{InstanceId: aws.String("i-1")}
+
+	tests := []struct {
+		name  string
+		pages [][]*ec2.Reservation
+		empty bool
+	}{
+		{"single page", [][]*ec2.Reservation{{{Instances: []*ec2.Instance{instance}}}}, false},
+		{"paginated fleet", [][]*ec2.Reservation{{{Instances: []*ec2.Instance{instance}}}, {}}, true},
+	}
+
+	os.Setenv("REGIONS", "us-east-1")
+	defer os.Unsetenv("REGIONS")
+
+	for _, tt := range tests {
+		fake := &fakeEC2{pages: tt.pages}
+		s, err := newEC2SourceWithClients(func(*session.Session, *aws.Config) ec2API { return fake })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fingerprint, err := s.Fingerprint()
+		if err != nil || (fingerprint == "") != tt.empty {
+			t.Errorf("%s: got fingerprint %q, %v", tt.name, fingerprint, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/pkg/errors"
+)
+
+// ecsDescribeLimit is the max number of container instances described at once
+const ecsDescribeLimit = 100
+
+// ecsMembers resolves EC2 instance ids of container instances of ECS clusters in the target
+func (t ec2Target) ecsMembers(clusters []string) ([]string, error) {
+	ids := []string{}
+
+	for _, cluster := range clusters {
+		arns := []*string{}
+		params := &ecs.ListContainerInstancesInput{Cluster: aws.String(cluster)}
+		err := t.ecs.ListContainerInstancesPages(params, func(page *ecs.ListContainerInstancesOutput, lastPage bool) bool {
+			arns = append(arns, page.ContainerInstanceArns...)
+			return true
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecs.ErrCodeClusterNotFoundException {
+			log.Printf("AWS: ECS cluster %s is not found in %s", cluster, t.name())
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't list container instances of %s in %s", cluster, t.name())
+		}
+
+		for len(arns) > 0 {
+			n := len(arns)
+			if n > ecsDescribeLimit {
+				n = ecsDescribeLimit
+			}
+
+			out, err := t.ecs.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
+				Cluster:            aws.String(cluster),
+				ContainerInstances: arns[:n],
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "Can't describe container instances of %s in %s", cluster, t.name())
+			}
+
+			for _, instance := range out.ContainerInstances {
+				if id := aws.StringValue(instance.Ec2InstanceId); id != "" {
+					ids = append(ids, id)
+				}
+			}
+			arns = arns[n:]
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/pkg/errors"
+)
+
+// eksClusterTagPrefix is the tag key prefix of worker nodes, e.g. kubernetes.io/cluster/prod=owned
+const eksClusterTagPrefix = "kubernetes.io/cluster/"
+
+// eksMembers resolves ids of worker nodes of EKS clusters in the target: members of
+// managed node group ASGs and instances tagged with kubernetes.io/cluster/<name>,
+// so self-managed nodes are found too
+func (t ec2Target) eksMembers(clusters []string) ([]string, error) {
+	asgNames := []string{}
+	tagKeys := []string{}
+
+	for _, cluster := range clusters {
+		tagKeys = append(tagKeys, eksClusterTagPrefix+cluster)
+
+		nodegroups := []*string{}
+		err := t.eks.ListNodegroupsPages(&eks.ListNodegroupsInput{ClusterName: aws.String(cluster)}, func(page *eks.ListNodegroupsOutput, lastPage bool) bool {
+			nodegroups = append(nodegroups, page.Nodegroups...)
+			return true
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == eks.ErrCodeResourceNotFoundException {
+			log.Printf("AWS: EKS cluster %s is not found in %s", cluster, t.name())
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't list node groups of %s in %s", cluster, t.name())
+		}
+
+		for _, nodegroup := range nodegroups {
+			out, err := t.eks.DescribeNodegroup(&eks.DescribeNodegroupInput{ClusterName: aws.String(cluster), NodegroupName: nodegroup})
+			if err != nil {
+				return nil, errors.Wrapf(err, "Can't describe node group %s of %s in %s", aws.StringValue(nodegroup), cluster, t.name())
+			}
+			if out.Nodegroup.Resources == nil {
+				continue
+			}
+
+			for _, group := range out.Nodegroup.Resources.AutoScalingGroups {
+				asgNames = append(asgNames, aws.StringValue(group.Name))
+			}
+		}
+	}
+
+	ids := []string{}
+	if len(asgNames) > 0 {
+		asgIDs, err := t.asgMembers(asgNames)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, asgIDs...)
+	}
+
+	params := &ec2.DescribeInstancesInput{Filters: []*ec2.Filter{{Name: aws.String("tag-key"), Values: aws.StringSlice(tagKeys)}}}
+	err := t.svc.DescribeInstancesPages(params, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		eachInstance(page.Reservations, func(reservation *ec2.Reservation, instance *ec2.Instance) {
+			ids = append(ids, aws.StringValue(instance.InstanceId))
+		})
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't find nodes of EKS clusters in %s", t.name())
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// hostsSource is the name of static hosts source
+const hostsSource = "hosts"
+
+// staticHosts contains hosts listed in HOSTS or given by the run,
+// they bypass discovery entirely
+type staticHosts struct {
+	hosts []string
+}
+
+func newHostsSource() (DiscoverySource, error) {
+	return &staticHosts{hosts: splitList(getEnv("HOSTS", ""))}, nil
+}
+
+// getHosts returns hosts of the run or HOSTS, empty list means discovery is used
+func getHosts(opts RunOptions) []string {
+	if len(opts.Hosts) > 0 {
+		return opts.Hosts
+	}
+
+	return splitList(getEnv("HOSTS", ""))
+}
+
+// Discover parses hosts in [user@]host[:port] format, the host is its own id
+func (s *staticHosts) Discover() ([]*InstanceInfo, error) {
+	instances := []*InstanceInfo{}
+
+	for _, entry := range s.hosts {
+		inst, err := parseHost(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
+
+func parseHost(entry string) (*InstanceInfo, error) {
+	inst := &InstanceInfo{id: entry, tags: map[string]string{}}
+
+	address := entry
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		inst.sshUser, address = address[:i], address[i+1:]
+	}
+
+	// bare IPv6 addresses have several colons and no port
+	host := address
+	if strings.HasPrefix(address, "[") || strings.Count(address, ":") == 1 {
+		var port string
+		var err error
+		if host, port, err = net.SplitHostPort(address); err != nil {
+			return nil, errors.Wrapf(err, "Invalid host: '%s'", entry)
+		}
+
+		if !validPort(port) {
+			return nil, errors.Errorf("Invalid port of host: '%s'", entry)
+		}
+		inst.sshPort = port
+	}
+
+	if host == "" {
+		return nil, errors.Errorf("Invalid host: '%s' (should be [user@]host[:port])", entry)
+	}
+
+	inst.name = host
+	inst.addrs = []string{host}
+
+	return inst, nil
+}
+
+func validPort(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p <= 65535
+}
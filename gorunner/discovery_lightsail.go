@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lightsail"
+	"github.com/pkg/errors"
+)
+
+// lightsailSource lists running Lightsail instances, which are invisible
+// to DescribeInstances, in every region listed in REGIONS
+type lightsailSource struct {
+	regions     map[string]*lightsail.Lightsail
+	addressMode string
+}
+
+func newLightsailSource() (DiscoverySource, error) {
+	mode, err := getAddressMode()
+	if err != nil {
+		return nil, err
+	}
+	s := &lightsailSource{regions: map[string]*lightsail.Lightsail{}, addressMode: mode}
+
+	sess := awsSession()
+	for _, region := range getRegions(aws.StringValue(sess.Config.Region)) {
+		s.regions[region] = lightsail.New(sess, aws.NewConfig().WithRegion(region))
+	}
+
+	return s, nil
+}
+
+// Discover returns running instances, the instance name is its id
+func (s *lightsailSource) Discover() ([]*InstanceInfo, error) {
+	instances := []*InstanceInfo{}
+
+	for region, svc := range s.regions {
+		params := &lightsail.GetInstancesInput{}
+		for {
+			page, err := svc.GetInstances(params)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Can't fetch lightsail instances in %s", region)
+			}
+
+			for _, instance := range page.Instances {
+				if instance.State == nil || aws.StringValue(instance.State.Name) != "running" {
+					continue
+				}
+
+				inst := &InstanceInfo{
+					id:     aws.StringValue(instance.Name),
+					name:   aws.StringValue(instance.Name),
+					region: region,
+					tags:   map[string]string{},
+				}
+				for _, tag := range instance.Tags {
+					inst.tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
+				inst.addrs = instanceAddrs(
+					[]string{aws.StringValue(instance.PrivateIpAddress), aws.StringValue(instance.Ipv6Address)},
+					[]string{aws.StringValue(instance.PublicIpAddress)},
+					s.addressMode,
+				)
+
+				instances = append(instances, inst)
+			}
+
+			if aws.StringValue(page.NextPageToken) == "" {
+				break
+			}
+			params.PageToken = page.NextPageToken
+		}
+	}
+
+	log.Printf("Lightsail: found %v running instance(s)...", len(instances))
+
+	return instances, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+)
+
+const defaultAccountConcurrency = "5"
+
+// getOrganizationRoles returns roles named ORGANIZATION_ROLE_NAME in all active accounts
+// of the organization except the own one, nothing is returned if it's not set
+func getOrganizationRoles(sess *session.Session) (map[string]string, error) {
+	roles := map[string]string{}
+
+	roleName := getEnv("ORGANIZATION_ROLE_NAME", "")
+	if roleName == "" {
+		return roles, nil
+	}
+
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't get caller identity")
+	}
+	caller, err := arn.Parse(aws.StringValue(identity.Arn))
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't parse caller identity")
+	}
+
+	err = organizations.New(sess).ListAccountsPages(&organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+		for _, account := range page.Accounts {
+			id := aws.StringValue(account.Id)
+			if id == caller.AccountID || aws.StringValue(account.Status) != organizations.AccountStatusActive {
+				continue
+			}
+
+			roles[id] = fmt.Sprintf("arn:%s:iam::%s:role/%s", caller.Partition, id, roleName)
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't list organization accounts")
+	}
+
+	log.Printf("AWS: found %v active account(s) in the organization", len(roles))
+
+	return roles, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// relaySource is the name of the source of hosts reached through the relay
+const relaySource = "relay"
+
+// relayItem is a reverse tunnel registered in RELAY_TABLE by the on-prem host,
+// e.g. with ssh -R 20022:localhost:22 relay. RelayPort of the relay forwards to sshd
+// of the host, expired registrations are skipped.
+type relayItem struct {
+	HostId    string
+	RelayPort string
+	User      string
+	Tags      map[string]string
+	// ExpiresAt is unix time of the registration expiry, 0 means it doesn't expire
+	ExpiresAt int64
+}
+
+// relayHosts lists hosts registered in RELAY_TABLE
+type relayHosts struct {
+	table string
+	svc   *dynamodb.DynamoDB
+}
+
+func newRelaySource() (DiscoverySource, error) {
+	table := getEnv("RELAY_TABLE", "")
+	if table == "" || getEnv("RELAY_HOST", "") == "" {
+		return nil, errors.Errorf("You should provide RELAY_TABLE and RELAY_HOST")
+	}
+
+	return &relayHosts{table: table, svc: dynamodb.New(awsSession())}, nil
+}
+
+// Discover scans the table, hosts are dialed at their ports on the relay
+func (s *relayHosts) Discover() ([]*InstanceInfo, error) {
+	instances := []*InstanceInfo{}
+	now := time.Now()
+
+	err := s.svc.ScanPages(&dynamodb.ScanInput{TableName: aws.String(s.table)}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, attrs := range page.Items {
+			item := relayItem{}
+			if err := dynamodbattribute.UnmarshalMap(attrs, &item); err != nil {
+				log.Println(errors.Wrapf(err, "Skipping item of %s", s.table))
+				continue
+			}
+			if item.HostId == "" || !validPort(item.RelayPort) {
+				log.Printf("Skipping item of %s: HostId or valid RelayPort is missing", s.table)
+				continue
+			}
+			if item.ExpiresAt > 0 && now.Unix() > item.ExpiresAt {
+				log.Printf("Relay: registration of %s is expired", item.HostId)
+				continue
+			}
+
+			inst := &InstanceInfo{
+				id:      item.HostId,
+				name:    item.Tags["Name"],
+				tags:    item.Tags,
+				addrs:   []string{"localhost"},
+				sshUser: item.User,
+				sshPort: item.RelayPort,
+			}
+			if inst.tags == nil {
+				inst.tags = map[string]string{}
+			}
+			if inst.name == "" {
+				inst.name = item.HostId
+			}
+
+			instances = append(instances, inst)
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't scan %s", s.table)
+	}
+
+	log.Printf("Relay: found %v host(s) in %s", len(instances), s.table)
+
+	return instances, nil
+}
+
+// relayDialer opens connections to relay hosts through a single ssh
+// connection to RELAY_HOST shared by all hosts of the run
+type relayDialer struct {
+	sync.Mutex
+	address string
+	configs []*ssh.ClientConfig
+	client  *ssh.Client
+}
+
+// newRelayDialer returns the dialer of RELAY_HOST ([user@]host[:port]), the first
+// of USERS is the relay user by default. Nil is returned when it's not set.
+func newRelayDialer(auths []*ssh.ClientConfig) (*relayDialer, error) {
+	value := getEnv("RELAY_HOST", "")
+	if value == "" {
+		return nil, nil
+	}
+	if len(auths) == 0 {
+		return nil, errors.Errorf("No ssh users to connect to the relay")
+	}
+
+	relay, err := parseHost(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't parse RELAY_HOST")
+	}
+
+	// every key of the first user is tried
+	configs := []*ssh.ClientConfig{}
+	for _, auth := range auths {
+		if auth.User != auths[0].User {
+			break
+		}
+		config := *auth
+		if relay.sshUser != "" {
+			config.User = relay.sshUser
+		}
+		configs = append(configs, &config)
+	}
+
+	return &relayDialer{
+		address: net.JoinHostPort(relay.addrs[0], relay.port()),
+		configs: configs,
+	}, nil
+}
+
+// dial connects to the address as seen from the relay, the relay
+// is connected again if its connection is broken
+func (d *relayDialer) dial(address string) (net.Conn, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	for i := 0; i < 2; i++ {
+		if d.client == nil {
+			if err := d.connect(); err != nil {
+				return nil, err
+			}
+		}
+
+		conn, err := d.client.Dial("tcp", address)
+		if err == nil {
+			return conn, nil
+		}
+		if _, ok := err.(*ssh.OpenChannelError); ok {
+			return nil, errors.Wrapf(err, "Tunnel %s is not open on relay", address)
+		}
+
+		d.client.Close()
+		d.client = nil
+	}
+
+	return nil, errors.Errorf("Can't reach %s through relay %s", address, d.address)
+}
+
+// connect tries every key till the relay accepts one
+func (d *relayDialer) connect() error {
+	var err error
+	for _, config := range d.configs {
+		var client *ssh.Client
+		if client, err = ssh.Dial("tcp", d.address, config); err == nil {
+			d.client = client
+			return nil
+		}
+		if !isAuthError(err) {
+			break
+		}
+	}
+
+	return errors.Wrapf(err, "Can't connect to relay %s", d.address)
+}
+
+func (d *relayDialer) close() {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.client != nil {
+		d.client.Close()
+		d.client = nil
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/pkg/errors"
+)
+
+// route53Source treats names of A and AAAA records of ROUTE53_ZONES as hosts
+type route53Source struct {
+	zones []string
+	svc   *route53.Route53
+}
+
+func newRoute53Source() (DiscoverySource, error) {
+	zones := splitList(getEnv("ROUTE53_ZONES", ""))
+	if len(zones) == 0 {
+		return nil, errors.Errorf("You should provide ROUTE53_ZONES")
+	}
+
+	return &route53Source{zones: zones, svc: route53.New(awsSession())}, nil
+}
+
+// Discover returns a host per record name, the name is the id of the host.
+// Hosts are contacted at their addresses, alias records are contacted by name.
+// Wildcard records are skipped.
+func (s *route53Source) Discover() ([]*InstanceInfo, error) {
+	instances := []*InstanceInfo{}
+	byName := map[string]*InstanceInfo{}
+
+	for _, zone := range s.zones {
+		params := &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(zone)}
+		err := s.svc.ListResourceRecordSetsPages(params, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+			for _, set := range page.ResourceRecordSets {
+				kind := aws.StringValue(set.Type)
+				if kind != route53.RRTypeA && kind != route53.RRTypeAaaa {
+					continue
+				}
+
+				// wildcards are escaped as \052
+				name := strings.TrimSuffix(aws.StringValue(set.Name), ".")
+				if strings.HasPrefix(name, `\052`) {
+					continue
+				}
+
+				inst, ok := byName[name]
+				if !ok {
+					inst = &InstanceInfo{id: name, name: name, tags: map[string]string{"HostedZoneId": zone}}
+					byName[name] = inst
+					instances = append(instances, inst)
+				}
+
+				if set.AliasTarget != nil {
+					inst.addrs = appendUnique(inst.addrs, name)
+					continue
+				}
+				for _, record := range set.ResourceRecords {
+					inst.addrs = appendUnique(inst.addrs, aws.StringValue(record.Value))
+				}
+			}
+
+			return true
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't list records of hosted zone %s", zone)
+		}
+	}
+
+	log.Printf("Route53: found %v host(s) in %v hosted zone(s)", len(instances), len(s.zones))
+
+	return instances, nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+
+	return append(list, value)
+}
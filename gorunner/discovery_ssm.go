@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/pkg/errors"
+)
+
+// ssmSource lists nodes registered in Systems Manager, including hybrid
+// and on-premises managed instances, in every region listed in REGIONS
+type ssmSource struct {
+	regions map[string]*ssm.SSM
+}
+
+func newSSMSource() (DiscoverySource, error) {
+	s := &ssmSource{regions: map[string]*ssm.SSM{}}
+
+	sess := awsSession()
+	for _, region := range getRegions(aws.StringValue(sess.Config.Region)) {
+		s.regions[region] = ssm.New(sess, aws.NewConfig().WithRegion(region))
+	}
+
+	return s, nil
+}
+
+// Discover returns nodes reporting to SSM, nodes which lost connection are skipped
+func (s *ssmSource) Discover() ([]*InstanceInfo, error) {
+	instances, err := s.describe(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("SSM: found %v online managed instance(s)...", len(instances))
+
+	return instances, nil
+}
+
+// Lookup describes given managed instances only
+func (s *ssmSource) Lookup(ids []string) ([]*InstanceInfo, error) {
+	return s.describe([]*ssm.InstanceInformationStringFilter{
+		{Key: aws.String("InstanceIds"), Values: aws.StringSlice(ids)},
+	})
+}
+
+func (s *ssmSource) describe(filters []*ssm.InstanceInformationStringFilter) ([]*InstanceInfo, error) {
+	instances := []*InstanceInfo{}
+
+	params := &ssm.DescribeInstanceInformationInput{
+		Filters: append([]*ssm.InstanceInformationStringFilter{
+			{Key: aws.String("PingStatus"), Values: aws.StringSlice([]string{ssm.PingStatusOnline})},
+		}, filters...),
+	}
+
+	for region, svc := range s.regions {
+		err := svc.DescribeInstanceInformationPages(params, func(page *ssm.DescribeInstanceInformationOutput, lastPage bool) bool {
+			for _, info := range page.InstanceInformationList {
+				inst := &InstanceInfo{
+					id:     aws.StringValue(info.InstanceId),
+					name:   aws.StringValue(info.ComputerName),
+					region: region,
+					tags:   map[string]string{},
+					addrs:  []string{},
+				}
+				if addr := aws.StringValue(info.IPAddress); addr != "" {
+					inst.addrs = append(inst.addrs, addr)
+				}
+
+				instances = append(instances, inst)
+			}
+
+			return true
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't fetch ssm managed instances in %s", region)
+		}
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/pkg/errors"
+)
+
+// endpointResolver overrides endpoints of services listed in API_ENDPOINTS JSON
+// ({<service>: <url>}, {region} is replaced with the region of the client),
+// endpoints of other services are resolved for the partition of the region
+func endpointResolver(value string) (endpoints.Resolver, error) {
+	overrides := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		return nil, errors.Wrap(err, "Can't parse API_ENDPOINTS")
+	}
+
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		url, ok := overrides[service]
+		if !ok {
+			return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+		}
+
+		return endpoints.ResolvedEndpoint{
+			URL:           strings.Replace(url, "{region}", region, -1),
+			SigningRegion: region,
+		}, nil
+	}), nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// launchTemplateIDTag and launchTemplateVersionTag are set by EC2 on instances
+	// launched from templates
+	launchTemplateIDTag      = "aws:ec2launchtemplate:id"
+	launchTemplateVersionTag = "aws:ec2launchtemplate:version"
+)
+
+// factCache keeps values of IMMUTABLE_FACTS by AMI and launch template
+// in the execution environment between warm invocations
+var factCache struct {
+	sync.Mutex
+	seeded bool
+	values map[string]map[string]cachedFact
+}
+
+// cachedFact is the value along with the hash of the command which produced
+// it, values of changed commands are never reused
+type cachedFact struct {
+	command string
+	value   string
+}
+
+// factCommandHash identifies the fact command in the cache and stored rows
+func factCommandHash(cmd string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(cmd)))[:16]
+}
+
+// getImmutableFacts returns labels of IMMUTABLE_FACTS
+func getImmutableFacts() []string {
+	return splitList(getEnv("IMMUTABLE_FACTS", ""))
+}
+
+// imageID returns the AMI of EC2 instance, empty for other sources
+func (inst *InstanceInfo) imageID() string {
+	if inst.description == nil {
+		return ""
+	}
+
+	return aws.StringValue(inst.description.ImageId)
+}
+
+// launchTemplate returns id:version of the template the instance was launched from
+func (inst *InstanceInfo) launchTemplate() string {
+	id := inst.tags[launchTemplateIDTag]
+	if id == "" {
+		return ""
+	}
+
+	return id + ":" + inst.tags[launchTemplateVersionTag]
+}
+
+// factCacheKey identifies instances sharing immutable facts, empty
+// for instances without AMI
+func factCacheKey(imageID, launchTemplate string) string {
+	if imageID == "" {
+		return ""
+	}
+
+	return imageID + "/" + launchTemplate
+}
+
+// applyFactCache sets cached values of immutable facts of every instance,
+// these facts are not collected from the instance again. Values are reused
+// only if they were collected with the same command the instance would run.
+// The cache is seeded from the latest stored run on the first use in the
+// execution environment.
+func applyFactCache(instances []*InstanceInfo, labels []string, commands map[string]string) {
+	factCache.Lock()
+	defer factCache.Unlock()
+
+	if !factCache.seeded {
+		factCache.seeded = true
+		seedFactCache(labels)
+	}
+
+	hits := 0
+	for _, inst := range instances {
+		inst.cachedFacts = nil
+		inst.factHashes = nil
+
+		facts := inst.withTagFacts(commands)
+		cached := factCache.values[factCacheKey(inst.imageID(), inst.launchTemplate())]
+		for _, label := range labels {
+			cmd, requested := facts[label]
+			if !requested {
+				continue
+			}
+			if inst.factHashes == nil {
+				inst.factHashes = map[string]string{}
+			}
+			inst.factHashes[label] = factCommandHash(cmd)
+
+			fact, ok := cached[label]
+			if !ok || fact.command != inst.factHashes[label] {
+				continue
+			}
+			if inst.cachedFacts == nil {
+				inst.cachedFacts = map[string]string{}
+			}
+			inst.cachedFacts[label] = fact.value
+		}
+		if inst.cachedFacts != nil {
+			hits++
+		}
+	}
+
+	log.Printf("Fact cache: immutable facts of %v instance(s) are reused", hits)
+}
+
+// seedFactCache fills the cache with facts of the latest stored run,
+// rows without hashes of fact commands are skipped
+func seedFactCache(labels []string) {
+	if !historyEnabled() {
+		return
+	}
+
+	run, err := latestRun()
+	if err == errRunNotFound {
+		return
+	}
+	if err != nil {
+		log.Printf("Fact cache: can't load the latest run: %v", err)
+		return
+	}
+
+	for _, row := range run.Rows {
+		storeFacts(factCacheKey(row.ImageId, row.LaunchTemplate), labels, row.Facts, row.FactHashes, row.Error == "")
+	}
+}
+
+// updateFactCache stores immutable facts collected by the run
+func updateFactCache(instances []*InstanceInfo, labels []string) {
+	factCache.Lock()
+	defer factCache.Unlock()
+
+	for _, inst := range instances {
+		storeFacts(factCacheKey(inst.imageID(), inst.launchTemplate()), labels, inst.facts, inst.factHashes, inst.err == nil)
+	}
+}
+
+// storeFacts keeps values of the labels collected without errors along with
+// hashes of their commands, the caller holds the cache lock
+func storeFacts(key string, labels []string, facts, hashes map[string]string, ok bool) {
+	if key == "" || !ok || facts == nil {
+		return
+	}
+
+	for _, label := range labels {
+		value, collected := facts[label]
+		hash, known := hashes[label]
+		if !collected || !known {
+			continue
+		}
+		if factCache.values == nil {
+			factCache.values = map[string]map[string]cachedFact{}
+		}
+		if factCache.values[key] == nil {
+			factCache.values[key] = map[string]cachedFact{}
+		}
+		factCache.values[key][label] = cachedFact{command: hash, value: value}
+	}
+}
+
+// cachedLabels returns sorted labels of facts reused from the cache
+func (inst *InstanceInfo) cachedLabels() []string {
+	if len(inst.cachedFacts) == 0 {
+		return nil
+	}
+
+	labels := []string{}
+	for label := range inst.cachedFacts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	return labels
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestFactCacheCommandChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		ami     string
+		command string
+		reused  bool
+	}{
+		{"unchanged command", "ami-1", "cat /etc/os-release", true},
+		{"changed command", "ami-1", "cat /etc/system-release", false},
+		{"other AMI", "ami-2", "cat /etc/os-release", false},
+	}
+
+	defer func() { factCache.values, factCache.seeded = nil, false }()
+
+	labels := []string{"release"}
+	for _, tt := range tests {
+		factCache.values, factCache.seeded = nil, true
+
+		first := &InstanceInfo{description: &ec2.Instance{ImageId: aws.String("ami-1")}}
+		commands := map[string]string{"release": "cat /etc/os-release", "uptime": "uptime"}
+		applyFactCache([]*InstanceInfo{first}, labels, commands)
+		first.facts = map[string]string{"release": "Amazon Linux 2", "uptime": "up"}
+		updateFactCache([]*InstanceInfo{first}, labels)
+
+		next := &InstanceInfo{description: &ec2.Instance{ImageId: aws.String(tt.ami)}}
+		commands["release"] = tt.command
+		applyFactCache([]*InstanceInfo{next}, labels, commands)
+
+		_, cached := next.cachedFacts["release"]
+		_, run := next.commands(commands)["release"]
+		if cached != tt.reused || run == tt.reused {
+			t.Errorf("%s: got cached %v, run %v, want reused %v", tt.name, cached, run, tt.reused)
+		}
+		if _, run := next.commands(commands)["uptime"]; !run {
+			t.Errorf("%s: mutable fact isn't run", tt.name)
+		}
+	}
+}
+
+func TestFactCacheSeedSkipsRowsWithoutHashes(t *testing.T) {
+	factCache.values = nil
+	defer func() { factCache.values = nil }()
+
+	hash := factCommandHash("cat /etc/os-release")
+	storeFacts(factCacheKey("ami-1", ""), []string{"release"}, map[string]string{"release": "old"}, nil, true)
+	storeFacts(factCacheKey("ami-2", ""), []string{"release"}, map[string]string{"release": "new"}, map[string]string{"release": hash}, true)
+
+	if _, ok := factCache.values[factCacheKey("ami-1", "")]; ok {
+		t.Error("row without command hashes is cached")
+	}
+	if fact := factCache.values[factCacheKey("ami-2", "")]["release"]; fact.command != hash || fact.value != "new" {
+		t.Errorf("unexpected cached fact: %+v", fact)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pkg/errors"
+)
+
+// FactIndex maps values of the fact to instances reporting them
+type FactIndex struct {
+	RunID  string
+	Fact   string
+	Values []FactValueInstances
+}
+
+// FactValueInstances lists instances reporting the value
+type FactValueInstances struct {
+	Value     string
+	Instances []string
+}
+
+// indexFact groups instances of the run by values of the fact,
+// the most frequent values go first
+func indexFact(run *RunResult, fact string) *FactIndex {
+	byValue := map[string][]string{}
+	for _, row := range run.Rows {
+		if value, ok := row.Facts[fact]; ok {
+			byValue[value] = append(byValue[value], row.InstanceId)
+		}
+	}
+
+	index := &FactIndex{RunID: run.RunID, Fact: fact, Values: []FactValueInstances{}}
+	for value, instances := range byValue {
+		sort.Strings(instances)
+		index.Values = append(index.Values, FactValueInstances{Value: value, Instances: instances})
+	}
+
+	sort.Slice(index.Values, func(i, j int) bool {
+		a, b := index.Values[i], index.Values[j]
+		if len(a.Instances) != len(b.Instances) {
+			return len(a.Instances) > len(b.Instances)
+		}
+		return a.Value < b.Value
+	})
+
+	return index
+}
+
+// handleFactValues serves GET /facts/{name}/values for the latest stored run,
+// value query string parameter keeps the single value only
+func handleFactValues(request events.APIGatewayProxyRequest) (Response, error) {
+	run, err := latestRun()
+	if err == errHistoryDisabled {
+		return errorResponse(404, err)
+	}
+	if err == errRunNotFound {
+		return errorResponse(404, errors.Errorf("No runs stored yet"))
+	}
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	index := indexFact(run, request.PathParameters["name"])
+
+	if value, ok := request.QueryStringParameters["value"]; ok {
+		filtered := []FactValueInstances{}
+		for _, v := range index.Values {
+			if v.Value == value {
+				filtered = append(filtered, v)
+			}
+		}
+		index.Values = filtered
+	}
+
+	return jsonResponse(200, index)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// factOptionsDefault key applies options to all facts without their own ones
+const factOptionsDefault = "*"
+
+var (
+	umaskPattern = regexp.MustCompile(`^[0-7]{3,4}$`)
+	userPattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*\$?$`)
+)
+
+// FactOptions set the environment the fact command is run in
+type FactOptions struct {
+	Cwd   string `json:"cwd"`
+	Umask string `json:"umask"`
+
+	// User runs the command in the context of another user with RunAs method: sudo (default) or su
+	User  string `json:"user"`
+	RunAs string `json:"run_as"`
+}
+
+// getFactOptions parses FACT_OPTIONS JSON: {<label>: {"cwd": <dir>, "umask": <mask>, "user": <user>, "run_as": <method>}}
+func getFactOptions() (map[string]FactOptions, error) {
+	options := map[string]FactOptions{}
+	if err := json.Unmarshal([]byte(getEnv("FACT_OPTIONS", "{}")), &options); err != nil {
+		return nil, errors.Wrap(err, "Can't parse FACT_OPTIONS")
+	}
+
+	for label, opts := range options {
+		if opts.Umask != "" && !umaskPattern.MatchString(opts.Umask) {
+			return nil, errors.Errorf("Invalid umask of '%s' fact: '%s'", label, opts.Umask)
+		}
+		if opts.User != "" && !userPattern.MatchString(opts.User) {
+			return nil, errors.Errorf("Invalid user of '%s' fact: '%s'", label, opts.User)
+		}
+		switch opts.RunAs {
+		case "", "sudo", "su":
+		default:
+			return nil, errors.Errorf("Unknown run_as of '%s' fact: '%s' (available: sudo, su)", label, opts.RunAs)
+		}
+	}
+
+	return options, nil
+}
+
+// applyFactOptions prefixes commands with cd and umask. The command is run
+// in a group, so it's never executed if the directory doesn't exist.
+// Commands of another user are passed to the shell started with sudo or su.
+func applyFactOptions(commands map[string]string, options map[string]FactOptions) map[string]string {
+	if len(options) == 0 {
+		return commands
+	}
+
+	applied := map[string]string{}
+	for label, cmd := range commands {
+		opts, ok := options[label]
+		if !ok {
+			opts = options[factOptionsDefault]
+		}
+
+		prefix := ""
+		if opts.Cwd != "" {
+			prefix += fmt.Sprintf("cd %s && ", shellQuote(opts.Cwd))
+		}
+		if opts.Umask != "" {
+			prefix += fmt.Sprintf("umask %s && ", opts.Umask)
+		}
+
+		if prefix != "" {
+			cmd = prefix + "{\n" + cmd + "\n}"
+		}
+
+		switch {
+		case opts.User == "":
+		case opts.RunAs == "su":
+			cmd = fmt.Sprintf("su -s /bin/sh %s -c %s", opts.User, shellQuote(cmd))
+		default:
+			// non-interactive: fail instead of waiting for password
+			cmd = fmt.Sprintf("sudo -n -u %s /bin/sh -c %s", opts.User, shellQuote(cmd))
+		}
+
+		applied[label] = cmd
+	}
+
+	return applied
+}
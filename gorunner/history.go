@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const defaultHistoryPrefix = "runs/"
+
+var (
+	// errRunNotFound is returned when the run isn't stored in history
+	errRunNotFound = errors.New("Run not found")
+	// errHistoryDisabled is returned when HISTORY_BUCKET isn't set
+	errHistoryDisabled = errors.New("Run history is disabled, set HISTORY_BUCKET to enable it")
+)
+
+// newRunID returns an id which sorts in the order runs were started
+func newRunID(startTime time.Time, requestID string) string {
+	id := startTime.UTC().Format("20060102T150405Z")
+	if len(requestID) > 8 {
+		requestID = requestID[:8]
+	}
+	if requestID != "" {
+		id += "-" + requestID
+	}
+
+	return id
+}
+
+// historyEnabled tells if runs should be stored in HISTORY_BUCKET
+func historyEnabled() bool {
+	return getEnv("HISTORY_BUCKET", "") != ""
+}
+
+func historyKey(runID string) string {
+	return getEnv("HISTORY_PREFIX", defaultHistoryPrefix) + runID + ".json"
+}
+
+// historyPartitionKey uses Hive-style partitions, so stored runs could be queried with Athena
+func historyPartitionKey(runID, account, region string) string {
+	if account == "" {
+		account = "unknown"
+	}
+	if region == "" {
+		region = "unknown"
+	}
+
+	return fmt.Sprintf("%spartitions/account=%s/region=%s/%s.json", getEnv("HISTORY_PREFIX", defaultHistoryPrefix), account, region, runID)
+}
+
+// loadRun reads the run result from the history bucket
+func loadRun(runID string) (*RunResult, error) {
+	if !historyEnabled() {
+		return nil, errHistoryDisabled
+	}
+
+	// run ids are used as object keys
+	if runID == "" || strings.ContainsAny(runID, "/.") {
+		return nil, errRunNotFound
+	}
+
+	return loadRunObject(s3.New(awsSession()), runID, historyKey(runID))
+}
+
+func loadRunObject(svc *s3.S3, runID, key string) (*RunResult, error) {
+	bucket := getEnv("HISTORY_BUCKET", "")
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, errRunNotFound
+		}
+		return nil, errors.Wrapf(err, "Can't read run %s from s3://%s/%s", runID, bucket, key)
+	}
+	defer obj.Body.Close()
+
+	body, err := ioutil.ReadAll(obj.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't read run %s", runID)
+	}
+
+	result := &RunResult{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, errors.Wrapf(err, "Can't decode run %s", runID)
+	}
+
+	return result, nil
+}
+
+// MergedRun is the run consolidated from all its stored parts
+type MergedRun struct {
+	RunResult
+	// Parts lists object keys the run was merged from
+	Parts []string
+	// Missing lists parts which were expected but not found
+	Missing []string
+	// Duplicates counts rows of the same instance found in several parts
+	Duplicates int
+}
+
+// mergeRun consolidates the whole run object with its account/region partitions.
+// Rows are deduplicated by instance id preferring successful ones, so the run
+// is complete as long as any part of every instance is stored.
+func mergeRun(runID string) (*MergedRun, error) {
+	if !historyEnabled() {
+		return nil, errHistoryDisabled
+	}
+	if runID == "" || strings.ContainsAny(runID, "/.") {
+		return nil, errRunNotFound
+	}
+
+	svc := s3.New(awsSession())
+	bucket := getEnv("HISTORY_BUCKET", "")
+	prefix := getEnv("HISTORY_PREFIX", defaultHistoryPrefix) + "partitions/"
+
+	keys := []string{historyKey(runID)}
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if strings.HasSuffix(aws.StringValue(obj.Key), "/"+runID+".json") {
+				keys = append(keys, aws.StringValue(obj.Key))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't list partitions of run %s", runID)
+	}
+
+	merged := &MergedRun{Parts: []string{}, Missing: []string{}}
+	rows := map[string]ResRow{}
+	order := []string{}
+	expected := map[string]bool{}
+
+	for _, key := range keys {
+		part, err := loadRunObject(svc, runID, key)
+		if err == errRunNotFound {
+			merged.Missing = append(merged.Missing, key)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		merged.Parts = append(merged.Parts, key)
+		if merged.RunID == "" || key == historyKey(runID) {
+			merged.RunMeta = part.RunMeta
+		}
+
+		for _, row := range part.Rows {
+			if key == historyKey(runID) {
+				expected[historyPartitionKey(runID, row.Account, row.Region)] = true
+			}
+
+			prev, ok := rows[row.InstanceId]
+			if !ok {
+				order = append(order, row.InstanceId)
+				rows[row.InstanceId] = row
+				continue
+			}
+
+			merged.Duplicates++
+			if prev.Error != "" && row.Error == "" {
+				rows[row.InstanceId] = row
+			}
+		}
+	}
+
+	if len(merged.Parts) == 0 {
+		return nil, errRunNotFound
+	}
+
+	// partitions are stored only for runs spanning several accounts or regions
+	if len(expected) > 1 {
+		found := map[string]bool{}
+		for _, key := range merged.Parts {
+			found[key] = true
+		}
+		for key := range expected {
+			if !found[key] {
+				merged.Missing = append(merged.Missing, key)
+			}
+		}
+		sort.Strings(merged.Missing)
+	}
+
+	merged.Rows = []ResRow{}
+	for _, id := range order {
+		merged.Rows = append(merged.Rows, rows[id])
+	}
+
+	return merged, nil
+}
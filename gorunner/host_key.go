@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultHostKeyTimeout = "2"
+
+// hostKeyRequest is sent to the verifier listening on HOST_KEY_SOCKET
+type hostKeyRequest struct {
+	Hostname    string `json:"hostname"`
+	Remote      string `json:"remote"`
+	KeyType     string `json:"key_type"`
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// hostKeyResponse is the verdict of the verifier
+type hostKeyResponse struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// getHostKeyCallback returns the callback delegating host key verification to
+// the verifier listening on HOST_KEY_SOCKET, e.g. Lambda extension of the CA
+// service. Host keys are not checked when it's not set.
+func getHostKeyCallback() (ssh.HostKeyCallback, error) {
+	socket := getEnv("HOST_KEY_SOCKET", "")
+	if socket == "" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	seconds, err := strconv.Atoi(getEnv("HOST_KEY_TIMEOUT", defaultHostKeyTimeout))
+	if err != nil || seconds <= 0 {
+		return nil, errors.Errorf("Invalid HOST_KEY_TIMEOUT: '%s'", getEnv("HOST_KEY_TIMEOUT", ""))
+	}
+	timeout := time.Duration(seconds) * time.Second
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return verifyHostKey(socket, timeout, hostname, remote, key)
+	}, nil
+}
+
+// verifyHostKey asks the verifier about the key over a new connection, the
+// request and the response are single JSON documents. Any failure to get
+// the verdict rejects the key.
+func verifyHostKey(socket string, timeout time.Duration, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return errors.Wrap(err, "Can't connect to host key verifier")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	request := hostKeyRequest{
+		Hostname:    hostname,
+		KeyType:     key.Type(),
+		Key:         base64.StdEncoding.EncodeToString(key.Marshal()),
+		Fingerprint: ssh.FingerprintSHA256(key),
+	}
+	if remote != nil {
+		request.Remote = remote.String()
+	}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return errors.Wrap(err, "Can't send host key to verifier")
+	}
+
+	response := hostKeyResponse{}
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return errors.Wrap(err, "Can't read host key verdict")
+	}
+	if !response.Allow {
+		return errors.Errorf("Host key %s of %s is rejected: %s", request.Fingerprint, hostname, response.Reason)
+	}
+
+	return nil
+}
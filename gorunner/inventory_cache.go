@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultInventoryCacheSeconds = "0"
+	defaultStaleInventorySeconds = "3600"
+)
+
+// StaleDiscovery is reported when discovery failed and the run used cached instances
+type StaleDiscovery struct {
+	FetchedAt time.Time
+	Error     string
+}
+
+// Fingerprinter is implemented by discovery sources supporting a cheap
+// change check: equal fingerprints mean the cached instances are still valid
+type Fingerprinter interface {
+	Fingerprint() (string, error)
+}
+
+// cachedInstance is a serializable copy of discovered instance
+type cachedInstance struct {
+	ID          string
+	Name        string
+	Source      string
+	Account     string
+	Region      string
+	Tags        map[string]string
+	Addrs       []string
+	Description *ec2.Instance `json:",omitempty"`
+	SSHUser     string        `json:",omitempty"`
+	SSHPort     string        `json:",omitempty"`
+}
+
+type inventorySnapshot struct {
+	Key         string
+	FetchedAt   time.Time
+	Fingerprint string
+	Instances   []cachedInstance
+}
+
+// inventoryCache keeps discovered instances in the execution environment between warm invocations
+var inventoryCache struct {
+	sync.Mutex
+	snapshot *inventorySnapshot
+}
+
+// getInstances returns cached instances while INVENTORY_CACHE_SECONDS isn't expired or
+// discovery sources report no changes, otherwise instances are discovered again.
+// Refresh clears the cached instances before discovery, so the run never falls
+// back to them, the cache is updated with discovered ones.
+// Inventories missing some accounts are never cached.
+func getInstances(refresh bool) ([]*InstanceInfo, []AccountError, *StaleDiscovery, error) {
+	ttl, _ := strconv.Atoi(getEnv("INVENTORY_CACHE_SECONDS", defaultInventoryCacheSeconds))
+	staleTTL, _ := strconv.Atoi(getEnv("STALE_INVENTORY_SECONDS", defaultStaleInventorySeconds))
+	if ttl <= 0 && staleTTL <= 0 {
+		instances, accountErrors, err := discoverInstances()
+		return instances, accountErrors, nil, err
+	}
+
+	key := inventoryCacheKey()
+	if refresh {
+		log.Printf("Refreshing cached inventory")
+		clearInventorySnapshot()
+
+		fingerprint := ""
+		if ttl > 0 {
+			var err error
+			if fingerprint, err = fingerprintSources(); err != nil {
+				log.Println(errors.Wrap(err, "Can't check inventory changes"))
+			}
+		}
+
+		return discoverAndCache(key, fingerprint, 0)
+	}
+
+	if ttl <= 0 {
+		return discoverAndCache(key, "", staleTTL)
+	}
+
+	snapshot := loadInventorySnapshot(key)
+
+	if snapshot != nil {
+		age := time.Since(snapshot.FetchedAt)
+		if age < time.Duration(ttl)*time.Second {
+			log.Printf("Using cached inventory (%v old)", age.Round(time.Second))
+			return snapshot.restore(), nil, nil, nil
+		}
+	}
+
+	fingerprint, err := fingerprintSources()
+	if err != nil {
+		log.Println(errors.Wrap(err, "Can't check inventory changes"))
+	}
+
+	if snapshot != nil && fingerprint != "" && fingerprint == snapshot.Fingerprint {
+		log.Printf("Inventory is not changed, extending cache")
+		snapshot.FetchedAt = time.Now()
+		saveInventorySnapshot(snapshot)
+		return snapshot.restore(), nil, nil, nil
+	}
+
+	return discoverAndCache(key, fingerprint, staleTTL)
+}
+
+// discoverAndCache falls back to the cached instances fetched less than
+// STALE_INVENTORY_SECONDS ago when discovery fails, e.g. EC2 API is throttled
+func discoverAndCache(key, fingerprint string, staleTTL int) ([]*InstanceInfo, []AccountError, *StaleDiscovery, error) {
+	instances, accountErrors, err := discoverInstances()
+	if err != nil {
+		snapshot := loadInventorySnapshot(key)
+		if snapshot == nil || staleTTL <= 0 || time.Since(snapshot.FetchedAt) >= time.Duration(staleTTL)*time.Second {
+			return nil, nil, nil, err
+		}
+
+		log.Printf("Discovery failed, using stale inventory fetched at %s: %v", snapshot.FetchedAt.UTC().Format(time.RFC3339), err)
+		return snapshot.restore(), nil, &StaleDiscovery{FetchedAt: snapshot.FetchedAt.UTC(), Error: err.Error()}, nil
+	}
+
+	if len(accountErrors) == 0 {
+		saveInventorySnapshot(newInventorySnapshot(key, fingerprint, instances))
+	}
+
+	return instances, accountErrors, nil, nil
+}
+
+// inventorySettings change the discovered inventory, so they are part of the cache key
+var inventorySettings = []string{"REGIONS", "TAG_FILTERS", "ASSUME_ROLES", "VPC_IDS", "SUBNET_IDS", "SECURITY_GROUP_IDS", "ASG_NAMES", "EC2_FILTERS", "INSTANCE_STATES", "ORGANIZATION_ROLE_NAME",
+	"ECS_CLUSTERS", "EKS_CLUSTERS", "INVENTORY_S3_URI", "INVENTORY_TABLE", "ROUTE53_ZONES", "CONFIG_AGGREGATOR", "ADDRESS_MODE", "RELAY_TABLE"}
+
+// inventoryCacheKey changes with discovery settings
+func inventoryCacheKey() string {
+	parts := []string{getEnv("DISCOVERY", defaultDiscovery)}
+	for _, name := range inventorySettings {
+		parts = append(parts, getEnv(name, ""))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// fingerprintSources combines fingerprints of all discovery sources,
+// empty result means changes can't be checked
+func fingerprintSources() (string, error) {
+	parts := []string{}
+
+	for _, name := range discoveryNames() {
+		source, err := newDiscoverySource(name)
+		if err != nil {
+			return "", err
+		}
+
+		f, ok := source.(Fingerprinter)
+		if !ok {
+			return "", nil
+		}
+
+		fingerprint, err := f.Fingerprint()
+		if err != nil || fingerprint == "" {
+			return "", err
+		}
+
+		parts = append(parts, name+"="+fingerprint)
+	}
+
+	return strings.Join(parts, ","), nil
+}
+
+func newInventorySnapshot(key, fingerprint string, instances []*InstanceInfo) *inventorySnapshot {
+	snapshot := &inventorySnapshot{
+		Key:         key,
+		FetchedAt:   time.Now(),
+		Fingerprint: fingerprint,
+	}
+
+	for _, inst := range instances {
+		snapshot.Instances = append(snapshot.Instances, cachedInstance{
+			ID:          inst.id,
+			Name:        inst.name,
+			Source:      inst.source,
+			Account:     inst.account,
+			Region:      inst.region,
+			Tags:        inst.tags,
+			Addrs:       inst.addrs,
+			Description: inst.description,
+			SSHUser:     inst.sshUser,
+			SSHPort:     inst.sshPort,
+		})
+	}
+
+	return snapshot
+}
+
+// restore returns fresh instances, so results of previous runs don't leak
+func (s *inventorySnapshot) restore() []*InstanceInfo {
+	instances := []*InstanceInfo{}
+	for _, c := range s.Instances {
+		instances = append(instances, &InstanceInfo{
+			id:          c.ID,
+			name:        c.Name,
+			source:      c.Source,
+			account:     c.Account,
+			region:      c.Region,
+			tags:        c.Tags,
+			addrs:       append([]string{}, c.Addrs...),
+			description: c.Description,
+			sshUser:     c.SSHUser,
+			sshPort:     c.SSHPort,
+		})
+	}
+
+	return instances
+}
+
+func loadInventorySnapshot(key string) *inventorySnapshot {
+	inventoryCache.Lock()
+	snapshot := inventoryCache.snapshot
+	inventoryCache.Unlock()
+
+	if snapshot != nil && snapshot.Key == key {
+		return snapshot
+	}
+
+	if table := getEnv("INVENTORY_CACHE_TABLE", ""); table != "" {
+		snapshot, err := loadInventoryItem(table, key)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+
+		if snapshot != nil {
+			inventoryCache.Lock()
+			inventoryCache.snapshot = snapshot
+			inventoryCache.Unlock()
+		}
+
+		return snapshot
+	}
+
+	return nil
+}
+
+// clearInventorySnapshot drops the cached instances of the execution environment
+func clearInventorySnapshot() {
+	inventoryCache.Lock()
+	inventoryCache.snapshot = nil
+	inventoryCache.Unlock()
+}
+
+func saveInventorySnapshot(snapshot *inventorySnapshot) {
+	inventoryCache.Lock()
+	inventoryCache.snapshot = snapshot
+	inventoryCache.Unlock()
+
+	if table := getEnv("INVENTORY_CACHE_TABLE", ""); table != "" {
+		if err := saveInventoryItem(table, snapshot); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// saveInventoryItem shares the snapshot between execution environments.
+// Instances are gzipped to stay below DynamoDB item size limit.
+func saveInventoryItem(table string, snapshot *inventorySnapshot) error {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	if err := json.NewEncoder(zw).Encode(snapshot); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	_, err := dynamodb.New(awsSession()).PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Key":      {S: aws.String(snapshot.Key)},
+			"Snapshot": {B: buf.Bytes()},
+		},
+	})
+
+	return errors.Wrapf(err, "Can't save inventory cache to %s table", table)
+}
+
+func loadInventoryItem(table, key string) (*inventorySnapshot, error) {
+	out, err := dynamodb.New(awsSession()).GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Key": {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't load inventory cache from %s table", table)
+	}
+
+	attr, ok := out.Item["Snapshot"]
+	if !ok {
+		return nil, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(attr.B))
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't decode inventory cache")
+	}
+
+	body, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't decode inventory cache")
+	}
+
+	snapshot := &inventorySnapshot{}
+	if err := json.Unmarshal(body, snapshot); err != nil {
+		return nil, errors.Wrap(err, "Can't decode inventory cache")
+	}
+
+	return snapshot, nil
+}
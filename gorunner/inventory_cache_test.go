@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// fakeSource discovers the given instances or fails
+type fakeSource struct {
+	ids  []string
+	fail bool
+}
+
+func (f *fakeSource) Discover() ([]*InstanceInfo, error) {
+	if f.fail {
+		return nil, errors.New("Throttling")
+	}
+
+	instances := []*InstanceInfo{}
+	for _, id := range f.ids {
+		instances = append(instances, &InstanceInfo{id: id})
+	}
+
+	return instances, nil
+}
+
+func TestGetInstances(t *testing.T) {
+	type call struct {
+		ids     []string
+		fail    bool
+		refresh bool
+		want    []string
+		stale   bool
+	}
+
+	tests := []struct {
+		name  string
+		ttl   string
+		calls []call
+	}{
+		{"cached inventory is used", "3600", []call{
+			{ids: []string{"i-1"}, want: []string{"i-1"}},
+			{ids: []string{"i-1", "i-2"}, want: []string{"i-1"}},
+		}},
+		{"refresh updates the cache", "3600", []call{
+			{ids: []string{"i-1"}, want: []string{"i-1"}},
+			{ids: []string{"i-1", "i-2"}, refresh: true, want: []string{"i-1", "i-2"}},
+			{ids: []string{"i-1"}, want: []string{"i-1", "i-2"}},
+		}},
+		{"failed refresh clears the cache", "3600", []call{
+			{ids: []string{"i-1"}, want: []string{"i-1"}},
+			{fail: true, refresh: true},
+			{ids: []string{"i-1", "i-2"}, want: []string{"i-1", "i-2"}},
+		}},
+		{"failed discovery falls back to stale inventory", "0", []call{
+			{ids: []string{"i-1"}, refresh: true, want: []string{"i-1"}},
+			{fail: true, want: []string{"i-1"}, stale: true},
+		}},
+		{"failed refresh doesn't fall back to stale inventory", "0", []call{
+			{ids: []string{"i-1"}, want: []string{"i-1"}},
+			{fail: true, refresh: true},
+		}},
+	}
+
+	source := &fakeSource{}
+	discoverySources["fake"] = func() (DiscoverySource, error) { return source, nil }
+	os.Setenv("DISCOVERY", "fake")
+	os.Setenv("STALE_INVENTORY_SECONDS", "3600")
+	defer func() {
+		delete(discoverySources, "fake")
+		os.Unsetenv("DISCOVERY")
+		os.Unsetenv("INVENTORY_CACHE_SECONDS")
+		os.Unsetenv("STALE_INVENTORY_SECONDS")
+		clearInventorySnapshot()
+	}()
+
+	for _, tt := range tests {
+		os.Setenv("INVENTORY_CACHE_SECONDS", tt.ttl)
+		clearInventorySnapshot()
+
+		for i, c := range tt.calls {
+			source.ids, source.fail = c.ids, c.fail
+
+			instances, _, stale, err := getInstances(c.refresh)
+			if (err != nil) != (c.fail && !c.stale) || (stale != nil) != c.stale {
+				t.Errorf("%s: call #%d: unexpected error: %v", tt.name, i+1, err)
+				continue
+			}
+
+			ids := []string{}
+			for _, inst := range instances {
+				ids = append(ids, inst.id)
+			}
+			if c.want == nil {
+				c.want = []string{}
+			}
+			if !reflect.DeepEqual(ids, c.want) {
+				t.Errorf("%s: call #%d: got %v, want %v", tt.name, i+1, ids, c.want)
+			}
+		}
+	}
+}
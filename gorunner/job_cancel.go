@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultJobPollSeconds = "5"
+
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobCancelled = "cancelled"
+)
+
+// errJobFinished is returned when the job can't be cancelled anymore
+var errJobFinished = errors.New("Job is already finished")
+
+// JobStatus is the state of the SNS job stored in JOBS_TABLE
+type JobStatus struct {
+	JobID  string
+	Status string
+}
+
+// jobTable returns JOBS_TABLE, jobs can't be cancelled if it's not set
+func jobTable() string {
+	return getEnv("JOBS_TABLE", "")
+}
+
+// startJob marks the job running, false is returned when it was cancelled before the start
+func startJob(id string) (bool, error) {
+	_, err := dynamodb.New(awsSession()).UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(jobTable()),
+		Key:                       map[string]*dynamodb.AttributeValue{"JobId": {S: aws.String(id)}},
+		UpdateExpression:          aws.String("SET #status = :status, StartedAt = :now"),
+		ConditionExpression:       aws.String("attribute_not_exists(Cancelled)"),
+		ExpressionAttributeNames:  map[string]*string{"#status": aws.String("Status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":status": {S: aws.String(jobRunning)}, ":now": timeAttr(time.Now())},
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "Can't start job %s", id)
+	}
+
+	return true, nil
+}
+
+// finishJob stores the status and the run of the finished job
+func finishJob(id string, meta *RunMeta) error {
+	status := jobDone
+	if meta.Cancelled {
+		status = jobCancelled
+	}
+
+	_, err := dynamodb.New(awsSession()).UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(jobTable()),
+		Key:                       map[string]*dynamodb.AttributeValue{"JobId": {S: aws.String(id)}},
+		UpdateExpression:          aws.String("SET #status = :status, RunID = :run, FinishedAt = :now"),
+		ExpressionAttributeNames:  map[string]*string{"#status": aws.String("Status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":status": {S: aws.String(status)}, ":run": {S: aws.String(meta.RunID)}, ":now": timeAttr(time.Now())},
+	})
+
+	return errors.Wrapf(err, "Can't finish job %s", id)
+}
+
+// cancelJob sets the cancellation flag of the job which is not finished yet,
+// jobs which haven't started yet are skipped when they arrive
+func cancelJob(id string) (*JobStatus, error) {
+	out, err := dynamodb.New(awsSession()).UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(jobTable()),
+		Key:                       map[string]*dynamodb.AttributeValue{"JobId": {S: aws.String(id)}},
+		UpdateExpression:          aws.String("SET Cancelled = :true, CancelledAt = :now"),
+		ConditionExpression:       aws.String("attribute_not_exists(#status) OR #status = :running"),
+		ExpressionAttributeNames:  map[string]*string{"#status": aws.String("Status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":true": {BOOL: aws.Bool(true)}, ":running": {S: aws.String(jobRunning)}, ":now": timeAttr(time.Now())},
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if isConditionFailed(err) {
+		return nil, errJobFinished
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't cancel job %s", id)
+	}
+
+	status := &JobStatus{JobID: id, Status: jobCancelled}
+	if attr, ok := out.Attributes["Status"]; ok && aws.StringValue(attr.S) == jobRunning {
+		status.Status = "cancelling"
+	}
+
+	return status, nil
+}
+
+// isJobCancelled tells if the cancellation flag of the job is set
+func isJobCancelled(id string) (bool, error) {
+	out, err := dynamodb.New(awsSession()).GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(jobTable()),
+		Key:            map[string]*dynamodb.AttributeValue{"JobId": {S: aws.String(id)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "Can't check cancellation of job %s", id)
+	}
+
+	attr, ok := out.Item["Cancelled"]
+	return ok && aws.BoolValue(attr.BOOL), nil
+}
+
+// watchJob returns the context which is cancelled once the cancellation flag
+// of the job is set, the flag is polled every JOB_POLL_SECONDS
+func watchJob(ctx context.Context, id string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	seconds, _ := strconv.Atoi(getEnv("JOB_POLL_SECONDS", defaultJobPollSeconds))
+	if seconds <= 0 {
+		seconds, _ = strconv.Atoi(defaultJobPollSeconds)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(seconds) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cancelled, err := isJobCancelled(id)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				if cancelled {
+					log.Printf("Job %s is cancelled, no new instances are contacted", id)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// handleCancelJob serves DELETE /jobs/{id}
+func handleCancelJob(request events.APIGatewayProxyRequest) (Response, error) {
+	if jobTable() == "" {
+		return errorResponse(400, errors.Errorf("Jobs can't be cancelled, JOBS_TABLE is not set"))
+	}
+
+	id := request.PathParameters["id"]
+	status, err := cancelJob(id)
+	if err == errJobFinished {
+		return errorResponse(409, errors.Errorf("Job is already finished: %s", id))
+	}
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	return jsonResponse(202, status)
+}
+
+func timeAttr(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{S: aws.String(t.UTC().Format(time.RFC3339))}
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/pkg/errors"
+)
+
+// snsMaxMessageSize is the SNS limit, larger replies are sent without rows
+const snsMaxMessageSize = 256 * 1024
+
+// Job is a run request published to SNS by other automation
+type Job struct {
+	// ID is used to cancel the job, SNS message id by default
+	ID string `json:"id"`
+	// Profile is a name of facts set from FACT_PROFILES
+	Profile string `json:"profile"`
+	// Facts are collected when no profile is given
+	Facts map[string]string `json:"facts"`
+	// InstanceIDs to collect facts from
+	InstanceIDs []string `json:"instance_ids"`
+	// Hosts are contacted instead of discovered instances
+	Hosts []string `json:"hosts"`
+	// Selector is the target expression of discovered instances
+	Selector string `json:"selector"`
+	// Refresh clears the inventory cache before discovery
+	Refresh bool `json:"refresh"`
+	// ReplyTopic receives results of the run
+	ReplyTopic string `json:"reply_topic"`
+	// Labels are stored with results of the run
+	Labels map[string]string `json:"labels"`
+}
+
+// options converts the job into run options
+func (j *Job) options() (RunOptions, error) {
+	opts := RunOptions{InstanceIDs: j.InstanceIDs, Hosts: j.Hosts, Selector: j.Selector, Facts: j.Facts, Labels: j.Labels, RefreshInventory: j.Refresh}
+
+	if len(j.InstanceIDs) == 0 && len(j.Hosts) == 0 && j.Selector == "" {
+		return opts, errors.Errorf("Job should list instance_ids or hosts or give selector")
+	}
+	if j.Selector != "" {
+		if _, err := parseSelector(j.Selector); err != nil {
+			return opts, errors.Wrap(err, "Can't parse selector")
+		}
+	}
+
+	if j.Profile != "" {
+		profiles := map[string]map[string]string{}
+		if err := json.Unmarshal([]byte(getEnv("FACT_PROFILES", "{}")), &profiles); err != nil {
+			return opts, errors.Wrap(err, "Can't parse FACT_PROFILES")
+		}
+
+		facts, ok := profiles[j.Profile]
+		if !ok {
+			return opts, errors.Errorf("Unknown facts profile: '%s'", j.Profile)
+		}
+		opts.Facts = facts
+	}
+
+	readOnly, err := strictReadOnly()
+	if err != nil {
+		return opts, err
+	}
+	if readOnly && opts.Facts != nil {
+		if err := checkReadOnly(opts.Facts); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+// isSNSEvent tells if the raw event was delivered by SNS
+func isSNSEvent(event json.RawMessage) (events.SNSEvent, bool) {
+	snsEvent := events.SNSEvent{}
+	if err := json.Unmarshal(event, &snsEvent); err != nil {
+		return snsEvent, false
+	}
+
+	return snsEvent, len(snsEvent.Records) > 0 && snsEvent.Records[0].EventSource == "aws:sns"
+}
+
+// handleSNS runs jobs from SNS messages. Malformed jobs are dropped since
+// redelivery won't fix them, failed runs are retried by Lambda.
+func (h *Handler) handleSNS(ctx context.Context, snsEvent events.SNSEvent) error {
+	for _, record := range snsEvent.Records {
+		job := &Job{}
+		if err := json.Unmarshal([]byte(record.SNS.Message), job); err != nil {
+			fmt.Println(errors.Wrapf(err, "Can't parse job message %s", record.SNS.MessageID))
+			continue
+		}
+
+		opts, err := job.options()
+		if err != nil {
+			fmt.Println(errors.Wrapf(err, "Invalid job message %s", record.SNS.MessageID))
+			continue
+		}
+
+		opts.JobID = job.ID
+		if opts.JobID == "" {
+			opts.JobID = record.SNS.MessageID
+		}
+
+		result, err := h.runJob(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			continue
+		}
+
+		if job.ReplyTopic != "" {
+			if err := publishReply(job.ReplyTopic, result); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// runJob runs the job which could be cancelled when JOBS_TABLE is set,
+// nil result means the job was cancelled before the start
+func (h *Handler) runJob(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	if jobTable() == "" {
+		return h.deps.Worker(ctx, opts)
+	}
+
+	started, err := startJob(opts.JobID)
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		fmt.Printf("Job %s was cancelled before the start, skipping\n", opts.JobID)
+		return nil, nil
+	}
+
+	jobCtx, stop := watchJob(ctx, opts.JobID)
+	result, err := h.deps.Worker(jobCtx, opts)
+	stop()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, finishJob(opts.JobID, &result.RunMeta)
+}
+
+func publishReply(topicArn string, result *RunResult) error {
+	body, err := marshalOutput(result)
+	if err != nil {
+		return err
+	}
+
+	if len(body) > snsMaxMessageSize {
+		var reply interface{} = result.RunMeta
+		if historyEnabled() {
+			if reply, err = storeResult(body, &result.RunMeta, topicArn); err != nil {
+				return err
+			}
+		}
+
+		if body, err = marshalOutput(reply); err != nil {
+			return err
+		}
+	}
+
+	_, err = sns.New(awsSession()).Publish(&sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String("lambda-gorunner run " + result.RunID),
+		Message:  aws.String(string(body)),
+	})
+
+	return errors.Wrapf(err, "Can't publish reply to %s", topicArn)
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
 )
 
 func panic(err error) {
@@ -20,3 +23,36 @@ func getEnv(name, fallback string) string {
 
 	return value
 }
+
+// splitList splits comma separated list skipping empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// awsSession resolves endpoints for the partition of the session region (aws, aws-us-gov, aws-cn),
+// AWS_CA_BUNDLE is read by the SDK itself
+func awsSession() *session.Session {
+	opts := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}
+
+	if value := getEnv("API_ENDPOINTS", ""); value != "" {
+		resolver, err := endpointResolver(value)
+		if err != nil {
+			panic(err)
+		}
+		opts.Config.EndpointResolver = resolver
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(opts))
+	sess.Handlers.Send.PushBack(countAPICall)
+
+	return sess
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/pkg/errors"
 )
 
 // Response is of type APIGatewayProxyResponse since we're leveraging the
@@ -14,31 +18,200 @@ import (
 // https://serverless.com/framework/docs/providers/aws/events/apigateway/#lambda-proxy-integration
 type Response events.APIGatewayProxyResponse
 
-// Handler is our lambda handler invoked by the `lambda.Start` function call
-func Handler(ctx context.Context) (response Response, err error) {
+// WorkerFunc collects facts, Worker is used in production
+type WorkerFunc func(ctx context.Context, opts RunOptions) (*RunResult, error)
 
-	res, err := Worker()
+// HandlerDeps are replaceable dependencies of the Handler
+type HandlerDeps struct {
+	Worker WorkerFunc
+	Now    func() time.Time
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call.
+// It serves API Gateway requests, jobs published to SNS, deployment verification
+// and custom AWS Config rules.
+type Handler struct {
+	deps HandlerDeps
+}
+
+// NewHandler returns the handler using given dependencies
+func NewHandler(deps HandlerDeps) *Handler {
+	if deps.Worker == nil {
+		deps.Worker = Worker
+	}
+	if deps.Now == nil {
+		deps.Now = time.Now
+	}
+
+	return &Handler{deps: deps}
+}
+
+// Handle detects the event type and routes it
+func (h *Handler) Handle(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	if snsEvent, ok := isSNSEvent(event); ok {
+		return nil, h.handleSNS(ctx, snsEvent)
+	}
+
+	if cpEvent, ok := isCodePipelineEvent(event); ok {
+		return nil, h.handleCodePipeline(ctx, cpEvent)
+	}
+
+	if hookEvent, ok := isCodeDeployHookEvent(event); ok {
+		return nil, h.handleCodeDeployHook(ctx, hookEvent)
+	}
+
+	if configEvent, ok := isConfigRuleEvent(event); ok {
+		return nil, h.handleConfigRule(ctx, configEvent)
+	}
+
+	if isDigestEvent(event) {
+		return nil, h.handleDigest(ctx)
+	}
+
+	if isWidgetEvent(event) {
+		return h.handleWidget(ctx)
+	}
+
+	request := events.APIGatewayProxyRequest{}
+	if len(event) > 0 {
+		if err := json.Unmarshal(event, &request); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.HandleAPI(ctx, request)
+}
+
+// HandleAPI routes API Gateway requests, errors are returned as JSON responses
+func (h *Handler) HandleAPI(ctx context.Context, request events.APIGatewayProxyRequest) (response Response, err error) {
+	startTime := h.deps.Now()
+
+	switch request.Resource {
+	case "/summary":
+		response, err = handleSummary(request)
+	case "/config":
+		response, err = handleConfig(request)
+	case "/runs/{id}":
+		response, err = handleGetRun(request)
+	case "/runs/{idA}/diff/{idB}":
+		response, err = handleRunDiff(request)
+	case "/facts/{name}/values":
+		response, err = handleFactValues(request)
+	case "/verify":
+		response, err = h.handleVerify(ctx, request)
+	case "/instances/{id}/facts":
+		response, err = h.handleInstanceFacts(ctx, request)
+	case "/jobs/{id}":
+		response, err = handleCancelJob(request)
+	default:
+		response, err = h.handleRun(ctx, request)
+	}
+
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	response.Headers["Server-Timing"] = fmt.Sprintf("total;dur=%d", h.deps.Now().Sub(startTime).Milliseconds())
+
+	return response, nil
+}
+
+// handleRun collects facts from the fleet
+func (h *Handler) handleRun(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	opts := RunOptions{}
+	if value := request.QueryStringParameters["tag_filters"]; value != "" {
+		if err := json.Unmarshal([]byte(value), &opts.Tags); err != nil {
+			return errorResponse(400, errors.Wrap(err, "Can't parse tag_filters"))
+		}
+	}
+
+	if value := request.QueryStringParameters["selector"]; value != "" {
+		if _, err := parseSelector(value); err != nil {
+			return errorResponse(400, errors.Wrap(err, "Can't parse selector"))
+		}
+		opts.Selector = value
+	}
+
+	if value := request.QueryStringParameters["hosts"]; value != "" {
+		opts.Hosts = splitList(value)
+	}
+
+	if value := request.QueryStringParameters["refresh"]; value != "" {
+		refresh, err := strconv.ParseBool(value)
+		if err != nil {
+			return errorResponse(400, errors.Wrap(err, "Can't parse refresh"))
+		}
+		opts.RefreshInventory = refresh
+	}
+
+	if value := request.QueryStringParameters["labels"]; value != "" {
+		labels, err := parseLabels(value)
+		if err != nil {
+			return errorResponse(400, err)
+		}
+		opts.Labels = labels
+	}
+
+	groupBy, err := getGroupBy(request.QueryStringParameters["group_by"])
 	if err != nil {
-		return
+		return errorResponse(400, err)
 	}
 
-	jsonRes, err := json.Marshal(res)
+	tmpl, err := getOutputTemplate()
 	if err != nil {
-		return
+		return errorResponse(500, err)
+	}
+	format, err := getOutputFormat()
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	res, err := h.deps.Worker(ctx, opts)
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	// rows are returned as is unless the summary is requested,
+	// the run description is returned when rows go to other sinks only
+	var body interface{} = res.Rows
+	if !sinkEnabled("response") {
+		res.Rows = nil
+		body = res
+	}
+	if request.QueryStringParameters["summary"] == "true" {
+		body = res
+	}
+	if groupBy != "" && res.Rows != nil {
+		res.Groups = groupRows(res.Rows)
+	}
+
+	if tmpl != nil {
+		return templateResponse(tmpl, res)
+	}
+	if format == "markdown" {
+		report, err := markdownReport(res)
+		if err != nil {
+			return errorResponse(500, err)
+		}
+		return textResponse(report, "text/markdown"), nil
+	}
+	if format == "dot" {
+		return textResponse(dotReport(buildTopology(res.Rows)), "text/vnd.graphviz"), nil
+	}
+	if format == "graph" {
+		return jsonResponse(200, buildTopology(res.Rows))
 	}
 
-	response = Response{
-		StatusCode:      200,
-		IsBase64Encoded: false,
-		Body:            string(jsonRes),
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
+	// grouped rows aren't repeated in the flat list
+	if res.Groups != nil {
+		res.Rows = nil
+		body = res
 	}
 
-	return
+	return resultResponse(body, &res.RunMeta, apiCaller(request))
 }
 
 func main() {
-	lambda.Start(Handler)
+	preloadCredentials()
+	lambda.Start(NewHandler(HandlerDeps{}).Handle)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pkg/errors"
+)
+
+func TestHandleAPI(t *testing.T) {
+	result := &RunResult{
+		RunMeta: RunMeta{RunID: "run", Summary: map[string]int{"instances": 1}},
+		Rows:    []ResRow{{InstanceId: "i-1", Facts: map[string]string{"kernel": "5.4"}}},
+	}
+
+	tests := []struct {
+		name     string
+		resource string
+		query    map[string]string
+		err      error
+		status   int
+		prefix   string
+		runs     int
+		refresh  bool
+		selector string
+	}{
+		{
+			name:   "rows by default",
+			status: 200,
+			prefix: `[{"InstanceId":"i-1",`,
+			runs:   1,
+		},
+		{
+			name:   "summary",
+			query:  map[string]string{"summary": "true"},
+			status: 200,
+			prefix: `{"RunID":"run","StartedAt":"0001-01-01T00:00:00Z",`,
+			runs:   1,
+		},
+		{
+			name:   "worker error",
+			err:    errors.New("Can't discover instances"),
+			status: 500,
+			prefix: `{"Error":"Can't discover instances"}`,
+			runs:   1,
+		},
+		{
+			name:    "refresh",
+			query:   map[string]string{"refresh": "true"},
+			status:  200,
+			prefix:  `[{"InstanceId":"i-1",`,
+			runs:    1,
+			refresh: true,
+		},
+		{
+			name:   "invalid refresh",
+			query:  map[string]string{"refresh": "maybe"},
+			status: 400,
+			prefix: `{"Error":"Can't parse refresh`,
+		},
+		{
+			name:     "selector",
+			query:    map[string]string{"selector": "tag:Role=web and not az=us-east-1a"},
+			status:   200,
+			prefix:   `[{"InstanceId":"i-1",`,
+			runs:     1,
+			selector: "tag:Role=web and not az=us-east-1a",
+		},
+		{
+			name:   "invalid selector",
+			query:  map[string]string{"selector": "role=web"},
+			status: 400,
+			prefix: `{"Error":"Can't parse selector: Unknown selector key: 'role'`,
+		},
+		{
+			name:   "invalid tag filters",
+			query:  map[string]string{"tag_filters": "{not json"},
+			status: 400,
+			prefix: `{"Error":"Can't parse tag_filters`,
+		},
+		{
+			name:     "run without history",
+			resource: "/runs/{id}",
+			status:   404,
+			prefix:   `{"Error":"Run history is disabled, set HISTORY_BUCKET to enable it"}`,
+		},
+		{
+			name:     "diff without history",
+			resource: "/runs/{idA}/diff/{idB}",
+			status:   404,
+			prefix:   `{"Error":"Run history is disabled, set HISTORY_BUCKET to enable it"}`,
+		},
+		{
+			name:     "fact values without history",
+			resource: "/facts/{name}/values",
+			status:   404,
+			prefix:   `{"Error":"Run history is disabled, set HISTORY_BUCKET to enable it"}`,
+		},
+		{
+			name:     "summary without history",
+			resource: "/summary",
+			status:   404,
+			prefix:   `{"Error":"Run history is disabled, set HISTORY_BUCKET to enable it"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		runs := 0
+		refresh := false
+		selector := ""
+		now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		h := NewHandler(HandlerDeps{
+			Worker: func(ctx context.Context, opts RunOptions) (*RunResult, error) {
+				runs++
+				refresh = opts.RefreshInventory
+				selector = opts.Selector
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return result, nil
+			},
+			Now: func() time.Time {
+				now = now.Add(1500 * time.Millisecond)
+				return now
+			},
+		})
+
+		response, err := h.HandleAPI(context.Background(), events.APIGatewayProxyRequest{
+			Resource:              tt.resource,
+			QueryStringParameters: tt.query,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if response.StatusCode != tt.status || !strings.HasPrefix(response.Body, tt.prefix) {
+			t.Errorf("%s: got %d %s\nwant %d %s", tt.name, response.StatusCode, response.Body, tt.status, tt.prefix)
+		}
+		if timing := response.Headers["Server-Timing"]; timing != "total;dur=1500" {
+			t.Errorf("%s: unexpected Server-Timing: %q", tt.name, timing)
+		}
+		if runs != tt.runs {
+			t.Errorf("%s: worker is called %d times, want %d", tt.name, runs, tt.runs)
+		}
+		if refresh != tt.refresh {
+			t.Errorf("%s: inventory refresh is %v, want %v", tt.name, refresh, tt.refresh)
+		}
+		if selector != tt.selector {
+			t.Errorf("%s: selector is %q, want %q", tt.name, selector, tt.selector)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// latencyBuckets are upper bounds of histogram buckets in seconds
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramBucket counts observations not greater than Le seconds
+// and greater than the previous bucket bound
+type HistogramBucket struct {
+	Le    string
+	Count int
+}
+
+// LatencyHistogram describes durations of successful ssh connections (dial and handshake)
+type LatencyHistogram struct {
+	Count   int
+	P50     float64
+	P90     float64
+	Max     float64
+	Buckets []HistogramBucket
+}
+
+// latencyRecorder collects durations from concurrent goroutines
+type latencyRecorder struct {
+	sync.Mutex
+	durations []time.Duration
+}
+
+func (r *latencyRecorder) Observe(d time.Duration) {
+	r.Lock()
+	r.durations = append(r.durations, d)
+	r.Unlock()
+}
+
+// Histogram returns buckets and percentiles in seconds
+func (r *latencyRecorder) Histogram() *LatencyHistogram {
+	r.Lock()
+	durations := append([]time.Duration{}, r.durations...)
+	r.Unlock()
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	h := &LatencyHistogram{Count: len(durations), Buckets: []HistogramBucket{}}
+	for _, le := range latencyBuckets {
+		h.Buckets = append(h.Buckets, HistogramBucket{Le: strconv.FormatFloat(le, 'f', -1, 64)})
+	}
+	h.Buckets = append(h.Buckets, HistogramBucket{Le: "+Inf"})
+
+	for _, d := range durations {
+		i := sort.SearchFloat64s(latencyBuckets, d.Seconds())
+		h.Buckets[i].Count++
+	}
+
+	if len(durations) > 0 {
+		h.P50 = percentile(durations, 0.5).Seconds()
+		h.P90 = percentile(durations, 0.9).Seconds()
+		h.Max = durations[len(durations)-1].Seconds()
+	}
+
+	return h
+}
+
+// percentile expects sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return sorted[i]
+}
+
+// emitMetrics prints run metrics in CloudWatch Embedded Metric Format
+// when METRICS_NAMESPACE is set
+func emitMetrics(meta *RunMeta) {
+	namespace := getEnv("METRICS_NAMESPACE", "")
+	if namespace == "" {
+		return
+	}
+
+	values := map[string]float64{
+		"Instances": float64(meta.Summary["instances"]),
+		"Failed":    float64(meta.Summary["failed"]),
+		"Duration":  meta.Duration,
+	}
+	units := map[string]string{
+		"Instances": "Count",
+		"Failed":    "Count",
+		"Duration":  "Seconds",
+	}
+
+	if h := meta.DialLatency; h != nil && h.Count > 0 {
+		values["DialLatencyP50"] = h.P50
+		values["DialLatencyP90"] = h.P90
+		values["DialLatencyMax"] = h.Max
+		units["DialLatencyP50"] = "Seconds"
+		units["DialLatencyP90"] = "Seconds"
+		units["DialLatencyMax"] = "Seconds"
+	}
+
+	if u := meta.Usage; u != nil {
+		values["SSHBytes"] = float64(u.SSHBytesSent + u.SSHBytesReceived)
+		values["SSHSessions"] = float64(u.SSHSessions)
+		values["APICalls"] = float64(u.APICalls)
+		values["PeakGoroutines"] = float64(u.PeakGoroutines)
+		units["SSHBytes"] = "Bytes"
+		units["SSHSessions"] = "Count"
+		units["APICalls"] = "Count"
+		units["PeakGoroutines"] = "Count"
+	}
+
+	metrics := []map[string]string{}
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	doc := map[string]interface{}{}
+	for _, name := range names {
+		metrics = append(metrics, map[string]string{"Name": name, "Unit": units[name]})
+		doc[name] = values[name]
+	}
+
+	doc["FunctionName"] = lambdacontext.FunctionName
+	doc["RunID"] = meta.RunID
+	doc["_aws"] = map[string]interface{}{
+		"Timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+		"CloudWatchMetrics": []map[string]interface{}{
+			{
+				"Namespace":  namespace,
+				"Dimensions": [][]string{{"FunctionName"}},
+				"Metrics":    metrics,
+			},
+		},
+	}
+
+	line, err := json.Marshal(doc)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(string(line))
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultOutputCase = "pascal"
+	defaultFactOrder  = "sorted"
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	factValuesType    = reflect.TypeOf(FactValues{})
+)
+
+// outputEncoder serializes results with configured field naming.
+// Only struct field names are renamed, map keys (fact labels) are kept as is.
+type outputEncoder struct {
+	rename    func(string) string
+	omitEmpty bool
+
+	// factOrder keeps positions of labels declared in FACTS, facts are sorted by labels if nil
+	factOrder map[string]int
+}
+
+func newOutputEncoder() (*outputEncoder, error) {
+	e := &outputEncoder{}
+
+	switch outputCase := getEnv("OUTPUT_CASE", defaultOutputCase); outputCase {
+	case "pascal":
+		e.rename = func(name string) string { return name }
+	case "camel":
+		e.rename = toCamelCase
+	case "snake":
+		e.rename = toSnakeCase
+	default:
+		return nil, errors.Errorf("Unknown OUTPUT_CASE: '%s' (available: pascal, camel, snake)", outputCase)
+	}
+
+	switch strings.ToLower(getEnv("OMIT_EMPTY", "")) {
+	case "1", "true", "yes":
+		e.omitEmpty = true
+	}
+
+	switch factOrder := getEnv("FACT_ORDER", defaultFactOrder); factOrder {
+	case "sorted":
+	case "declared":
+		order, err := declaredFactOrder(getEnv("FACTS", defaultFacts))
+		if err != nil {
+			return nil, err
+		}
+		e.factOrder = order
+	default:
+		return nil, errors.Errorf("Unknown FACT_ORDER: '%s' (available: sorted, declared)", factOrder)
+	}
+
+	return e, nil
+}
+
+// marshalOutput serializes v using OUTPUT_CASE and OMIT_EMPTY settings
+func marshalOutput(v interface{}) ([]byte, error) {
+	e, err := newOutputEncoder()
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Marshal(v)
+}
+
+func (e *outputEncoder) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(e.convert(reflect.ValueOf(v)))
+}
+
+// convert builds a tree of json-ready values with renamed struct fields
+func (e *outputEncoder) convert(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Type().Implements(jsonMarshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return e.convert(v.Elem())
+	case reflect.Struct:
+		obj := orderedObject{}
+		e.convertFields(v, &obj)
+		return obj
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type() == factValuesType {
+			return e.convertFacts(v.Interface().(FactValues))
+		}
+		m := map[string]interface{}{}
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = e.convert(iter.Value())
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			list[i] = e.convert(v.Index(i))
+		}
+		return list
+	}
+
+	return v.Interface()
+}
+
+// convertFacts orders facts by labels or by their position in FACTS.
+// Labels not declared in FACTS (e.g. from the facts tag) follow sorted.
+func (e *outputEncoder) convertFacts(facts FactValues) orderedObject {
+	labels := []string{}
+	for label := range facts {
+		labels = append(labels, label)
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		pi, iDeclared := e.factOrder[labels[i]]
+		pj, jDeclared := e.factOrder[labels[j]]
+		switch {
+		case iDeclared && jDeclared:
+			return pi < pj
+		case iDeclared != jDeclared:
+			return iDeclared
+		}
+		return labels[i] < labels[j]
+	})
+
+	obj := orderedObject{}
+	for _, label := range labels {
+		obj = append(obj, objectField{Key: label, Value: facts[label]})
+	}
+
+	return obj
+}
+
+// declaredFactOrder reads positions of labels in FACTS JSON
+func declaredFactOrder(facts string) (map[string]int, error) {
+	order := map[string]int{}
+
+	dec := json.NewDecoder(strings.NewReader(facts))
+	if _, err := dec.Token(); err != nil {
+		return nil, errors.Wrap(err, "Can't parse FACTS")
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, errors.Wrap(err, "Can't parse FACTS")
+		}
+		if label, ok := token.(string); ok {
+			order[label] = len(order)
+		}
+
+		var command string
+		if err := dec.Decode(&command); err != nil {
+			return nil, errors.Wrap(err, "Can't parse FACTS")
+		}
+	}
+
+	return order, nil
+}
+
+func (e *outputEncoder) convertFields(v reflect.Value, obj *orderedObject) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name, opts := field.Name, ""
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) > 1 {
+				opts = parts[1]
+			}
+		}
+
+		fv := v.Field(i)
+
+		// flatten embedded structs like encoding/json does
+		if field.Anonymous && fv.Kind() == reflect.Struct && name == field.Name {
+			e.convertFields(fv, obj)
+			continue
+		}
+
+		if (e.omitEmpty || strings.Contains(opts, "omitempty")) && isEmptyValue(fv) {
+			continue
+		}
+
+		*obj = append(*obj, objectField{Key: e.rename(name), Value: e.convert(fv)})
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return v.IsZero()
+}
+
+type objectField struct {
+	Key   string
+	Value interface{}
+}
+
+// orderedObject keeps struct fields in their declaration order
+type orderedObject []objectField
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// splitWords splits Go identifier into words keeping acronyms together:
+// InstanceId -> [Instance Id], IPs -> [IPs], HTTPServer -> [HTTP Server]
+func splitWords(name string) []string {
+	runes := []rune(name)
+	words := []string{}
+
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+
+		split := false
+		switch {
+		case unicode.IsUpper(cur) && !unicode.IsUpper(prev):
+			split = true
+		case unicode.IsUpper(cur) && unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+			// plural acronym: IPs
+			split = !(runes[i+1] == 's' && (i+2 == len(runes) || unicode.IsUpper(runes[i+2])))
+		}
+
+		if split {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+
+	return append(words, string(runes[start:]))
+}
+
+func toSnakeCase(name string) string {
+	words := splitWords(name)
+	for i := range words {
+		words[i] = strings.ToLower(words[i])
+	}
+
+	return strings.Join(words, "_")
+}
+
+func toCamelCase(name string) string {
+	words := splitWords(name)
+	for i := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(words[i])
+			continue
+		}
+		words[i] = strings.ToUpper(words[i][:1]) + strings.ToLower(words[i][1:])
+	}
+
+	return strings.Join(words, "")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// asgNameTag is set by EC2 Auto Scaling on instances of the group
+const asgNameTag = "aws:autoscaling:groupName"
+
+const (
+	reachReached     = "reached"
+	reachPartial     = "partial"
+	reachUnreachable = "unreachable"
+	reachOffline     = "offline"
+)
+
+// TopologyGraph shows instances nested in VPCs, subnets and Auto Scaling Groups
+// with their reachability from the function
+type TopologyGraph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// GraphNode is the function, a network segment or an instance. Segments count
+// reached instances of Total online ones.
+type GraphNode struct {
+	ID      string
+	Kind    string
+	Label   string
+	Parent  string `json:",omitempty"`
+	Status  string
+	Reached int `json:",omitempty"`
+	Total   int `json:",omitempty"`
+}
+
+// GraphEdge connects the function to subnets, status tells if instances
+// of the subnet were reached
+type GraphEdge struct {
+	From   string
+	To     string
+	Status string
+}
+
+// rowReachability tells if the function connected to the instance: rows with
+// facts are reached even if some commands failed
+func rowReachability(row ResRow) string {
+	switch {
+	case row.State != "":
+		return reachOffline
+	case row.Error == "" || len(row.Facts) > 0:
+		return reachReached
+	default:
+		return reachUnreachable
+	}
+}
+
+// buildTopology groups rows by VPC, subnet and Auto Scaling Group. Hosts
+// outside of VPCs are grouped by their discovery source.
+func buildTopology(rows []ResRow) *TopologyGraph {
+	graph := &TopologyGraph{}
+	nodes := map[string]*GraphNode{}
+	order := []string{}
+
+	segment := func(id, kind, label, parent string) *GraphNode {
+		if node, ok := nodes[id]; ok {
+			return node
+		}
+		nodes[id] = &GraphNode{ID: id, Kind: kind, Label: label, Parent: parent}
+		order = append(order, id)
+		return nodes[id]
+	}
+
+	lambda := segment("lambda", "lambda", "lambda-gorunner", "")
+	instances := []GraphNode{}
+
+	for _, row := range rows {
+		var vpc, subnet *GraphNode
+		if row.VpcId != "" {
+			vpc = segment("vpc:"+row.VpcId, "vpc", row.VpcId, "")
+		} else {
+			vpc = segment("source:"+row.Source, "source", row.Source, "")
+		}
+		if row.SubnetId != "" {
+			subnet = segment("subnet:"+row.SubnetId, "subnet", row.SubnetId, vpc.ID)
+		} else {
+			subnet = segment(vpc.ID+"/subnet:", "subnet", "no subnet", vpc.ID)
+		}
+		segments := []*GraphNode{vpc, subnet}
+		if row.AutoScalingGroup != "" {
+			segments = append(segments, segment(subnet.ID+"/asg:"+row.AutoScalingGroup, "asg", row.AutoScalingGroup, subnet.ID))
+		}
+
+		status := rowReachability(row)
+		for _, node := range segments {
+			if status == reachOffline {
+				continue
+			}
+			node.Total++
+			if status == reachReached {
+				node.Reached++
+			}
+		}
+
+		label := row.InstanceId
+		if row.Name != "" && row.Name != row.InstanceId {
+			label = row.Name + "\n" + row.InstanceId
+		}
+		instances = append(instances, GraphNode{
+			ID:     "instance:" + row.InstanceId,
+			Kind:   "instance",
+			Label:  label,
+			Parent: segments[len(segments)-1].ID,
+			Status: status,
+		})
+	}
+
+	for _, id := range order {
+		node := nodes[id]
+		if node != lambda {
+			node.Status = segmentReachability(node.Reached, node.Total)
+		}
+		graph.Nodes = append(graph.Nodes, *node)
+		if node.Kind == "subnet" {
+			graph.Edges = append(graph.Edges, GraphEdge{From: lambda.ID, To: node.ID, Status: node.Status})
+		}
+	}
+	graph.Nodes = append(graph.Nodes, instances...)
+
+	return graph
+}
+
+func segmentReachability(reached, total int) string {
+	switch {
+	case total == 0:
+		return reachOffline
+	case reached == total:
+		return reachReached
+	case reached > 0:
+		return reachPartial
+	default:
+		return reachUnreachable
+	}
+}
+
+// dotColors are fill and edge colors of reachability statuses
+var dotColors = map[string][2]string{
+	reachReached:     {"palegreen", "forestgreen"},
+	reachPartial:     {"gold", "orange"},
+	reachUnreachable: {"lightpink", "red"},
+	reachOffline:     {"lightgrey", "grey"},
+}
+
+// dotReport renders the topology in Graphviz DOT language: segments are nested
+// clusters and edges from the function to subnets are colored by reachability
+func dotReport(graph *TopologyGraph) string {
+	children := map[string][]GraphNode{}
+	for _, node := range graph.Nodes {
+		if node.Kind != "lambda" {
+			children[node.Parent] = append(children[node.Parent], node)
+		}
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("digraph topology {\n")
+	b.WriteString("  rankdir=LR;\n  compound=true;\n  node [shape=box, style=filled];\n")
+	b.WriteString(`  "lambda" [label="lambda-gorunner", shape=ellipse, fillcolor=lightblue];` + "\n")
+
+	// edges point to the first instance of the subnet clipped at its cluster
+	anchors := map[string]string{}
+
+	var render func(node GraphNode, indent string)
+	render = func(node GraphNode, indent string) {
+		if node.Kind == "instance" {
+			fmt.Fprintf(b, "%s%s [label=%s, fillcolor=%s];\n", indent, dotQuote(node.ID), dotQuote(node.Label), dotColors[node.Status][0])
+			return
+		}
+
+		fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+node.ID))
+		fmt.Fprintf(b, "%s  label=%s;\n", indent, dotQuote(fmt.Sprintf("%s %s (%d/%d reached)", node.Kind, node.Label, node.Reached, node.Total)))
+		fmt.Fprintf(b, "%s  style=filled; fillcolor=white; color=%s;\n", indent, dotColors[node.Status][1])
+		for _, child := range children[node.ID] {
+			render(child, indent+"  ")
+			if _, ok := anchors[node.ID]; !ok && child.Kind == "instance" {
+				anchors[node.ID] = child.ID
+			}
+			if anchor, ok := anchors[child.ID]; ok {
+				if _, ok := anchors[node.ID]; !ok {
+					anchors[node.ID] = anchor
+				}
+			}
+		}
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+	for _, node := range children[""] {
+		render(node, "  ")
+	}
+
+	edges := append([]GraphEdge{}, graph.Edges...)
+	sort.SliceStable(edges, func(i, j int) bool { return edges[i].To < edges[j].To })
+	for _, edge := range edges {
+		anchor, ok := anchors[edge.To]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(b, "  %s -> %s [lhead=%s, color=%s];\n", dotQuote(edge.From), dotQuote(anchor), dotQuote("cluster_"+edge.To), dotColors[edge.Status][1])
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// dotQuote quotes DOT identifiers and labels, line breaks are kept as \n
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+
+	return `"` + s + `"`
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// groupByZone nests rows under regions and availability zones
+const groupByZone = "az"
+
+// ResGroup contains rows of a single availability zone of the region,
+// rows of instances without zone are grouped by region only
+type ResGroup struct {
+	Region           string
+	AvailabilityZone string `json:",omitempty"`
+	// Summary counts instances, failed and offline ones of the group
+	Summary map[string]int
+	Rows    []ResRow
+}
+
+// getGroupBy validates GROUP_BY or the value given by the request
+func getGroupBy(value string) (string, error) {
+	if value == "" {
+		value = getEnv("GROUP_BY", "")
+	}
+
+	switch value {
+	case "", groupByZone:
+		return value, nil
+	default:
+		return "", errors.Errorf("Unknown GROUP_BY: '%s' (available: %s)", value, groupByZone)
+	}
+}
+
+// groupRows nests rows under regions and availability zones, groups are sorted
+// by region and zone while rows keep their order
+func groupRows(rows []ResRow) []ResGroup {
+	groups := []ResGroup{}
+	index := map[[2]string]int{}
+
+	for _, row := range rows {
+		key := [2]string{row.Region, row.AvailabilityZone}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, ResGroup{
+				Region:           row.Region,
+				AvailabilityZone: row.AvailabilityZone,
+				Summary:          map[string]int{"instances": 0, "failed": 0},
+			})
+		}
+
+		group := &groups[i]
+		group.Rows = append(group.Rows, row)
+		group.Summary["instances"]++
+		switch {
+		case row.State != "":
+			group.Summary["offline"]++
+		case row.Error != "":
+			group.Summary["failed"]++
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Region != groups[j].Region {
+			return groups[i].Region < groups[j].Region
+		}
+		return groups[i].AvailabilityZone < groups[j].AvailabilityZone
+	})
+
+	return groups
+}
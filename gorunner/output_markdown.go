@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultOutputFormat    = "json"
+	defaultDisplayTimezone = "UTC"
+
+	displayTimeLayout = "2006-01-02 15:04:05 MST"
+)
+
+// getDisplayLocation returns DISPLAY_TIMEZONE of human-facing formats, e.g. Europe/Berlin
+func getDisplayLocation() (*time.Location, error) {
+	name := getEnv("DISPLAY_TIMEZONE", defaultDisplayTimezone)
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.Errorf("Unknown DISPLAY_TIMEZONE: '%s'", name)
+	}
+
+	return loc, nil
+}
+
+// displayTime formats the time in the location, zero time is empty
+func displayTime(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.In(loc).Format(displayTimeLayout)
+}
+
+// getOutputFormat returns OUTPUT_FORMAT of API responses
+func getOutputFormat() (string, error) {
+	switch format := getEnv("OUTPUT_FORMAT", defaultOutputFormat); format {
+	case "json", "markdown", "dot", "graph":
+		return format, nil
+	default:
+		return "", errors.Errorf("Unknown OUTPUT_FORMAT: '%s' (available: json, markdown, dot, graph)", format)
+	}
+}
+
+// markdownReport renders the run as a summary header followed by
+// a table of instances and their facts
+func markdownReport(res *RunResult) (string, error) {
+	e, err := newOutputEncoder()
+	if err != nil {
+		return "", err
+	}
+	loc, err := getDisplayLocation()
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{
+		fmt.Sprintf("## Run %s", res.RunID),
+		"",
+		fmt.Sprintf("Started: %s, completed: %s, duration: %.1fs", displayTime(res.StartedAt, loc), displayTime(res.CompletedAt, loc), res.Duration),
+	}
+
+	if len(res.Labels) > 0 {
+		lines = append(lines, "", "Labels: "+joinPairs(res.Labels))
+	}
+	if len(res.Summary) > 0 {
+		summary := map[string]string{}
+		for k, v := range res.Summary {
+			summary[k] = fmt.Sprint(v)
+		}
+		lines = append(lines, "", "Summary: "+joinPairs(summary))
+	}
+	if res.Hint != "" {
+		lines = append(lines, "", res.Hint)
+	}
+
+	if len(res.Rows) == 0 {
+		return strings.Join(lines, "\n") + "\n", nil
+	}
+
+	// FACT_ORDER applies to columns
+	labels := []string{}
+	all := FactValues{}
+	for _, label := range factLabels(res.Rows) {
+		all[label] = ""
+	}
+	for _, f := range e.convertFacts(all) {
+		labels = append(labels, f.Key)
+	}
+
+	header := append([]string{"Instance", "Name", "Account", "Region", "IPs"}, labels...)
+	header = append(header, "Completed", "Error")
+	lines = append(lines, "", markdownRow(header), "|"+strings.Repeat("---|", len(header)))
+
+	for _, row := range res.Rows {
+		cells := []string{row.InstanceId, row.Name, row.Account, row.Region, strings.Join(row.IPs, ", ")}
+		for _, label := range labels {
+			cells = append(cells, row.Facts[label])
+		}
+		completed := ""
+		if row.CompletedAt != nil {
+			completed = displayTime(*row.CompletedAt, loc)
+		}
+		cells = append(cells, completed, row.Error)
+
+		lines = append(lines, markdownRow(cells))
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// markdownRow escapes cells, so multiline outputs and pipes don't break the table
+func markdownRow(cells []string) string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		cell = strings.ReplaceAll(strings.TrimSpace(cell), "|", `\|`)
+		escaped[i] = strings.ReplaceAll(cell, "\n", "<br>")
+	}
+
+	return "| " + strings.Join(escaped, " | ") + " |"
+}
+
+// joinPairs formats the map as sorted key=value list
+func joinPairs(m map[string]string) string {
+	pairs := []string{}
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ", ")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const defaultOutputTemplateType = "text/plain"
+
+// templateFuncs are available in OUTPUT_TEMPLATE in addition to the builtin ones
+var templateFuncs = template.FuncMap{
+	"join":       strings.Join,
+	"csv":        csvLine,
+	"factLabels": factLabels,
+	"localTime":  localTime,
+}
+
+// getOutputTemplate parses OUTPUT_TEMPLATE (inline text or s3://bucket/key)
+// or output_template of the config file, nil is returned if neither is set
+func getOutputTemplate() (*template.Template, error) {
+	text := getEnv("OUTPUT_TEMPLATE", "")
+	if text == "" {
+		config, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		text = config.OutputTemplate
+	}
+	if text == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(text, "s3://") {
+		var err error
+		if text, err = readS3Object(text); err != nil {
+			return nil, errors.Wrap(err, "Can't read OUTPUT_TEMPLATE")
+		}
+	}
+
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't parse OUTPUT_TEMPLATE")
+	}
+
+	return tmpl, nil
+}
+
+// parseS3Location returns bucket and key of s3://bucket/key URL
+func parseS3Location(location string) (string, string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return "", "", errors.Errorf("Invalid S3 location: '%s' (should be s3://bucket/key)", location)
+	}
+
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// readS3Object returns content of the object given with s3://bucket/key URL
+func readS3Object(location string) (string, error) {
+	bucket, key, err := parseS3Location(location)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := s3.New(awsSession()).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "Can't read %s", location)
+	}
+	defer out.Body.Close()
+
+	data, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "Can't read %s", location)
+	}
+
+	return string(data), nil
+}
+
+// templateResponse renders the run result with the output template
+func templateResponse(tmpl *template.Template, res *RunResult) (Response, error) {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, res); err != nil {
+		return errorResponse(500, errors.Wrap(err, "Can't render OUTPUT_TEMPLATE"))
+	}
+
+	return textResponse(buf.String(), getEnv("OUTPUT_TEMPLATE_TYPE", defaultOutputTemplateType)), nil
+}
+
+// csvLine joins values into a single CSV record quoting them as needed
+func csvLine(values ...string) (string, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(values); err != nil {
+		return "", err
+	}
+	w.Flush()
+
+	return strings.TrimSuffix(buf.String(), "\n"), w.Error()
+}
+
+// localTime formats time.Time or *time.Time in DISPLAY_TIMEZONE
+func localTime(v interface{}) (string, error) {
+	loc, err := getDisplayLocation()
+	if err != nil {
+		return "", err
+	}
+
+	switch t := v.(type) {
+	case time.Time:
+		return displayTime(t, loc), nil
+	case *time.Time:
+		if t == nil {
+			return "", nil
+		}
+		return displayTime(*t, loc), nil
+	default:
+		return "", errors.Errorf("localTime expects time, got %T", v)
+	}
+}
+
+// factLabels returns sorted labels of facts collected from any row
+func factLabels(rows []ResRow) []string {
+	seen := map[string]bool{}
+	labels := []string{}
+	for _, row := range rows {
+		for label := range row.Facts {
+			if !seen[label] {
+				seen[label] = true
+				labels = append(labels, label)
+			}
+		}
+	}
+	sort.Strings(labels)
+
+	return labels
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOutputCase(t *testing.T) {
+	tests := []struct {
+		name  string
+		snake string
+		camel string
+	}{
+		{"Name", "name", "name"},
+		{"InstanceId", "instance_id", "instanceId"},
+		{"InstanceID", "instance_id", "instanceId"},
+		{"DNSName", "dns_name", "dnsName"},
+		{"PrivateDNSName", "private_dns_name", "privateDnsName"},
+		{"HTTPServer", "http_server", "httpServer"},
+		{"IPs", "ips", "ips"},
+		{"PublicIPs", "public_ips", "publicIps"},
+		{"SSHKeyPath", "ssh_key_path", "sshKeyPath"},
+		{"ID", "id", "id"},
+	}
+
+	for _, tt := range tests {
+		if got := toSnakeCase(tt.name); got != tt.snake {
+			t.Errorf("toSnakeCase(%q) = %q, want %q", tt.name, got, tt.snake)
+		}
+		if got := toCamelCase(tt.name); got != tt.camel {
+			t.Errorf("toCamelCase(%q) = %q, want %q", tt.name, got, tt.camel)
+		}
+	}
+}
+
+func TestMarshalOutput(t *testing.T) {
+	type inner struct {
+		DNSName string
+	}
+	type row struct {
+		InstanceID string
+		Facts      map[string]string
+		Error      string `json:",omitempty"`
+		Skipped    string `json:"-"`
+		Renamed    string `json:"custom_name"`
+		Nested     *inner
+		Tags       []string
+	}
+	value := []row{{
+		InstanceID: "i-1",
+		Facts:      map[string]string{"OsName": "linux"},
+		Skipped:    "x",
+		Nested:     &inner{DNSName: "host"},
+	}}
+
+	tests := []struct {
+		outputCase string
+		omitEmpty  string
+		want       string
+	}{
+		{"", "", `[{"InstanceID":"i-1","Facts":{"OsName":"linux"},"custom_name":"","Nested":{"DNSName":"host"},"Tags":null}]`},
+		{"camel", "", `[{"instanceId":"i-1","facts":{"OsName":"linux"},"custom_name":"","nested":{"dnsName":"host"},"tags":null}]`},
+		{"snake", "", `[{"instance_id":"i-1","facts":{"OsName":"linux"},"custom_name":"","nested":{"dns_name":"host"},"tags":null}]`},
+		{"snake", "true", `[{"instance_id":"i-1","facts":{"OsName":"linux"},"nested":{"dns_name":"host"}}]`},
+		{"pascal", "no", `[{"InstanceID":"i-1","Facts":{"OsName":"linux"},"custom_name":"","Nested":{"DNSName":"host"},"Tags":null}]`},
+	}
+
+	defer os.Unsetenv("OUTPUT_CASE")
+	defer os.Unsetenv("OMIT_EMPTY")
+
+	for _, tt := range tests {
+		os.Setenv("OUTPUT_CASE", tt.outputCase)
+		os.Setenv("OMIT_EMPTY", tt.omitEmpty)
+		if tt.outputCase == "" {
+			os.Unsetenv("OUTPUT_CASE")
+		}
+
+		got, err := marshalOutput(value)
+		if err != nil {
+			t.Fatalf("OUTPUT_CASE=%q: %v", tt.outputCase, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("OUTPUT_CASE=%q OMIT_EMPTY=%q:\n got %s\nwant %s", tt.outputCase, tt.omitEmpty, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalOutputUnknownCase(t *testing.T) {
+	os.Setenv("OUTPUT_CASE", "kebab")
+	defer os.Unsetenv("OUTPUT_CASE")
+
+	if _, err := marshalOutput(nil); err == nil {
+		t.Error("expected error for unknown OUTPUT_CASE")
+	}
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// PipelineStep is a stage of the run, only parameters of its kind are used
+type PipelineStep struct {
+	Step string `yaml:"step"`
+
+	// filter
+	Tags        map[string]string `yaml:"tags"`
+	InstanceIDs []string          `yaml:"instance_ids"`
+
+	// collect
+	Facts      map[string]string `yaml:"facts"`
+	Collectors []string          `yaml:"collectors"`
+
+	// transform
+	Rename  map[string]string `yaml:"rename"`
+	Drop    []string          `yaml:"drop"`
+	Extract map[string]string `yaml:"extract"`
+
+	// rules
+	Rules []Rule `yaml:"rules"`
+
+	// sinks
+	Sinks []string `yaml:"sinks"`
+}
+
+// defaultPipeline is used when the config doesn't define one
+var defaultPipeline = []PipelineStep{{Step: "discover"}, {Step: "collect"}, {Step: "sinks"}}
+
+// pipelineRun is a state passed through the steps
+type pipelineRun struct {
+	ctx       context.Context
+	opts      RunOptions
+	startTime time.Time
+	usage     *usageRecorder
+
+	instances []*InstanceInfo
+	meta      *RunMeta
+	rows      []ResRow
+
+	// discovered and filters explain runs matching no instances
+	discovered int
+	filters    RunFilters
+
+	accountErrors  []AccountError
+	staleDiscovery *StaleDiscovery
+	addressReport  *AddressReport
+
+	// skipped counts instances excluded with EXCLUDE_TAGS, name patterns or age limits,
+	// nil if they aren't set
+	skipped *int
+
+	// unsampled counts instances over MAX_INSTANCES, nil if it's not set
+	unsampled *int
+
+	// sinks are set up before the run to fail fast on wrong settings
+	sinks map[int]map[string]Sink
+}
+
+type pipelineStepFunc func(run *pipelineRun, step *PipelineStep, index int) error
+
+// pipelineSteps contains all supported steps
+var pipelineSteps = map[string]pipelineStepFunc{
+	"discover":  discoverStep,
+	"filter":    filterStep,
+	"collect":   collectStep,
+	"transform": transformStep,
+	"rules":     rulesStep,
+	"sinks":     sinksStep,
+}
+
+// pipelineOrder enforces steps to be listed in the order of data flow
+var pipelineOrder = map[string]int{
+	"discover":  0,
+	"filter":    1,
+	"collect":   2,
+	"transform": 3,
+	"rules":     3,
+	"sinks":     4,
+}
+
+// getPipeline returns the pipeline defined in the config file or the default one
+func getPipeline() ([]PipelineStep, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Pipeline) == 0 {
+		return defaultPipeline, nil
+	}
+
+	stage := 0
+	collected := false
+	for _, step := range config.Pipeline {
+		order, ok := pipelineOrder[step.Step]
+		if !ok {
+			return nil, errors.Errorf("Unknown pipeline step: '%s' (available: discover, filter, collect, transform, rules, sinks)", step.Step)
+		}
+		if order < stage {
+			return nil, errors.Errorf("Pipeline step '%s' should precede previous steps", step.Step)
+		}
+		if step.Step == "collect" {
+			if collected {
+				return nil, errors.Errorf("Pipeline should have single collect step")
+			}
+			collected = true
+		}
+		stage = order
+	}
+
+	if !collected || config.Pipeline[0].Step != "discover" {
+		return nil, errors.Errorf("Pipeline should start with discover step and have collect step")
+	}
+
+	return config.Pipeline, nil
+}
+
+// runPipeline executes the steps one by one
+func runPipeline(ctx context.Context, opts RunOptions, steps []PipelineStep) (*RunResult, error) {
+	run := &pipelineRun{
+		ctx:       ctx,
+		opts:      opts,
+		startTime: time.Now(),
+		usage:     newUsageRecorder(),
+		sinks:     map[int]map[string]Sink{},
+	}
+	defer run.usage.stop()
+
+	for i := range steps {
+		if steps[i].Step != "sinks" {
+			continue
+		}
+
+		sinks, err := getSinks(steps[i].Sinks)
+		if err != nil {
+			return nil, err
+		}
+		run.sinks[i] = sinks
+	}
+
+	for i := range steps {
+		if err := pipelineSteps[steps[i].Step](run, &steps[i], i); err != nil {
+			return nil, errors.Wrapf(err, "Pipeline step '%s' failed", steps[i].Step)
+		}
+	}
+
+	emitMetrics(run.meta)
+
+	fmt.Printf("\nProcessed %v instance(s) for %v seconds\n", len(run.instances), run.meta.Duration)
+
+	return &RunResult{RunMeta: *run.meta, Rows: run.rows}, nil
+}
+
+// discoverStep finds instances limited by run options
+func discoverStep(run *pipelineRun, step *PipelineStep, index int) error {
+	if len(run.opts.InstanceIDs) == 0 {
+		run.opts.InstanceIDs = getInstanceIDs()
+	}
+
+	if hosts := getHosts(run.opts); len(hosts) > 0 {
+		return run.discoverHosts(hosts)
+	}
+
+	instances, accountErrors, stale, err := findInstances(run.opts)
+	if err != nil {
+		return err
+	}
+	run.discovered = len(instances)
+	run.accountErrors = accountErrors
+	run.staleDiscovery = stale
+
+	reportAddresses, err := addressReportEnabled()
+	if err != nil {
+		return err
+	}
+	if reportAddresses {
+		run.addressReport = buildAddressReport(instances)
+	}
+
+	run.filters.Discovery = discoveryNames()
+	run.filters.Regions = getRegions(aws.StringValue(awsSession().Config.Region))
+	run.filters.InstanceIDs = append(run.filters.InstanceIDs, run.opts.InstanceIDs...)
+	run.addTagFilters(run.opts.Tags)
+
+	// TAG_FILTERS are applied by ec2 source, they are echoed here
+	if value := getEnv("TAG_FILTERS", ""); value != "" {
+		envTags := map[string]string{}
+		if err := json.Unmarshal([]byte(value), &envTags); err != nil {
+			return errors.Wrap(err, "Can't parse TAG_FILTERS")
+		}
+		run.addTagFilters(envTags)
+	}
+
+	if len(run.opts.Tags) > 0 {
+		instances = filterTags(instances, run.opts.Tags)
+	}
+
+	if text := run.selector(); text != "" {
+		sel, err := parseSelector(text)
+		if err != nil {
+			return errors.Wrap(err, "Can't parse selector")
+		}
+		instances = filterSelector(instances, sel)
+		run.filters.Selector = text
+	}
+
+	if value := getEnv("EXCLUDE_TAGS", ""); value != "" {
+		excluded := map[string]string{}
+		if err := json.Unmarshal([]byte(value), &excluded); err != nil {
+			return errors.Wrap(err, "Can't parse EXCLUDE_TAGS")
+		}
+
+		var skipped int
+		instances, skipped = excludeTags(instances, excluded)
+		run.skipped = &skipped
+	}
+
+	include, exclude, err := getNamePatterns()
+	if err != nil {
+		return err
+	}
+	if include != nil || exclude != nil {
+		var skipped int
+		instances, skipped = filterNames(instances, include, exclude)
+		if run.skipped != nil {
+			skipped += *run.skipped
+		}
+		run.skipped = &skipped
+	}
+
+	minAge, maxAge, err := getAgeLimits()
+	if err != nil {
+		return err
+	}
+	if minAge > 0 || maxAge > 0 {
+		var skipped int
+		instances, skipped = filterAge(instances, minAge, maxAge, run.startTime)
+		if run.skipped != nil {
+			skipped += *run.skipped
+		}
+		run.skipped = &skipped
+	}
+
+	lifecycles, err := getLifecycles()
+	if err != nil {
+		return err
+	}
+	if len(lifecycles) > 0 {
+		var skipped int
+		instances, skipped = filterLifecycle(instances, lifecycles)
+		if run.skipped != nil {
+			skipped += *run.skipped
+		}
+		run.skipped = &skipped
+	}
+
+	maxInstances, sample, err := getSampling()
+	if err != nil {
+		return err
+	}
+	if maxInstances > 0 {
+		var unsampled int
+		instances, unsampled = sampleInstances(instances, maxInstances, sample)
+		run.unsampled = &unsampled
+	}
+
+	run.instances = instances
+
+	return nil
+}
+
+// selector returns the target expression of the run or SELECTOR
+func (run *pipelineRun) selector() string {
+	if run.opts.Selector != "" {
+		return run.opts.Selector
+	}
+
+	return getEnv("SELECTOR", "")
+}
+
+// discoverHosts uses static hosts instead of discovery sources
+func (run *pipelineRun) discoverHosts(hosts []string) error {
+	instances, err := (&staticHosts{hosts: hosts}).Discover()
+	if err != nil {
+		return err
+	}
+	for _, inst := range instances {
+		inst.source = hostsSource
+	}
+	run.discovered = len(instances)
+
+	run.filters.Discovery = []string{hostsSource}
+	if len(run.opts.InstanceIDs) > 0 {
+		run.filters.InstanceIDs = append(run.filters.InstanceIDs, run.opts.InstanceIDs...)
+		instances = filterInstanceIDs(instances, run.opts.InstanceIDs)
+	}
+
+	run.instances = instances
+
+	return nil
+}
+
+// getInstanceIDs returns instances listed in INSTANCE_IDS
+func getInstanceIDs() []string {
+	ids := []string{}
+	for _, id := range strings.Split(getEnv("INSTANCE_IDS", ""), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// filterStep keeps instances matching all the tags and ids of the step
+func filterStep(run *pipelineRun, step *PipelineStep, index int) error {
+	run.filters.InstanceIDs = append(run.filters.InstanceIDs, step.InstanceIDs...)
+	run.addTagFilters(step.Tags)
+
+	if len(step.Tags) > 0 {
+		run.instances = filterTags(run.instances, step.Tags)
+	}
+	if len(step.InstanceIDs) > 0 {
+		run.instances = filterInstanceIDs(run.instances, step.InstanceIDs)
+	}
+
+	return nil
+}
+
+// collectStep connects to instances and runs facts commands. Facts of the run
+// options win over facts of the step, FACTS are used if neither is given.
+func collectStep(run *pipelineRun, step *PipelineStep, index int) error {
+	sshAuths, err := sshAuthSetup()
+	if err != nil {
+		return err
+	}
+
+	factsToCollect := map[string]string{}
+	switch {
+	case run.opts.Facts != nil:
+		factsToCollect = run.opts.Facts
+	case step.Facts != nil:
+		factsToCollect = step.Facts
+	default:
+		if err := json.Unmarshal([]byte(getEnv("FACTS", defaultFacts)), &factsToCollect); err != nil {
+			return err
+		}
+	}
+
+	collectorNames := step.Collectors
+	if collectorNames == nil {
+		collectorNames = strings.Split(getEnv("COLLECTORS", ""), ",")
+	}
+	enabledCollectors, err := getCollectors(collectorNames)
+	if err != nil {
+		return err
+	}
+	factOptions, err := getFactOptions()
+	if err != nil {
+		return err
+	}
+	commands := applyFactOptions(collectorCommands(factsToCollect, enabledCollectors), factOptions)
+
+	rollout, err := getCandidateRollout()
+	if err != nil {
+		return err
+	}
+
+	readOnly, err := strictReadOnly()
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		if err := checkReadOnly(commands); err != nil {
+			return err
+		}
+		if rollout != nil {
+			if err := checkReadOnly(rollout.facts); err != nil {
+				return err
+			}
+		}
+	}
+	if rollout != nil {
+		rollout.assign(run.instances)
+	}
+
+	immutableFacts := getImmutableFacts()
+	if len(immutableFacts) > 0 {
+		applyFactCache(onlineInstances(run.instances), immutableFacts, commands)
+	}
+
+	maxSessions, _ := strconv.Atoi(getEnv("MAX_SESSIONS", defaultMaxSessions))
+	maxAttempts, _ := strconv.Atoi(getEnv("MAX_ATTEMPTS", defaultMaxAttempts))
+	maxCommands, _ := strconv.Atoi(getEnv("MAX_COMMANDS", defaultMaxCommands()))
+	maxConnections, _ := strconv.Atoi(getEnv("MAX_CONNECTIONS", defaultMaxConnections))
+	maxDialAttempts, _ := strconv.Atoi(getEnv("MAX_DIAL_ATTEMPTS", defaultMaxDialAttempts))
+	runner := newSSHRunner(sshAuths, maxConnections, maxCommands, maxDialAttempts)
+	runner.usage = run.usage
+	if runner.addressFamily, err = getAddressFamily(); err != nil {
+		return err
+	}
+	if runner.dialNamer, err = getDialNamer(); err != nil {
+		return err
+	}
+	if runner.resolver, err = getDNSResolver(); err != nil {
+		return err
+	}
+	if runner.relay, err = newRelayDialer(sshAuths); err != nil {
+		return err
+	}
+	if runner.relay != nil {
+		defer runner.relay.close()
+	}
+	runner.readOnly = readOnly
+	if runner.verboseLog, err = verboseAttemptLog(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Collecting facts (%v) for %v instances(s)...\n", factsToCollect, len(run.instances))
+
+	warmup, _ := strconv.Atoi(getEnv("PENDING_WARMUP", defaultPendingWarmup))
+	ready, pending := splitPending(onlineInstances(run.instances), time.Duration(warmup)*time.Second)
+
+	dispatch(run.ctx, ready, maxSessions, commands, enabledCollectors, runner)
+
+	collectPending(run.ctx, pending, run.startTime, time.Duration(warmup)*time.Second, func(batch []*InstanceInfo) {
+		dispatch(run.ctx, batch, maxSessions, commands, enabledCollectors, runner)
+	})
+
+	retryFailed(run.ctx, run.instances, maxAttempts, maxSessions, func(batch []*InstanceInfo) {
+		dispatch(run.ctx, batch, maxSessions, commands, enabledCollectors, runner)
+	})
+
+	if len(immutableFacts) > 0 {
+		updateFactCache(run.instances, immutableFacts)
+	}
+
+	cancelled := errors.Cause(run.ctx.Err()) == context.Canceled
+	if !cancelled {
+		recheckStates(run.instances)
+	}
+
+	requestID := ""
+	if lc, ok := lambdacontext.FromContext(run.ctx); ok {
+		requestID = lc.AwsRequestID
+	}
+
+	labels, err := runLabels(run.opts)
+	if err != nil {
+		return err
+	}
+
+	run.meta = &RunMeta{
+		RunID:          newRunID(run.startTime, requestID),
+		StartedAt:      run.startTime.UTC(),
+		CompletedAt:    time.Now().UTC(),
+		Labels:         labels,
+		AccountErrors:  run.accountErrors,
+		StaleDiscovery: run.staleDiscovery,
+		AddressReport:  run.addressReport,
+		JobID:          run.opts.JobID,
+		Cancelled:      cancelled,
+		ReadOnly:       readOnly,
+		Duration:       time.Since(run.startTime).Seconds(),
+		Summary:        summarize(run.instances, enabledCollectors),
+		DialLatency:    runner.dialLatency.Histogram(),
+		Usage:          run.usage.Usage(),
+	}
+	if run.skipped != nil {
+		run.meta.Summary["skipped"] = *run.skipped
+	}
+	if run.unsampled != nil {
+		run.meta.Summary["unsampled"] = *run.unsampled
+	}
+	run.rows = formatResult(run.instances, factsToCollect)
+
+	if len(run.instances) == 0 {
+		run.meta.Filters = &run.filters
+		run.meta.Hint = "Discovery found no instances, check DISCOVERY and REGIONS settings"
+		if run.discovered > 0 {
+			run.meta.Hint = fmt.Sprintf("%d instance(s) discovered, none matched the filters", run.discovered)
+		}
+	}
+
+	return nil
+}
+
+func (run *pipelineRun) addTagFilters(tags map[string]string) {
+	if len(tags) > 0 && run.filters.Tags == nil {
+		run.filters.Tags = map[string]string{}
+	}
+	for k, v := range tags {
+		run.filters.Tags[k] = v
+	}
+}
+
+// transformStep renames, drops and extracts parts of collected facts.
+// Extract replaces the fact with the first group matched by the pattern
+// or with the whole match if the pattern has no groups.
+func transformStep(run *pipelineRun, step *PipelineStep, index int) error {
+	extract := map[string]*regexp.Regexp{}
+	for label, pattern := range step.Extract {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid extract pattern of '%s' fact", label)
+		}
+		extract[label] = re
+	}
+
+	for _, row := range run.rows {
+		for label, re := range extract {
+			value, ok := row.Facts[label]
+			if !ok {
+				continue
+			}
+
+			match := re.FindStringSubmatch(value)
+			switch {
+			case match == nil:
+				row.Facts[label] = ""
+			case len(match) > 1:
+				row.Facts[label] = match[1]
+			default:
+				row.Facts[label] = match[0]
+			}
+		}
+
+		for _, label := range step.Drop {
+			delete(row.Facts, label)
+		}
+
+		for from, to := range step.Rename {
+			if value, ok := row.Facts[from]; ok {
+				delete(row.Facts, from)
+				row.Facts[to] = value
+			}
+		}
+	}
+
+	return nil
+}
+
+// rulesStep evaluates compliance rules against collected facts
+func rulesStep(run *pipelineRun, step *PipelineStep, index int) error {
+	return evaluateRules(step.Rules, run.meta, run.rows)
+}
+
+// sinksStep delivers results to the sinks of the step or to SINKS
+func sinksStep(run *pipelineRun, step *PipelineStep, index int) error {
+	run.meta.Duration = time.Since(run.startTime).Seconds()
+	writeSinks(run.sinks[index], run.meta, run.rows)
+
+	return nil
+}
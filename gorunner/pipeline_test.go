@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetPipeline(t *testing.T) {
+	tests := []struct {
+		steps string
+		err   string
+	}{
+		{"", ""},
+		{"discover, collect, sinks", ""},
+		{"discover, filter, collect, transform, rules, sinks", ""},
+		{"discover, collect, rules, transform, sinks", ""},
+		{"discover, collect, filter", "should precede previous steps"},
+		{"discover, collect, sinks, transform", "should precede previous steps"},
+		{"discover, collect, collect", "single collect step"},
+		{"collect, sinks", "should start with discover step"},
+		{"discover, sinks", "have collect step"},
+		{"discover, collect, exec", "Unknown pipeline step: 'exec'"},
+	}
+
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yml")
+	os.Setenv("CONFIG_FILE", path)
+	defer os.Unsetenv("CONFIG_FILE")
+
+	for _, tt := range tests {
+		config := "pipeline:\n"
+		for _, step := range strings.Split(tt.steps, ",") {
+			if step = strings.TrimSpace(step); step != "" {
+				config += "  - step: " + step + "\n"
+			}
+		}
+		if tt.steps == "" {
+			config = ""
+		}
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		steps, err := getPipeline()
+		if tt.err == "" && err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.steps, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%q: got error %v, want %q", tt.steps, err, tt.err)
+		}
+		if tt.steps == "" && len(steps) != len(defaultPipeline) {
+			t.Errorf("empty config: got %v steps, want the default pipeline", steps)
+		}
+	}
+}
+
+func TestTransformStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    PipelineStep
+		facts   map[string]string
+		want    map[string]string
+		invalid bool
+	}{
+		{
+			name:  "extract first group",
+			step:  PipelineStep{Extract: map[string]string{"kernel": `Linux (\d+\.\d+)`}},
+			facts: map[string]string{"kernel": "Linux 4.14.123"},
+			want:  map[string]string{"kernel": "4.14"},
+		},
+		{
+			name:  "extract only first of several groups",
+			step:  PipelineStep{Extract: map[string]string{"kernel": `(\d+)\.(\d+)`}},
+			facts: map[string]string{"kernel": "Linux 4.14.123"},
+			want:  map[string]string{"kernel": "4"},
+		},
+		{
+			name:  "extract whole match without groups",
+			step:  PipelineStep{Extract: map[string]string{"kernel": `\d+\.\d+`}},
+			facts: map[string]string{"kernel": "Linux 4.14.123"},
+			want:  map[string]string{"kernel": "4.14"},
+		},
+		{
+			name:  "extract without match",
+			step:  PipelineStep{Extract: map[string]string{"kernel": `FreeBSD`}},
+			facts: map[string]string{"kernel": "Linux 4.14.123"},
+			want:  map[string]string{"kernel": ""},
+		},
+		{
+			name:  "extract before drop and rename",
+			step:  PipelineStep{Extract: map[string]string{"kernel": `Linux (\S+)`}, Drop: []string{"host"}, Rename: map[string]string{"kernel": "version"}},
+			facts: map[string]string{"kernel": "Linux 4.14", "host": "web-1"},
+			want:  map[string]string{"version": "4.14"},
+		},
+		{
+			name:    "invalid pattern",
+			step:    PipelineStep{Extract: map[string]string{"kernel": `(`}},
+			facts:   map[string]string{"kernel": "Linux"},
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		run := &pipelineRun{rows: []ResRow{{InstanceId: "i-1", Facts: tt.facts}}}
+
+		err := transformStep(run, &tt.step, 0)
+		if (err != nil) != tt.invalid {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if tt.invalid {
+			continue
+		}
+
+		got := run.rows[0].Facts
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		for label, value := range tt.want {
+			if got[label] != value {
+				t.Errorf("%s: %s is %q, want %q", tt.name, label, got[label], value)
+			}
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxNestedScripts caps the depth of substitutions and sh -c scripts being checked
+const maxNestedScripts = 8
+
+// shellKeywords precede commands without changing them
+var shellKeywords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "while": true, "until": true,
+	"do": true, "!": true, "{": true, "}": true, "fi": true, "done": true, "esac": true,
+}
+
+// shellAssignment matches variable assignments preceding commands
+var shellAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// wrapperOptions lists options taking an argument of commands running other
+// commands, the wrapped command follows the options
+var wrapperOptions = map[string][]string{
+	"sudo":     {"-u", "-g", "-C", "-h", "-p", "-U", "-r", "-t", "-D", "-R", "-T", "--user", "--group", "--close-from", "--host", "--prompt", "--other-user", "--role", "--type", "--chdir", "--chroot", "--command-timeout"},
+	"doas":     {"-u", "-C"},
+	"env":      {"-u", "-C", "--unset", "--chdir"},
+	"timeout":  {"-s", "-k", "--signal", "--kill-after"},
+	"nice":     {"-n", "--adjustment"},
+	"ionice":   {"-c", "-n", "-p", "-P", "-u", "--class", "--classdata"},
+	"stdbuf":   {"-i", "-o", "-e", "--input", "--output", "--error"},
+	"xargs":    {"-I", "-n", "-P", "-d", "-L", "-s", "-E", "-a", "--max-args", "--max-procs", "--delimiter", "--max-lines", "--max-chars", "--arg-file", "--eof"},
+	"watch":    {"-n", "--interval"},
+	"flock":    {"-w", "-E", "--timeout", "--conflict-exit-code"},
+	"runuser":  {"-u", "-g", "-G", "--user", "--group", "--supp-group"},
+	"time":     {"-f", "-o", "--format", "--output"},
+	"nohup":    nil,
+	"exec":     {"-a"},
+	"builtin":  nil,
+	"setsid":   nil,
+	"unbuffer": nil,
+	"chroot":   nil,
+	"chrt":     nil,
+	"taskset":  nil,
+}
+
+// wrapperPositionals is the number of arguments of wrappers preceding the wrapped command
+var wrapperPositionals = map[string]int{"timeout": 1, "chroot": 1, "flock": 1, "chrt": 1, "taskset": 1}
+
+var (
+	fileChangeCommands = map[string]bool{
+		"rm": true, "rmdir": true, "mv": true, "cp": true, "dd": true, "touch": true, "mkdir": true,
+		"ln": true, "install": true, "truncate": true, "shred": true, "chmod": true, "chown": true,
+		"chgrp": true, "chattr": true, "setfacl": true, "umount": true, "unlink": true, "rsync": true,
+		"scp": true, "mknod": true, "mkfifo": true, "patch": true, "sponge": true, "sudoedit": true,
+	}
+	processCommands = map[string]bool{
+		"kill": true, "pkill": true, "killall": true, "reboot": true, "shutdown": true, "halt": true,
+		"poweroff": true, "init": true, "telinit": true,
+	}
+	accountCommands = map[string]bool{
+		"useradd": true, "userdel": true, "usermod": true, "groupadd": true, "groupdel": true, "groupmod": true,
+		"passwd": true, "chpasswd": true, "gpasswd": true, "chsh": true, "chfn": true, "vipw": true, "visudo": true,
+	}
+	systemCommands = map[string]bool{
+		"iptables-restore": true, "ip6tables-restore": true, "modprobe": true, "insmod": true, "rmmod": true,
+		"swapon": true, "swapoff": true,
+	}
+	packageCommands = map[string]bool{
+		"apt": true, "apt-get": true, "yum": true, "dnf": true, "zypper": true, "apk": true, "pip": true,
+		"pip3": true, "npm": true, "gem": true, "snap": true,
+	}
+	packageActions = map[string]bool{
+		"install": true, "reinstall": true, "remove": true, "purge": true, "erase": true, "upgrade": true,
+		"update": true, "downgrade": true, "add": true, "del": true, "uninstall": true, "autoremove": true,
+	}
+	serviceActions = map[string]bool{
+		"start": true, "stop": true, "restart": true, "reload": true, "try-restart": true, "reload-or-restart": true,
+		"force-reload": true, "enable": true, "disable": true, "mask": true, "unmask": true, "kill": true,
+		"daemon-reload": true, "isolate": true, "set-property": true, "edit": true,
+	}
+	containerActions = map[string]bool{
+		"run": true, "rm": true, "rmi": true, "stop": true, "kill": true, "exec": true, "start": true,
+		"restart": true, "pull": true, "create": true, "prune": true, "cp": true, "commit": true,
+	}
+	networkActions = map[string]bool{
+		"add": true, "del": true, "delete": true, "set": true, "flush": true, "replace": true, "change": true, "append": true,
+	}
+	gitActions = map[string]bool{
+		"clean": true, "reset": true, "checkout": true, "switch": true, "restore": true, "pull": true, "push": true,
+		"commit": true, "merge": true, "rebase": true, "init": true, "clone": true, "add": true, "rm": true,
+		"mv": true, "apply": true, "am": true, "fetch": true, "gc": true, "prune": true, "cherry-pick": true,
+		"revert": true,
+	}
+)
+
+// options of commands changing the host, flags without values could precede
+// the in-place flag of sed and perl
+var (
+	inPlaceOptions = map[string]*regexp.Regexp{
+		"sed":  regexp.MustCompile(`^-[nrsuzE]*i`),
+		"perl": regexp.MustCompile(`^-[pnlawWsTtcU0]*i`),
+	}
+	rpmChangeOption      = regexp.MustCompile(`^(-[iUeF]|--(install|upgrade|erase|freshen|import))`)
+	dpkgChangeOption     = regexp.MustCompile(`^(-[irP]|--(install|remove|purge|configure|unpack))`)
+	crontabChangeOption  = regexp.MustCompile(`^-\w*[eri]`)
+	sysctlChangeOption   = regexp.MustCompile(`^(-\w*[wp]|--(write|load|system))`)
+	iptablesChangeOption = regexp.MustCompile(`^(-[ADIRFXNPZE]|--(append|delete|insert|replace|flush|delete-chain|new-chain|policy|zero|rename-chain))`)
+	gitRefChangeOption   = regexp.MustCompile(`^(-[dDmMcCf]|--(delete|move|copy|force))`)
+)
+
+// strictReadOnly tells if STRICT_READONLY is set
+func strictReadOnly() (bool, error) {
+	value := getEnv("STRICT_READONLY", "false")
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Errorf("Invalid STRICT_READONLY: '%s'", value)
+	}
+
+	return enabled, nil
+}
+
+// checkReadOnly returns an error naming the first command which could mutate
+// the host. Commands are split like the shell does it and verbs are matched
+// by their base name once wrappers (sudo, env, timeout, xargs, find -exec,
+// sh -c, ...) are stripped. The check is best effort: scripts stored on the
+// host and interpreters like awk or python are not inspected.
+func checkReadOnly(commands map[string]string) error {
+	labels := make([]string, 0, len(commands))
+	for label := range commands {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		if match, name := checkScript(commands[label], 0); match != "" {
+			return errors.Errorf("Command of '%s' fact is rejected by STRICT_READONLY: %s (%s)", label, match, name)
+		}
+	}
+
+	return nil
+}
+
+// checkScript returns the first mutating command of the script and the kind of change
+func checkScript(script string, depth int) (string, string) {
+	if depth > maxNestedScripts {
+		return script, "too deeply nested"
+	}
+
+	parsed := parseShell(script)
+	if len(parsed.redirects) > 0 {
+		return parsed.redirects[0], "output redirect"
+	}
+	for _, nested := range parsed.nested {
+		if match, name := checkScript(nested, depth+1); match != "" {
+			return match, name
+		}
+	}
+	for _, words := range parsed.commands {
+		if match, name := checkCommand(words, depth); match != "" {
+			return match, name
+		}
+	}
+
+	return "", ""
+}
+
+// checkCommand strips keywords, assignments and wrappers and checks the verb
+func checkCommand(words []string, depth int) (string, string) {
+	for len(words) > 0 {
+		if shellKeywords[words[0]] || shellAssignment.MatchString(words[0]) {
+			words = words[1:]
+			continue
+		}
+
+		verb, args := path.Base(words[0]), words[1:]
+		switch verb {
+		case "sh", "bash", "dash", "zsh", "ksh", "ash", "su", "eval":
+			if script := shellScriptArg(verb, args); script != "" {
+				return checkScript(script, depth+1)
+			}
+			return "", ""
+		case "command":
+			if hasOption(args, "-v", "-V") {
+				return "", ""
+			}
+			words = skipOptions(args, nil)
+			continue
+		case "sudo":
+			if hasOption(args, "-e", "--edit") {
+				return joinCommand(verb, args), "file change"
+			}
+		case "env":
+			if script := optionValue(args, "-S", "--split-string"); script != "" {
+				return checkScript(script, depth+1)
+			}
+		case "runuser":
+			if script := optionValue(args, "-c", "--command"); script != "" {
+				return checkScript(script, depth+1)
+			}
+		}
+
+		options, ok := wrapperOptions[verb]
+		if !ok {
+			return checkVerb(verb, args, depth)
+		}
+		words = skipOptions(args, options)
+		if n := wrapperPositionals[verb]; len(words) >= n {
+			words = words[n:]
+		}
+	}
+
+	return "", ""
+}
+
+// checkVerb tells if the command changes the host
+func checkVerb(verb string, args []string, depth int) (string, string) {
+	name := ""
+	switch {
+	case fileChangeCommands[verb] || strings.HasPrefix(verb, "mkfs"):
+		name = "file change"
+	case verb == "tee":
+		for _, arg := range skipOptions(args, nil) {
+			if !harmlessRedirectTarget(">", arg) {
+				name = "file change"
+			}
+		}
+	case verb == "tar":
+		if len(args) > 0 && (strings.ContainsAny(strings.TrimPrefix(args[0], "-"), "xcruA") && !strings.HasPrefix(args[0], "--")) ||
+			hasOption(args, "--extract", "--create", "--append", "--update", "--delete", "--get") {
+			name = "file change"
+		}
+	case verb == "sed" || verb == "perl":
+		if hasOptionPrefix(args, "--in-place") || matchOption(args, inPlaceOptions[verb]) {
+			name = "in-place edit"
+		}
+	case verb == "curl":
+		if curlWritesFile(args) {
+			name = "file change"
+		}
+	case verb == "wget":
+		if !wgetToStdout(args) {
+			name = "file change"
+		}
+	case verb == "find":
+		return checkFind(args, depth)
+	case processCommands[verb]:
+		name = "process control"
+	case verb == "systemctl":
+		if serviceActions[firstArg(args)] {
+			name = "service control"
+		}
+	case verb == "service":
+		if len(args) > 1 && serviceActions[args[1]] {
+			name = "service control"
+		}
+	case packageCommands[verb]:
+		if packageActions[firstArg(args)] {
+			name = "package change"
+		}
+	case verb == "rpm":
+		if matchOption(args, rpmChangeOption) {
+			name = "package change"
+		}
+	case verb == "dpkg":
+		if matchOption(args, dpkgChangeOption) {
+			name = "package change"
+		}
+	case accountCommands[verb]:
+		name = "account change"
+	case verb == "crontab":
+		for _, arg := range skipOptions(args, []string{"-u"}) {
+			if arg != "" {
+				name = "account change"
+			}
+		}
+		if matchOption(args, crontabChangeOption) {
+			name = "account change"
+		}
+	case systemCommands[verb]:
+		name = "system change"
+	case verb == "sysctl":
+		if matchOption(args, sysctlChangeOption) {
+			name = "system change"
+		}
+		for _, arg := range args {
+			if strings.Contains(arg, "=") && !strings.HasPrefix(arg, "-") {
+				name = "system change"
+			}
+		}
+	case verb == "hostnamectl" || verb == "timedatectl" || verb == "localectl":
+		if strings.HasPrefix(firstArg(args), "set-") {
+			name = "system change"
+		}
+	case verb == "iptables" || verb == "ip6tables":
+		if matchOption(args, iptablesChangeOption) {
+			name = "system change"
+		}
+	case verb == "nft" || verb == "ip":
+		for _, arg := range args {
+			if networkActions[arg] || arg == "create" || arg == "insert" {
+				name = "system change"
+			}
+		}
+	case verb == "mount":
+		if firstArg(args) != "" {
+			name = "system change"
+		}
+	case verb == "docker" || verb == "podman":
+		operands := skipOptions(args, []string{"-H", "--host", "--context", "--config", "--log-level"})
+		for i := 0; i < len(operands) && i < 2; i++ {
+			if containerActions[operands[i]] {
+				name = "system change"
+			}
+		}
+	case verb == "git":
+		operands := skipOptions(args, []string{"-C", "-c", "--git-dir", "--work-tree", "--namespace"})
+		switch {
+		case len(operands) == 0:
+		case gitActions[operands[0]]:
+			name = "repository change"
+		case operands[0] == "stash" && (len(operands) == 1 || (operands[1] != "list" && operands[1] != "show")):
+			name = "repository change"
+		case (operands[0] == "branch" || operands[0] == "tag") && matchOption(operands[1:], gitRefChangeOption):
+			name = "repository change"
+		}
+	}
+
+	if name == "" {
+		return "", ""
+	}
+
+	return joinCommand(verb, args), name
+}
+
+// checkFind rejects actions deleting or writing files and checks commands of -exec
+func checkFind(args []string, depth int) (string, string) {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-delete", "-fprint", "-fprint0", "-fprintf", "-fls":
+			return joinCommand("find", args), "file change"
+		case "-exec", "-execdir", "-ok", "-okdir":
+			end := i + 1
+			for end < len(args) && args[end] != ";" && args[end] != "+" {
+				end++
+			}
+			if match, name := checkCommand(args[i+1:end], depth+1); match != "" {
+				return match, name
+			}
+			i = end
+		}
+	}
+
+	return "", ""
+}
+
+// curlWritesFile tells if curl saves responses, headers or cookies to files
+func curlWritesFile(args []string) bool {
+	for i, arg := range args {
+		switch {
+		case arg == "--output" || arg == "--remote-name" || arg == "--remote-name-all" || arg == "--cookie-jar" || arg == "--upload-file":
+			return true
+		case strings.HasPrefix(arg, "--output=") || strings.HasPrefix(arg, "--cookie-jar="):
+			return true
+		case arg == "--dump-header" || arg == "-D":
+			if i+1 < len(args) && args[i+1] != "-" {
+				return true
+			}
+		case isShortOptions(arg) && strings.ContainsAny(arg, "oOcT"):
+			return true
+		}
+	}
+
+	return false
+}
+
+// wgetToStdout tells if wget prints the document instead of saving it
+func wgetToStdout(args []string) bool {
+	for i, arg := range args {
+		switch {
+		case arg == "--spider" || arg == "--output-document=-":
+			return true
+		case isShortOptions(arg) && strings.Contains(arg, "O"):
+			value := arg[strings.Index(arg, "O")+1:]
+			if value == "" && i+1 < len(args) {
+				value = args[i+1]
+			}
+			return value == "-"
+		}
+	}
+
+	return false
+}
+
+// shellScriptArg returns the script run by the shell with -c or by eval
+func shellScriptArg(verb string, args []string) string {
+	if verb == "eval" {
+		return strings.Join(args, " ")
+	}
+	if verb == "su" {
+		return optionValue(args, "-c", "--command")
+	}
+
+	for i, arg := range args {
+		if isShortOptions(arg) && strings.Contains(arg, "c") && i+1 < len(args) {
+			return args[i+1]
+		}
+		if !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "+") {
+			break
+		}
+	}
+
+	return ""
+}
+
+// skipOptions returns operands following the options, options listed
+// in withValue are followed by their values
+func skipOptions(args []string, withValue []string) []string {
+	i := 0
+	for i < len(args) {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			break
+		}
+		i++
+		for _, option := range withValue {
+			if arg == option {
+				i++
+				break
+			}
+		}
+	}
+
+	if i > len(args) {
+		return nil
+	}
+
+	return args[i:]
+}
+
+// optionValue returns the value of the option given as a separate or attached argument
+func optionValue(args []string, short, long string) string {
+	for i, arg := range args {
+		switch {
+		case (arg == short || arg == long) && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(arg, long+"="):
+			return strings.TrimPrefix(arg, long+"=")
+		}
+	}
+
+	return ""
+}
+
+func hasOption(args []string, options ...string) bool {
+	for _, arg := range args {
+		for _, option := range options {
+			if arg == option {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasOptionPrefix(args []string, prefix string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchOption(args []string, re *regexp.Regexp) bool {
+	for _, arg := range args {
+		if re.MatchString(arg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// firstArg returns the first argument which isn't an option
+func firstArg(args []string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+
+	return ""
+}
+
+// isShortOptions tells if the argument is a cluster of short options like -sSL
+func isShortOptions(arg string) bool {
+	return len(arg) > 1 && arg[0] == '-' && arg[1] != '-'
+}
+
+func joinCommand(verb string, args []string) string {
+	return strings.Join(append([]string{verb}, args...), " ")
+}
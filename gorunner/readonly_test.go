@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheckReadOnlyRejects(t *testing.T) {
+	tests := []struct {
+		command string
+		kind    string
+	}{
+		{"rm -rf /tmp/x", "file change"},
+		{"/bin/rm x", "file change"},
+		{`\rm x`, "file change"},
+		{"'rm' x", "file change"},
+		{"sudo rm x", "file change"},
+		{"sudo -n -u app rm x", "file change"},
+		{"sudo --user app rm x", "file change"},
+		{"sudo -e /etc/hosts", "file change"},
+		{"env rm -f /x", "file change"},
+		{"env -i PATH=/bin rm -f /x", "file change"},
+		{"LANG=C rm x", "file change"},
+		{"timeout 5 rm x", "file change"},
+		{"timeout -s KILL 5 rm x", "file change"},
+		{"nohup rm x", "file change"},
+		{"command rm x", "file change"},
+		{"nice -n 10 rm x", "file change"},
+		{"ls | xargs rm", "file change"},
+		{"ls | xargs -I{} -P 4 rm {}", "file change"},
+		{"find /tmp -exec rm {} +", "file change"},
+		{`find /tmp -name '*.log' -exec rm -f {} \;`, "file change"},
+		{"find /tmp -mtime +7 -delete", "file change"},
+		{"sh -c 'rm x'", "file change"},
+		{`bash -lc "rm x"`, "file change"},
+		{`su -c "rm x" app`, "file change"},
+		{"eval rm x", "file change"},
+		{"echo $(rm x)", "file change"},
+		{`echo "$(rm x)"`, "file change"},
+		{"echo `rm x`", "file change"},
+		{"if true; then rm x; fi", "file change"},
+		{"for f in a b; do rm $f; done", "file change"},
+		{"{\nrm x\n}", "file change"},
+		{"tar -xzf a.tgz", "file change"},
+		{"curl -o /tmp/x https://example.com", "file change"},
+		{"curl -sSLo /tmp/x https://example.com", "file change"},
+		{"curl --output=/tmp/x https://example.com", "file change"},
+		{"wget https://example.com/x", "file change"},
+		{"tee /etc/motd", "file change"},
+		{"sed -i s/a/b/ /etc/hosts", "in-place edit"},
+		{"sed -ni s/a/b/p /etc/hosts", "in-place edit"},
+		{"perl -pi -e s/a/b/ /etc/hosts", "in-place edit"},
+		{"echo x > /tmp/x", "output redirect"},
+		{"echo x>>/tmp/x", "output redirect"},
+		{"cat a &> /tmp/x", "output redirect"},
+		{"then reboot;", "process control"},
+		{"kill -9 1", "process control"},
+		{"sudo systemctl --no-block restart sshd", "service control"},
+		{"service sshd stop", "service control"},
+		{"yum -y install htop", "package change"},
+		{"rpm -e htop", "package change"},
+		{"crontab -r", "account change"},
+		{"crontab /tmp/jobs", "account change"},
+		{"useradd bob", "account change"},
+		{"sysctl -w vm.swappiness=1", "system change"},
+		{"hostnamectl set-hostname x", "system change"},
+		{"iptables -F", "system change"},
+		{"ip link set eth0 down", "system change"},
+		{"docker run alpine", "system change"},
+		{"git clean -fdx", "repository change"},
+		{"git -C /srv/app reset --hard", "repository change"},
+	}
+
+	for _, test := range tests {
+		match, kind := checkScript(test.command, 0)
+		if kind != test.kind {
+			t.Errorf("%q: got %q (%s), want %s", test.command, match, kind, test.kind)
+		}
+	}
+}
+
+func TestCheckReadOnlyAccepts(t *testing.T) {
+	commands := []string{
+		"awk '$1 > 5' /proc/loadavg",
+		`awk "{ if (\$1 > 5) print }" /proc/loadavg`,
+		"getent passwd",
+		"grep rm /etc/passwd",
+		"rpm -qi bash",
+		"dpkg -l",
+		"crontab -l -u root",
+		"systemctl status sshd",
+		"echo x 2>/dev/null",
+		"cmd 2>&1 | head",
+		"cmd >&2",
+		"ls >/dev/null 2>&1",
+		"command -v rm",
+		"git status --short",
+		"git stash list",
+		"curl -s https://example.com",
+		"curl -D - -s https://example.com",
+		"wget -qO- https://example.com",
+		"find /etc -name '*.conf' -exec cat {} +",
+		"sudo -n cat /etc/shadow",
+		"ip addr show",
+		"docker ps -a",
+		"sysctl -a",
+		"mount",
+		"tee",
+		"sed -n 's/a/b/p' /etc/hosts",
+		"cat <<EOF\nrm is only text\nEOF\nuname -r",
+		"echo 'rm x; reboot'",
+		"echo $((1 + 2))",
+		"# rm x\nuname -r",
+		"[ -r \"$f\" ] && cat \"$f\"",
+	}
+
+	for _, command := range commands {
+		if match, kind := checkScript(command, 0); match != "" {
+			t.Errorf("%q: rejected %q (%s)", command, match, kind)
+		}
+	}
+}
+
+// TestCheckReadOnlyCollectors makes sure built-in collectors pass the check
+func TestCheckReadOnlyCollectors(t *testing.T) {
+	os.Setenv("SYSTEMD_UNITS", "sshd")
+	defer os.Unsetenv("SYSTEMD_UNITS")
+
+	for name, newCollector := range collectors {
+		c, err := newCollector()
+		if err != nil {
+			continue
+		}
+		if err := checkReadOnly(map[string]string{collectorPrefix + name: c.Command()}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
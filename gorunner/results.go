@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultResponseMaxBytes stays below 6MB Lambda response payload limit
+	defaultResponseMaxBytes = "5000000"
+	defaultResultURLExpiry  = "900"
+
+	// anonymousCaller is reported for requests without identity, results aren't presigned for it
+	anonymousCaller = "anonymous"
+)
+
+// ResultPointer is returned instead of results too large to be delivered inline
+type ResultPointer struct {
+	RunMeta
+	ResultURL        string
+	ResultURLExpires time.Time
+}
+
+// resultResponse returns the run result or a pointer to it stored in HISTORY_BUCKET
+// when the result exceeds RESPONSE_MAX_BYTES
+func resultResponse(body interface{}, meta *RunMeta, caller string) (Response, error) {
+	response, err := jsonResponse(200, body)
+	if err != nil || !historyEnabled() || len(response.Body) <= responseMaxBytes() {
+		return response, err
+	}
+
+	pointer, err := storeResult([]byte(response.Body), meta, caller)
+	if err != nil && caller == anonymousCaller {
+		return errorResponse(413, err)
+	}
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	return jsonResponse(200, pointer)
+}
+
+func responseMaxBytes() int {
+	maxBytes, _ := strconv.Atoi(getEnv("RESPONSE_MAX_BYTES", defaultResponseMaxBytes))
+
+	return maxBytes
+}
+
+// storeResult uploads serialized result and presigns GET URL for it. The URL
+// is a bearer token: anyone holding it reads the result until it expires,
+// so it's never given to anonymous callers.
+func storeResult(body []byte, meta *RunMeta, caller string) (*ResultPointer, error) {
+	if caller == anonymousCaller {
+		return nil, errors.Errorf("Result of run %s exceeds RESPONSE_MAX_BYTES and can't be shared with anonymous caller", meta.RunID)
+	}
+
+	expiry, err := strconv.Atoi(getEnv("RESULT_URL_EXPIRY", defaultResultURLExpiry))
+	if err != nil || expiry <= 0 {
+		return nil, errors.Errorf("Invalid RESULT_URL_EXPIRY: '%s'", getEnv("RESULT_URL_EXPIRY", ""))
+	}
+
+	bucket := getEnv("HISTORY_BUCKET", "")
+	key := resultKey(meta.RunID, caller)
+	svc := s3.New(awsSession())
+
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't store result of run %s in s3://%s/%s", meta.RunID, bucket, key)
+	}
+
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	url, err := req.Presign(time.Duration(expiry) * time.Second)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't presign result URL of run %s", meta.RunID)
+	}
+
+	return &ResultPointer{
+		RunMeta:          *meta,
+		ResultURL:        url,
+		ResultURLExpires: time.Now().Add(time.Duration(expiry) * time.Second).UTC(),
+	}, nil
+}
+
+// resultKey shares run id based naming with history, so results follow its retention.
+// The caller hash only groups results, it doesn't restrict access to them.
+func resultKey(runID, caller string) string {
+	sum := sha256.Sum256([]byte(caller))
+
+	return getEnv("HISTORY_PREFIX", defaultHistoryPrefix) + "results/" + hex.EncodeToString(sum[:8]) + "/" + runID + ".json"
+}
+
+// apiCaller identifies the API caller by IAM identity, API key or source address
+func apiCaller(request events.APIGatewayProxyRequest) string {
+	identity := request.RequestContext.Identity
+	for _, caller := range []string{identity.UserArn, identity.APIKeyID, identity.SourceIP} {
+		if caller != "" {
+			return caller
+		}
+	}
+
+	return anonymousCaller
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestAPICaller(t *testing.T) {
+	tests := []struct {
+		identity events.APIGatewayRequestIdentity
+		caller   string
+	}{
+		{events.APIGatewayRequestIdentity{UserArn: "arn:aws:iam::1:user/a", APIKeyID: "key", SourceIP: "1.1.1.1"}, "arn:aws:iam::1:user/a"},
+		{events.APIGatewayRequestIdentity{APIKeyID: "key", SourceIP: "1.1.1.1"}, "key"},
+		{events.APIGatewayRequestIdentity{SourceIP: "1.1.1.1"}, "1.1.1.1"},
+		{events.APIGatewayRequestIdentity{}, anonymousCaller},
+	}
+
+	for _, test := range tests {
+		request := events.APIGatewayProxyRequest{}
+		request.RequestContext.Identity = test.identity
+		if caller := apiCaller(request); caller != test.caller {
+			t.Errorf("got %q, want %q", caller, test.caller)
+		}
+	}
+}
+
+func TestResultResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		bucket   string
+		maxBytes string
+		status   int
+		body     string
+	}{
+		{"small result", "history", "100", 200, `[{"InstanceId":"i-1"}]`},
+		{"history disabled", "", "1", 200, `[{"InstanceId":"i-1"}]`},
+		{"oversized result", "history", "1", 413, "can't be shared with anonymous caller"},
+	}
+
+	defer os.Unsetenv("HISTORY_BUCKET")
+	defer os.Unsetenv("RESPONSE_MAX_BYTES")
+
+	for _, tt := range tests {
+		os.Setenv("HISTORY_BUCKET", tt.bucket)
+		os.Setenv("RESPONSE_MAX_BYTES", tt.maxBytes)
+
+		body := []map[string]string{{"InstanceId": "i-1"}}
+		response, err := resultResponse(body, &RunMeta{RunID: "run"}, anonymousCaller)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if response.StatusCode != tt.status || !strings.Contains(response.Body, tt.body) {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, response.StatusCode, response.Body, tt.status, tt.body)
+		}
+	}
+}
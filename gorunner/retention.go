@@ -0,0 +1,129 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// s3DeleteBatchSize is the maximum number of keys in DeleteObjects call
+const s3DeleteBatchSize = 1000
+
+// retention limits stored history, zero values mean no limit
+type retention struct {
+	runs int
+	days int
+}
+
+func getRetention() retention {
+	runs, _ := strconv.Atoi(getEnv("HISTORY_RETENTION_RUNS", "0"))
+	days, _ := strconv.Atoi(getEnv("HISTORY_RETENTION_DAYS", "0"))
+
+	return retention{runs: runs, days: days}
+}
+
+func (r retention) enabled() bool {
+	return r.runs > 0 || r.days > 0
+}
+
+// expiresAt returns the time stored runs should be removed at
+func (r retention) expiresAt(startTime time.Time) time.Time {
+	if r.days <= 0 {
+		return time.Time{}
+	}
+
+	return startTime.AddDate(0, 0, r.days)
+}
+
+// expired returns ids of runs not fitting into retention limits
+func (r retention) expired(runIDs []string, now time.Time) map[string]bool {
+	sorted := append([]string{}, runIDs...)
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+
+	expired := map[string]bool{}
+	for i, id := range sorted {
+		if r.runs > 0 && i >= r.runs {
+			expired[id] = true
+			continue
+		}
+
+		if startTime, err := runStartTime(id); err == nil && r.days > 0 && now.After(r.expiresAt(startTime)) {
+			expired[id] = true
+		}
+	}
+
+	return expired
+}
+
+// runStartTime parses start time encoded into run id by newRunID
+func runStartTime(runID string) (time.Time, error) {
+	layout := "20060102T150405Z"
+	if len(runID) < len(layout) {
+		return time.Time{}, errors.Errorf("Unexpected run id: %s", runID)
+	}
+
+	return time.Parse(layout, runID[:len(layout)])
+}
+
+// enforceRetention deletes expired runs and their partitions from the history bucket
+func (s *s3Sink) enforceRetention(r retention) error {
+	prefix := getEnv("HISTORY_PREFIX", defaultHistoryPrefix)
+	keysByRun := map[string][]string{}
+
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			if !strings.HasSuffix(key, ".json") {
+				continue
+			}
+
+			runID := strings.TrimSuffix(path.Base(key), ".json")
+			keysByRun[runID] = append(keysByRun[runID], key)
+		}
+
+		return true
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Can't list runs in s3://%s/%s", s.bucket, prefix)
+	}
+
+	runIDs := []string{}
+	for id := range keysByRun {
+		runIDs = append(runIDs, id)
+	}
+
+	toDelete := []*s3.ObjectIdentifier{}
+	for id := range r.expired(runIDs, time.Now()) {
+		for _, key := range keysByRun[id] {
+			toDelete = append(toDelete, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+	}
+
+	for len(toDelete) > 0 {
+		n := s3DeleteBatchSize
+		if len(toDelete) < n {
+			n = len(toDelete)
+		}
+
+		_, err := s.svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{Objects: toDelete[:n], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Can't delete expired runs from s3://%s", s.bucket)
+		}
+
+		toDelete = toDelete[n:]
+	}
+
+	return nil
+}
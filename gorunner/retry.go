@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// retryMargin is reserved to shape and return the response after the last retry
+const retryMargin = 2 * time.Second
+
+// retryableError marks failures which may disappear on the next attempt
+type retryableError struct {
+	error
+}
+
+func isRetryable(err error) bool {
+	_, ok := errors.Cause(err).(retryableError)
+	return ok
+}
+
+// isAuthError tells if the ssh server rejected our credentials,
+// there is no point to retry such connections
+func isAuthError(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// retryFailed re-attempts instances failed with retryable errors while
+// the time budget of the invocation allows it
+func retryFailed(ctx context.Context, instances []*InstanceInfo, maxAttempts, maxSessions int, collect func([]*InstanceInfo)) {
+	timeout := time.Second * time.Duration(getTimeout())
+
+	for attempt := 2; attempt <= maxAttempts && ctx.Err() == nil; attempt++ {
+		batch := []*InstanceInfo{}
+		for _, inst := range instances {
+			if inst.err != nil && isRetryable(inst.err) {
+				batch = append(batch, inst)
+			}
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		// worst case is a full timeout for every user and address of every
+		// instance, MAX_SESSIONS instances are contacted at once
+		if deadline, ok := ctx.Deadline(); ok {
+			needed := retryBudget(len(batch), maxSessions, len(getUsers())*maxAddrs(batch), timeout)
+			if time.Until(deadline) < needed {
+				fmt.Printf("Not enough time left to retry %v instance(s)\n", len(batch))
+				return
+			}
+		}
+
+		fmt.Printf("Retrying %v failed instance(s), attempt %v...\n", len(batch), attempt)
+		collect(batch)
+	}
+}
+
+// retryBudget is the worst case duration of the retry pass
+func retryBudget(instances, maxSessions, dials int, timeout time.Duration) time.Duration {
+	if maxSessions < 1 {
+		maxSessions = 1
+	}
+	batches := (instances + maxSessions - 1) / maxSessions
+
+	return timeout*time.Duration(batches*dials) + retryMargin
+}
+
+func maxAddrs(instances []*InstanceInfo) int {
+	max := 1
+	for _, inst := range instances {
+		if len(inst.addrs) > max {
+			max = len(inst.addrs)
+		}
+	}
+
+	return max
+}
+
+// splitPending separates instances which are still booting when PENDING_WARMUP is set
+func splitPending(instances []*InstanceInfo, warmup time.Duration) (ready, pending []*InstanceInfo) {
+	for _, inst := range instances {
+		if warmup > 0 && inst.pending() {
+			pending = append(pending, inst)
+			continue
+		}
+		ready = append(ready, inst)
+	}
+
+	return ready, pending
+}
+
+// collectPending waits until the warm-up delay passes since the run start
+// and collects facts from pending instances. Instances are failed without
+// connecting if the invocation time budget doesn't allow to wait.
+func collectPending(ctx context.Context, pending []*InstanceInfo, startTime time.Time, warmup time.Duration, collect func([]*InstanceInfo)) {
+	if len(pending) == 0 {
+		return
+	}
+
+	delay := warmup - time.Since(startTime)
+	timeout := time.Second * time.Duration(getTimeout())
+
+	if deadline, ok := ctx.Deadline(); ok {
+		needed := delay + timeout*time.Duration(len(getUsers())*maxAddrs(pending)) + retryMargin
+		if time.Until(deadline) < needed {
+			fmt.Printf("Not enough time left to wait for %v pending instance(s)\n", len(pending))
+			for _, inst := range pending {
+				inst.err = errors.Errorf("Instance is pending, not enough time left to wait for warm-up")
+			}
+			return
+		}
+	}
+
+	if delay > 0 {
+		fmt.Printf("Waiting %v for %v pending instance(s) to warm up...\n", delay.Round(time.Second), len(pending))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			for _, inst := range pending {
+				inst.err = errRunCancelled
+			}
+			return
+		}
+	}
+
+	collect(pending)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Rule checks the fact value of every instance. Instances which failed
+// to collect facts are not evaluated.
+type Rule struct {
+	Name string `yaml:"name"`
+	Fact string `yaml:"fact"`
+	// Equals requires the exact fact value
+	Equals *string `yaml:"equals"`
+	// Matches requires the fact value to match the regular expression
+	Matches string `yaml:"matches"`
+}
+
+// evaluateRules reports rule results in Compliance field of rows and
+// counts noncompliant instances in the run summary
+func evaluateRules(rules []Rule, meta *RunMeta, rows []ResRow) error {
+	patterns := map[string]*regexp.Regexp{}
+	for _, rule := range rules {
+		if rule.Name == "" || rule.Fact == "" {
+			return errors.Errorf("Rule should have name and fact")
+		}
+		if rule.Equals == nil && rule.Matches == "" {
+			return errors.Errorf("Rule '%s' should define equals or matches", rule.Name)
+		}
+
+		if rule.Matches != "" {
+			re, err := regexp.Compile(rule.Matches)
+			if err != nil {
+				return errors.Wrapf(err, "Invalid pattern of '%s' rule", rule.Name)
+			}
+			patterns[rule.Name] = re
+		}
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		if row.Error != "" {
+			continue
+		}
+
+		if row.Compliance == nil {
+			row.Compliance = map[string]bool{}
+		}
+
+		compliant := true
+		for _, rule := range rules {
+			value, ok := row.Facts[rule.Fact]
+
+			passed := ok
+			if passed && rule.Equals != nil {
+				passed = value == *rule.Equals
+			}
+			if passed && patterns[rule.Name] != nil {
+				passed = patterns[rule.Name].MatchString(value)
+			}
+
+			row.Compliance[rule.Name] = passed
+			compliant = compliant && passed
+		}
+
+		if !compliant {
+			meta.Summary["noncompliant"]++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"io"
+	"math/big"
+	"os"
+	"reflect"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// secretBuffer keeps private key material. Memory is locked where supported,
+// so it's never swapped, and wiped with Wipe once the key is parsed.
+type secretBuffer struct {
+	data []byte
+
+	// mapped and locked tell how the memory was allocated
+	mapped bool
+	locked bool
+}
+
+// Bytes returns the buffer contents, the slice is invalid after Wipe
+func (b *secretBuffer) Bytes() []byte {
+	return b.data
+}
+
+// Wipe zeroes and releases the buffer
+func (b *secretBuffer) Wipe() {
+	wipeBytes(b.data)
+
+	freeSecretMemory(b)
+	b.data = nil
+}
+
+// moveSecretString copies the secret into the buffer and wipes the string,
+// it's used for secrets received as strings which aren't referenced elsewhere
+func moveSecretString(s *string) *secretBuffer {
+	b := newSecretBuffer(len(*s))
+	copy(b.data, *s)
+	wipeString(s)
+
+	return b
+}
+
+// takeEnvSecret moves the variable value into the buffer, wipes the value in
+// the process environment and unsets the variable. Nil is returned if it's empty.
+func takeEnvSecret(name string) *secretBuffer {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	b := moveSecretString(&value)
+	os.Unsetenv(name)
+
+	return b
+}
+
+// wipeString zeroes memory backing the string in place. It must never be
+// used for string literals or strings which are still referenced.
+func wipeString(s *string) {
+	if len(*s) == 0 {
+		return
+	}
+
+	var data []byte
+	sh := (*reflect.StringHeader)(unsafe.Pointer(s))
+	bh := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	bh.Data, bh.Len, bh.Cap = sh.Data, sh.Len, sh.Len
+
+	for i := range data {
+		data[i] = 0
+	}
+	*s = ""
+}
+
+// wipePrivateKey zeroes private parts of the key parsed with ssh.ParseRawPrivateKey,
+// the key is unusable afterwards
+func wipePrivateKey(key interface{}) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		wipeInt(k.D)
+		for _, prime := range k.Primes {
+			wipeInt(prime)
+		}
+		wipeInt(k.Precomputed.Dp)
+		wipeInt(k.Precomputed.Dq)
+		wipeInt(k.Precomputed.Qinv)
+		for _, crt := range k.Precomputed.CRTValues {
+			wipeInt(crt.Exp)
+			wipeInt(crt.Coeff)
+			wipeInt(crt.R)
+		}
+	case *ecdsa.PrivateKey:
+		wipeInt(k.D)
+	case *dsa.PrivateKey:
+		wipeInt(k.X)
+	case *ed25519.PrivateKey:
+		wipeBytes(*k)
+	case ed25519.PrivateKey:
+		wipeBytes(k)
+	}
+}
+
+func wipePrivateKeys(keys []interface{}) {
+	for _, key := range keys {
+		wipePrivateKey(key)
+	}
+}
+
+func wipeInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+
+	words := n.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	n.SetInt64(0)
+}
+
+func wipeBytes(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
+
+// readSecretFile reads the file directly into the buffer to avoid extra copies
+func readSecretFile(path string) (*secretBuffer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	b := newSecretBuffer(int(info.Size()))
+	if _, err := io.ReadFull(f, b.data); err != nil {
+		b.Wipe()
+		return nil, errors.Wrapf(err, "Can't read %s", path)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"syscall"
+)
+
+// newSecretBuffer allocates memory outside of Go heap and locks it,
+// unlocked memory is used if mlock is not permitted
+func newSecretBuffer(size int) *secretBuffer {
+	if size == 0 {
+		return &secretBuffer{data: []byte{}}
+	}
+
+	data, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		log.Printf("Can't allocate memory for the key outside of heap: %v", err)
+		return &secretBuffer{data: make([]byte, size)}
+	}
+
+	b := &secretBuffer{data: data, mapped: true}
+	if err := syscall.Mlock(data); err != nil {
+		log.Printf("Can't lock memory of the key: %v", err)
+		return b
+	}
+	b.locked = true
+
+	return b
+}
+
+func freeSecretMemory(b *secretBuffer) {
+	if b.locked {
+		syscall.Munlock(b.data)
+	}
+	if b.mapped {
+		syscall.Munmap(b.data)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// newSecretBuffer uses regular memory where locking is not implemented,
+// the buffer is still wiped
+func newSecretBuffer(size int) *secretBuffer {
+	return &secretBuffer{data: make([]byte, size)}
+}
+
+func freeSecretMemory(b *secretBuffer) {}
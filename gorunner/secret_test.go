@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTakeEnvSecret(t *testing.T) {
+	os.Setenv("GORUNNER_TEST_SECRET", strings.Repeat("k", 64))
+
+	// the value shares memory with the environment copy
+	value := os.Getenv("GORUNNER_TEST_SECRET")
+	secret := takeEnvSecret("GORUNNER_TEST_SECRET")
+	if secret == nil || string(secret.Bytes()) != strings.Repeat("k", 64) {
+		t.Fatalf("unexpected secret: %v", secret)
+	}
+	defer secret.Wipe()
+
+	if _, ok := os.LookupEnv("GORUNNER_TEST_SECRET"); ok {
+		t.Error("variable is not unset")
+	}
+	if value != strings.Repeat("\x00", 64) {
+		t.Errorf("environment value is not wiped: %q", value)
+	}
+	if takeEnvSecret("GORUNNER_TEST_SECRET") != nil {
+		t.Error("unset variable is returned")
+	}
+}
+
+func TestWipePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   interface{}
+		wiped func() bool
+	}{
+		{"rsa", rsaKey, func() bool {
+			return rsaKey.D.Sign() == 0 && rsaKey.Primes[0].Sign() == 0 && rsaKey.Primes[1].Sign() == 0
+		}},
+		{"ecdsa", ecdsaKey, func() bool { return ecdsaKey.D.Sign() == 0 }},
+		{"ed25519", &ed25519Key, func() bool { return strings.Trim(string(ed25519Key), "\x00") == "" }},
+	}
+
+	for _, tt := range tests {
+		wipePrivateKey(tt.key)
+		if !tt.wiped() {
+			t.Errorf("%s key is not wiped", tt.name)
+		}
+	}
+}
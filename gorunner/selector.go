@@ -0,0 +1,229 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// selector matches instances of the target expression
+type selector func(inst *InstanceInfo) bool
+
+// selectorKeys are attributes of instances besides tag:<Key>
+var selectorKeys = map[string]func(inst *InstanceInfo) string{
+	"id":        func(inst *InstanceInfo) string { return inst.id },
+	"name":      func(inst *InstanceInfo) string { return inst.name },
+	"source":    func(inst *InstanceInfo) string { return inst.source },
+	"account":   func(inst *InstanceInfo) string { return inst.account },
+	"region":    func(inst *InstanceInfo) string { return inst.region },
+	"state":     func(inst *InstanceInfo) string { return inst.state() },
+	"lifecycle": func(inst *InstanceInfo) string { return inst.lifecycle() },
+	"type": func(inst *InstanceInfo) string {
+		if inst.description == nil {
+			return ""
+		}
+		return aws.StringValue(inst.description.InstanceType)
+	},
+	"az": func(inst *InstanceInfo) string { return inst.availabilityZone() },
+	"vpc": func(inst *InstanceInfo) string {
+		if inst.description == nil {
+			return ""
+		}
+		return aws.StringValue(inst.description.VpcId)
+	},
+	"subnet": func(inst *InstanceInfo) string {
+		if inst.description == nil {
+			return ""
+		}
+		return aws.StringValue(inst.description.SubnetId)
+	},
+}
+
+// parseSelector parses the target expression: key=value and key!=value terms
+// combined with and, or, not and parentheses, e.g.
+// tag:Role=web and type=m5.* and not (az=us-east-1a or tag:Env="load test").
+// Values may contain * and ? wildcards.
+func parseSelector(text string) (selector, error) {
+	tokens, err := selectorTokens(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.Errorf("Empty selector")
+	}
+
+	p := &selectorParser{tokens: tokens}
+	sel, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errors.Errorf("Unexpected '%s' in selector", p.tokens[p.pos])
+	}
+
+	return sel, nil
+}
+
+// selectorTokens splits the expression by spaces and parentheses outside of quotes
+func selectorTokens(text string) ([]string, error) {
+	tokens := []string{}
+	token, quote := "", rune(0)
+
+	for _, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			token += string(c)
+		case c == '"' || c == '\'':
+			quote = c
+			token += string(c)
+		case c == ' ' || c == '\t' || c == '(' || c == ')':
+			if token != "" {
+				tokens = append(tokens, token)
+			}
+			token = ""
+			if c == '(' || c == ')' {
+				tokens = append(tokens, string(c))
+			}
+		default:
+			token += string(c)
+		}
+	}
+	if quote != 0 {
+		return nil, errors.Errorf("Unterminated quote in selector: %s", text)
+	}
+	if token != "" {
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+type selectorParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *selectorParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *selectorParser) or() (selector, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for strings.EqualFold(p.peek(), "or") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(inst *InstanceInfo) bool { return l(inst) || right(inst) }
+	}
+
+	return left, nil
+}
+
+func (p *selectorParser) and() (selector, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for strings.EqualFold(p.peek(), "and") {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(inst *InstanceInfo) bool { return l(inst) && right(inst) }
+	}
+
+	return left, nil
+}
+
+func (p *selectorParser) unary() (selector, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return nil, errors.Errorf("Unexpected end of selector")
+	case strings.EqualFold(token, "not"):
+		p.pos++
+		sel, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(inst *InstanceInfo) bool { return !sel(inst) }, nil
+	case token == "(":
+		p.pos++
+		sel, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.Errorf("Missing ')' in selector")
+		}
+		p.pos++
+		return sel, nil
+	}
+
+	p.pos++
+	return parseSelectorTerm(token)
+}
+
+// parseSelectorTerm parses key=value or key!=value
+func parseSelectorTerm(term string) (selector, error) {
+	negate := false
+	i := strings.Index(term, "=")
+	if i <= 0 {
+		return nil, errors.Errorf("Invalid selector term: '%s' (should be key=value)", term)
+	}
+	key, value := term[:i], unquote(term[i+1:])
+	if strings.HasSuffix(key, "!") {
+		negate, key = true, strings.TrimSuffix(key, "!")
+	}
+
+	attr, ok := selectorKeys[key]
+	if strings.HasPrefix(key, "tag:") && len(key) > len("tag:") {
+		tag := strings.TrimPrefix(key, "tag:")
+		attr, ok = func(inst *InstanceInfo) string { return inst.tags[tag] }, true
+	}
+	if !ok {
+		return nil, errors.Errorf("Unknown selector key: '%s' (available: tag:<Key>, %s)", key, strings.Join(selectorKeyNames(), ", "))
+	}
+
+	pattern := regexp.QuoteMeta(value)
+	pattern = strings.Replace(pattern, `\*`, ".*", -1)
+	pattern = strings.Replace(pattern, `\?`, ".", -1)
+	re := regexp.MustCompile("^" + pattern + "$")
+
+	return func(inst *InstanceInfo) bool { return re.MatchString(attr(inst)) != negate }, nil
+}
+
+func selectorKeyNames() []string {
+	return []string{"id", "name", "source", "account", "region", "state", "lifecycle", "type", "az", "vpc", "subnet"}
+}
+
+// filterSelector keeps instances matching the selector
+func filterSelector(instances []*InstanceInfo, sel selector) []*InstanceInfo {
+	filtered := []*InstanceInfo{}
+	for _, inst := range instances {
+		if sel(inst) {
+			filtered = append(filtered, inst)
+		}
+	}
+
+	return filtered
+}
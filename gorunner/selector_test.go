@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestParseSelector(t *testing.T) {
+	instance := func(id, state, az string, tags map[string]string) *InstanceInfo {
+		return &InstanceInfo{id: id, source: "ec2", tags: tags, description: &ec2.Instance{
+			InstanceType: aws.String("m5.large"),
+			State:        &ec2.InstanceState{Name: aws.String(state)},
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String(az)},
+		}}
+	}
+	instances := []*InstanceInfo{
+		instance("i-1", "running", "us-east-1a", map[string]string{"Role": "web", "Env": "load test"}),
+		instance("i-2", "running", "us-east-1b", map[string]string{"Role": "web", "Env": "prod"}),
+		instance("i-3", "stopped", "us-east-1a", map[string]string{"Role": "db", "Env": "prod"}),
+		{id: "i-4", source: "hosts", tags: map[string]string{}},
+	}
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"tag:Role=web", []string{"i-1", "i-2"}},
+		{"tag:Role!=web", []string{"i-3", "i-4"}},
+		{"id=i-?", []string{"i-1", "i-2", "i-3", "i-4"}},
+		{"type=m5.*", []string{"i-1", "i-2", "i-3"}},
+		{"az=us-east-1a", []string{"i-1", "i-3"}},
+		{"source=hosts", []string{"i-4"}},
+		{"state=", []string{"i-4"}},
+		{`tag:Env="load test"`, []string{"i-1"}},
+		{`tag:Env='load *'`, []string{"i-1"}},
+		{"tag:Env=load test", nil},
+
+		// and binds tighter than or, not binds tighter than and
+		{"tag:Role=db or tag:Role=web and az=us-east-1b", []string{"i-2", "i-3"}},
+		{"(tag:Role=db or tag:Role=web) and az=us-east-1b", []string{"i-2"}},
+		{"not tag:Role=web and source=ec2", []string{"i-3"}},
+		{"not (tag:Role=web and source=ec2)", []string{"i-3", "i-4"}},
+		{"tag:Role=web AND NOT az=us-east-1a", []string{"i-2"}},
+		{"not not state=running", []string{"i-1", "i-2"}},
+	}
+
+	for _, tt := range tests {
+		sel, err := parseSelector(tt.text)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.text, err)
+			continue
+		}
+
+		got := []string{}
+		for _, inst := range filterSelector(instances, sel) {
+			got = append(got, inst.id)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"", "Empty selector"},
+		{"   ", "Empty selector"},
+		{"role=web", "Unknown selector key: 'role' (available: tag:<Key>, id, name"},
+		{"tag:=web", "Unknown selector key: 'tag:'"},
+		{"web", "Invalid selector term: 'web' (should be key=value)"},
+		{"=web", "Invalid selector term: '=web' (should be key=value)"},
+		{`tag:Env="load test`, `Unterminated quote in selector: tag:Env="load test`},
+		{"(id=i-1", "Missing ')' in selector"},
+		{"id=i-1)", "Unexpected ')' in selector"},
+		{"id=i-1 id=i-2", "Unexpected 'id=i-2' in selector"},
+		{"id=i-1 and", "Unexpected end of selector"},
+		{"not", "Unexpected end of selector"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseSelector(tt.text)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.text, err, tt.err)
+		}
+	}
+}
+
+func TestRunSelector(t *testing.T) {
+	tests := []struct {
+		env     string
+		request string
+		want    string
+	}{
+		{"", "", ""},
+		{"tag:Role=web", "", "tag:Role=web"},
+		{"tag:Role=web", "tag:Role=db", "tag:Role=db"},
+	}
+
+	defer os.Unsetenv("SELECTOR")
+
+	for _, tt := range tests {
+		os.Setenv("SELECTOR", tt.env)
+
+		run := &pipelineRun{opts: RunOptions{Selector: tt.request}}
+		if got := run.selector(); got != tt.want {
+			t.Errorf("SELECTOR=%q, request %q: got %q, want %q", tt.env, tt.request, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	// sessionFact is an internal fact which is never reported in Facts
+	sessionFact = "gorunner:session"
+
+	// sessionMarker separates output of login scripts from the fingerprint
+	sessionMarker = "__gorunner_session__"
+
+	// sessionCommand prints the connection, effective user and the shell
+	// running commands ($0 of `$SHELL -c`)
+	sessionCommand = `echo ` + sessionMarker + `; echo "$SSH_CONNECTION"; id -un; echo "$0"`
+)
+
+// SessionInfo describes the remote environment commands were run in.
+// Unexpected user, shell or output of login scripts explain mangled facts.
+type SessionInfo struct {
+	Connection string
+	LoginUser  string
+	User       string
+	Shell      string
+	// UserMismatch is set when commands were run as another user than the login one
+	UserMismatch bool
+	// ExtraOutput is printed to stdout by login scripts before the command
+	ExtraOutput string `json:",omitempty"`
+}
+
+// sessionFingerprintEnabled tells if SESSION_FINGERPRINT is set
+func sessionFingerprintEnabled() bool {
+	switch strings.ToLower(getEnv("SESSION_FINGERPRINT", "")) {
+	case "1", "true", "yes":
+		return true
+	}
+
+	return false
+}
+
+// parseSession reads the fingerprint collected from the instance
+func parseSession(instance *InstanceInfo) {
+	instance.session = nil
+
+	out, ok := instance.facts[sessionFact]
+	if !ok {
+		return
+	}
+
+	info := &SessionInfo{LoginUser: instance.user}
+
+	parts := strings.SplitN(out, sessionMarker, 2)
+	if len(parts) == 2 {
+		info.ExtraOutput = strings.TrimSpace(parts[0])
+		out = parts[1]
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i, field := range []*string{&info.Connection, &info.User, &info.Shell} {
+		if i < len(lines) {
+			*field = strings.TrimSpace(lines[i])
+		}
+	}
+	info.UserMismatch = info.User != "" && info.User != info.LoginUser
+
+	instance.session = info
+}
+
+// anomalous tells if the session could mangle command outputs
+func (s *SessionInfo) anomalous() bool {
+	return s != nil && (s.UserMismatch || s.ExtraOutput != "")
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// shellScript is a command line split the way the shell does it: simple
+// commands with unquoted words, targets of output redirects and scripts of
+// command substitutions. It's a best effort parser for checks of fact
+// commands, not a complete shell grammar.
+type shellScript struct {
+	commands  [][]string
+	redirects []string
+	nested    []string
+}
+
+// shellOperators end words outside quotes, all of them but redirects end the simple command
+const shellOperators = ";&|()<>\n"
+
+// parseShell splits the script into simple commands
+func parseShell(script string) *shellScript {
+	s := &shellScript{}
+	runes := []rune(script)
+
+	var words []string
+	word := &strings.Builder{}
+	inWord := false
+	heredocs := []string{}
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			s.commands = append(s.commands, words)
+			words = nil
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\':
+			if i+1 < len(runes) {
+				i++
+				if runes[i] != '\n' {
+					word.WriteRune(runes[i])
+					inWord = true
+				}
+			}
+		case c == '\'':
+			end := indexRune(runes, i+1, '\'')
+			word.WriteString(string(runes[i+1 : end]))
+			inWord = true
+			i = end
+		case c == '"':
+			i = s.readDoubleQuoted(runes, i+1, word)
+			inWord = true
+		case c == '`':
+			end := indexRune(runes, i+1, '`')
+			s.nested = append(s.nested, string(runes[i+1:end]))
+			inWord = true
+			i = end
+		case c == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			end := closingParen(runes, i+2)
+			s.nested = append(s.nested, string(runes[i+2:end]))
+			inWord = true
+			i = end
+		case c == '#' && !inWord:
+			i = indexRune(runes, i, '\n') - 1
+		case c == '>' || c == '<' || (c == '&' && i+1 < len(runes) && runes[i+1] == '>'):
+			// descriptor number before the operator belongs to it
+			if inWord && isDigits(word.String()) {
+				word.Reset()
+				inWord = false
+			}
+			endWord()
+			i = s.readRedirect(runes, i, &heredocs) - 1
+		case c == '\n':
+			endCommand()
+			if len(heredocs) > 0 {
+				i = skipHeredocs(runes, i+1, heredocs) - 1
+				heredocs = heredocs[:0]
+			}
+		case strings.ContainsRune(shellOperators, c):
+			endCommand()
+		case unicode.IsSpace(c):
+			endWord()
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	endCommand()
+
+	return s
+}
+
+// readDoubleQuoted appends the quoted text to the word and returns
+// the position of the closing quote, substitutions are nested scripts
+func (s *shellScript) readDoubleQuoted(runes []rune, i int, word *strings.Builder) int {
+	for ; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '"':
+			return i
+		case c == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`\n", runes[i+1]):
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+			}
+		case c == '`':
+			end := indexRune(runes, i+1, '`')
+			s.nested = append(s.nested, string(runes[i+1:end]))
+			i = end
+		case c == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			end := closingParen(runes, i+2)
+			s.nested = append(s.nested, string(runes[i+2:end]))
+			i = end
+		default:
+			word.WriteRune(c)
+		}
+	}
+
+	return i
+}
+
+// readRedirect reads the redirect operator and its target starting at i and
+// returns the position after them. Here-documents are queued to be skipped,
+// output redirects to other places than null device and descriptors are recorded.
+func (s *shellScript) readRedirect(runes []rune, i int, heredocs *[]string) int {
+	op := string(runes[i])
+	for i++; i < len(runes) && strings.ContainsRune("<>&|-", runes[i]); i++ {
+		op += string(runes[i])
+	}
+	for i < len(runes) && (runes[i] == ' ' || runes[i] == '\t') {
+		i++
+	}
+
+	// process substitution
+	if i < len(runes) && runes[i] == '(' && (op == "<" || op == ">") {
+		end := closingParen(runes, i+1)
+		s.nested = append(s.nested, string(runes[i+1:end]))
+		return end + 1
+	}
+
+	target, end := readShellWord(runes, i)
+	switch {
+	case strings.HasPrefix(op, "<<") && op != "<<<":
+		*heredocs = append(*heredocs, target)
+	case !strings.Contains(op, ">"):
+		// input redirect
+	case harmlessRedirectTarget(op, target):
+	default:
+		s.redirects = append(s.redirects, op+target)
+	}
+
+	return end
+}
+
+// harmlessRedirectTarget tells if the redirect doesn't write files
+func harmlessRedirectTarget(op, target string) bool {
+	switch {
+	case strings.HasSuffix(op, "-"):
+		return true
+	case strings.HasSuffix(op, ">&") && (isDigits(target) || target == "-"):
+		return true
+	}
+
+	switch target {
+	case "/dev/null", "/dev/stdout", "/dev/stderr":
+		return true
+	}
+
+	return false
+}
+
+// readShellWord reads a single unquoted word starting at i, substitutions are kept as is
+func readShellWord(runes []rune, i int) (string, int) {
+	word := &strings.Builder{}
+	for ; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+		case c == '\'' || c == '"':
+			end := indexRune(runes, i+1, c)
+			word.WriteString(string(runes[i+1 : end]))
+			i = end
+		case c == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			end := closingParen(runes, i+2)
+			word.WriteString(string(runes[i : end+1]))
+			i = end
+		case unicode.IsSpace(c) || strings.ContainsRune(shellOperators, c):
+			return word.String(), i
+		default:
+			word.WriteRune(c)
+		}
+	}
+
+	return word.String(), i
+}
+
+// skipHeredocs returns the position after bodies of here-documents starting at i
+func skipHeredocs(runes []rune, i int, delimiters []string) int {
+	for _, delimiter := range delimiters {
+		for i < len(runes) {
+			end := indexRune(runes, i, '\n')
+			line := string(runes[i:end])
+			i = end + 1
+			if strings.TrimLeft(line, "\t") == delimiter {
+				break
+			}
+		}
+	}
+
+	if i > len(runes) {
+		return len(runes)
+	}
+
+	return i
+}
+
+// indexRune returns the position of r at or after i, the end of runes if there's none
+func indexRune(runes []rune, i int, r rune) int {
+	for ; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+
+	return len(runes)
+}
+
+// closingParen returns the position of the parenthesis closing the one before i,
+// quoted parentheses are skipped
+func closingParen(runes []rune, i int) int {
+	depth := 1
+	for ; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case '\'', '"':
+			i = indexRune(runes, i+1, runes[i])
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return len(runes)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dynamoDBBatchSize is the maximum number of items in BatchWriteItem call
+	dynamoDBBatchSize = 25
+
+	// dynamoDBMaxThrottled is the number of batches in a row with unprocessed
+	// items before the write fails
+	dynamoDBMaxThrottled = 8
+
+	// dynamoDBBackoffBase and dynamoDBBackoffMax bound the delay before
+	// the batch following the throttled one
+	dynamoDBBackoffBase = 50 * time.Millisecond
+	dynamoDBBackoffMax  = 5 * time.Second
+
+	// dynamoDBTTLAttribute is enabled as TTL attribute of the table,
+	// it's named the same way with any OUTPUT_CASE
+	dynamoDBTTLAttribute = "ExpiresAt"
+)
+
+// dynamoDBBatchWriter is the part of DynamoDB API used by the sink
+type dynamoDBBatchWriter interface {
+	BatchWriteItem(*dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// dynamoDBSink puts a row per instance into RESULTS_TABLE.
+// The table should have RunID hash key and InstanceId range key named according to OUTPUT_CASE,
+// Partition attribute (account/region) could be used as a secondary index key.
+type dynamoDBSink struct {
+	table   string
+	encoder *outputEncoder
+	svc     dynamoDBBatchWriter
+
+	// sleep waits between batches returning unprocessed items
+	sleep func(time.Duration)
+}
+
+func newDynamoDBSink() (Sink, error) {
+	table := getEnv("RESULTS_TABLE", "")
+	if table == "" {
+		return nil, errors.Errorf("You should provide RESULTS_TABLE")
+	}
+
+	encoder, err := newOutputEncoder()
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamoDBSink{
+		table:   table,
+		encoder: encoder,
+		svc:     dynamodb.New(awsSession()),
+		sleep:   time.Sleep,
+	}, nil
+}
+
+func (s *dynamoDBSink) Write(meta *RunMeta, rows []ResRow) error {
+	requests := []*dynamodb.WriteRequest{}
+
+	for _, row := range rows {
+		item, err := s.item(meta, row)
+		if err != nil {
+			return errors.Wrapf(err, "Can't convert %s row", row.InstanceId)
+		}
+
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: item},
+		})
+	}
+
+	throttled := 0
+	for len(requests) > 0 {
+		n := dynamoDBBatchSize
+		if len(requests) < n {
+			n = len(requests)
+		}
+
+		out, err := s.svc.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{s.table: requests[:n]},
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Can't write run %s to %s table", meta.RunID, s.table)
+		}
+
+		// throttled items are sent again with the next batch after the backoff
+		unprocessed := out.UnprocessedItems[s.table]
+		requests = append(requests[n:], unprocessed...)
+		if len(unprocessed) == 0 {
+			throttled = 0
+			continue
+		}
+
+		throttled++
+		if throttled >= dynamoDBMaxThrottled {
+			return errors.Errorf("Can't write run %s to %s table: %v item(s) are still unprocessed after %v throttled batches", meta.RunID, s.table, len(requests), throttled)
+		}
+		s.sleep(dynamoDBBackoff(throttled))
+	}
+
+	return nil
+}
+
+// dynamoDBBackoff returns exponential delay with full jitter before the batch
+// following the given number of throttled ones
+func dynamoDBBackoff(throttled int) time.Duration {
+	limit := dynamoDBBackoffBase << uint(throttled-1)
+	if limit > dynamoDBBackoffMax || limit <= 0 {
+		limit = dynamoDBBackoffMax
+	}
+
+	return time.Duration(rand.Int63n(int64(limit)) + 1)
+}
+
+// item converts the row into DynamoDB attributes using the output encoder
+func (s *dynamoDBSink) item(meta *RunMeta, row ResRow) (map[string]*dynamodb.AttributeValue, error) {
+	body, err := s.encoder.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]interface{}{}
+	if err := json.Unmarshal(body, &attrs); err != nil {
+		return nil, err
+	}
+
+	attrs[s.encoder.rename("RunID")] = meta.RunID
+	attrs[s.encoder.rename("InstanceId")] = row.InstanceId
+	attrs[s.encoder.rename("Partition")] = row.Account + "/" + row.Region
+	if len(meta.Labels) > 0 {
+		attrs[s.encoder.rename("Labels")] = meta.Labels
+	}
+
+	// DynamoDB TTL attribute should be a number of seconds since the epoch,
+	// HISTORY_RETENTION_RUNS can't be expressed with it and isn't applied
+	if r := getRetention(); r.days > 0 {
+		if startTime, err := runStartTime(meta.RunID); err == nil {
+			attrs[dynamoDBTTLAttribute] = r.expiresAt(startTime).Unix()
+		}
+	}
+
+	item, err := dynamodbattribute.MarshalMap(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
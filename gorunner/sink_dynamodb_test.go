@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// throttlingWriter leaves the first items of the batch unprocessed
+// while throttles remain, negative throttles never end
+type throttlingWriter struct {
+	unprocessed int
+	throttles   int
+	written     int
+}
+
+func (w *throttlingWriter) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	requests := input.RequestItems["results"]
+	out := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+
+	if w.throttles != 0 {
+		w.throttles--
+		n := w.unprocessed
+		if n > len(requests) {
+			n = len(requests)
+		}
+		out.UnprocessedItems["results"] = requests[:n]
+		requests = requests[n:]
+	}
+	w.written += len(requests)
+
+	return out, nil
+}
+
+func TestDynamoDBSinkWrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		rows        int
+		unprocessed int
+		throttles   int
+		written     int
+		sleeps      int
+		fails       bool
+	}{
+		{"no throttling", 60, 0, 0, 60, 0, false},
+		{"throttled batches are retried", 30, 5, 3, 30, 3, false},
+		{"gives up after max throttled batches", 3, 25, -1, 0, dynamoDBMaxThrottled - 1, true},
+	}
+
+	encoder, err := newOutputEncoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		writer := &throttlingWriter{unprocessed: tt.unprocessed, throttles: tt.throttles}
+		sleeps := []time.Duration{}
+		sink := &dynamoDBSink{
+			table:   "results",
+			encoder: encoder,
+			svc:     writer,
+			sleep:   func(d time.Duration) { sleeps = append(sleeps, d) },
+		}
+
+		rows := []ResRow{}
+		for i := 0; i < tt.rows; i++ {
+			rows = append(rows, ResRow{InstanceId: fmt.Sprintf("i-%d", i)})
+		}
+
+		err := sink.Write(&RunMeta{RunID: "run"}, rows)
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if writer.written != tt.written {
+			t.Errorf("%s: written %d items, want %d", tt.name, writer.written, tt.written)
+		}
+		if len(sleeps) != tt.sleeps {
+			t.Errorf("%s: slept %d times, want %d", tt.name, len(sleeps), tt.sleeps)
+		}
+		for i, d := range sleeps {
+			if limit := dynamoDBBackoffBase << uint(i); d <= 0 || d > limit {
+				t.Errorf("%s: delay #%d is %v, want up to %v", tt.name, i+1, d, limit)
+			}
+		}
+	}
+}
+
+func TestDynamoDBBackoff(t *testing.T) {
+	for throttled := 1; throttled < 100; throttled++ {
+		if d := dynamoDBBackoff(throttled); d <= 0 || d > dynamoDBBackoffMax {
+			t.Fatalf("backoff after %d throttled batches is %v", throttled, d)
+		}
+	}
+}
+
+func TestDynamoDBItem(t *testing.T) {
+	startTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	weekLater := fmt.Sprint(startTime.AddDate(0, 0, 7).Unix())
+
+	tests := []struct {
+		outputCase    string
+		retentionDays string
+		keys          []string
+		expiresAt     string
+	}{
+		{"pascal", "", []string{"RunID", "InstanceId", "Partition"}, ""},
+		{"pascal", "7", []string{"RunID", "InstanceId", "Partition"}, weekLater},
+		{"snake", "7", []string{"run_id", "instance_id", "partition"}, weekLater},
+		{"camel", "7", []string{"runId", "instanceId", "partition"}, weekLater},
+	}
+
+	defer os.Unsetenv("OUTPUT_CASE")
+	defer os.Unsetenv("HISTORY_RETENTION_DAYS")
+
+	for _, tt := range tests {
+		os.Setenv("OUTPUT_CASE", tt.outputCase)
+		os.Setenv("HISTORY_RETENTION_DAYS", tt.retentionDays)
+
+		encoder, err := newOutputEncoder()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink := &dynamoDBSink{table: "results", encoder: encoder}
+
+		item, err := sink.item(&RunMeta{RunID: newRunID(startTime, "request")}, ResRow{InstanceId: "i-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range tt.keys {
+			if item[key] == nil {
+				t.Errorf("%s: %s is missing in %v", tt.outputCase, key, item)
+			}
+		}
+
+		// the TTL attribute is named the same with any OUTPUT_CASE
+		expiresAt := ""
+		if attr := item[dynamoDBTTLAttribute]; attr != nil {
+			expiresAt = aws.StringValue(attr.N)
+		}
+		if expiresAt != tt.expiresAt {
+			t.Errorf("%s: %s is %q, want %q", tt.outputCase, dynamoDBTTLAttribute, expiresAt, tt.expiresAt)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// s3Sink stores runs in HISTORY_BUCKET. Field names are not changed by
+// OUTPUT_CASE here since stored runs are read back as history.
+type s3Sink struct {
+	bucket string
+	svc    *s3.S3
+}
+
+func newS3Sink() (Sink, error) {
+	if !historyEnabled() {
+		return nil, errors.Errorf("You should provide HISTORY_BUCKET")
+	}
+
+	return &s3Sink{
+		bucket: getEnv("HISTORY_BUCKET", ""),
+		svc:    s3.New(awsSession()),
+	}, nil
+}
+
+// Write stores the whole run as history. Runs spanning several accounts or
+// regions are also stored partitioned by account and region. Expired runs are removed afterwards.
+func (s *s3Sink) Write(meta *RunMeta, rows []ResRow) error {
+	if err := s.put(historyKey(meta.RunID), meta, rows); err != nil {
+		return err
+	}
+
+	partitions := partitionRows(rows)
+	if len(partitions) > 1 {
+		for p, partRows := range partitions {
+			if err := s.put(historyPartitionKey(meta.RunID, p.Account, p.Region), meta, partRows); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r := getRetention(); r.enabled() {
+		return s.enforceRetention(r)
+	}
+
+	return nil
+}
+
+func (s *s3Sink) put(key string, meta *RunMeta, rows []ResRow) error {
+	body, err := json.Marshal(&RunResult{RunMeta: *meta, Rows: rows})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+
+	return errors.Wrapf(err, "Can't store run %s in s3://%s/%s", meta.RunID, s.bucket, key)
+}
+
+// partition identifies the source of the rows
+type partition struct {
+	Account string
+	Region  string
+}
+
+func partitionRows(rows []ResRow) map[partition][]ResRow {
+	partitions := map[partition][]ResRow{}
+	for _, row := range rows {
+		p := partition{Account: row.Account, Region: row.Region}
+		partitions[p] = append(partitions[p], row)
+	}
+
+	return partitions
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/pkg/errors"
+)
+
+// snsSink publishes the run description to SNS_TOPIC_ARN.
+// Rows are not published since they could exceed SNS message size limit.
+type snsSink struct {
+	topicArn string
+	encoder  *outputEncoder
+	svc      *sns.SNS
+}
+
+func newSNSSink() (Sink, error) {
+	topicArn := getEnv("SNS_TOPIC_ARN", "")
+	if topicArn == "" {
+		return nil, errors.Errorf("You should provide SNS_TOPIC_ARN")
+	}
+
+	encoder, err := newOutputEncoder()
+	if err != nil {
+		return nil, err
+	}
+
+	return &snsSink{
+		topicArn: topicArn,
+		encoder:  encoder,
+		svc:      sns.New(awsSession()),
+	}, nil
+}
+
+func (s *snsSink) Write(meta *RunMeta, rows []ResRow) error {
+	body, err := s.encoder.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.svc.Publish(&sns.PublishInput{
+		TopicArn: aws.String(s.topicArn),
+		Subject:  aws.String("lambda-gorunner run " + meta.RunID),
+		Message:  aws.String(string(body)),
+	})
+
+	return errors.Wrapf(err, "Can't publish run %s to %s", meta.RunID, s.topicArn)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// webhookSink posts results to WEBHOOK_URL
+type webhookSink struct {
+	url     string
+	encoder *outputEncoder
+	client  *http.Client
+}
+
+func newWebhookSink() (Sink, error) {
+	url := getEnv("WEBHOOK_URL", "")
+	if url == "" {
+		return nil, errors.Errorf("You should provide WEBHOOK_URL")
+	}
+
+	encoder, err := newOutputEncoder()
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhookSink{
+		url:     url,
+		encoder: encoder,
+		client:  &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+func (s *webhookSink) Write(meta *RunMeta, rows []ResRow) error {
+	body, err := s.encoder.Marshal(&RunResult{RunMeta: *meta, Rows: rows})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Can't post results to webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("Webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
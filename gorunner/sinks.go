@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Sink delivers results of the run
+type Sink interface {
+	Write(meta *RunMeta, rows []ResRow) error
+}
+
+// sinks contains constructors for all registered sinks
+var sinks = map[string]func() (Sink, error){
+	"response": newResponseSink,
+	"s3":       newS3Sink,
+	"dynamodb": newDynamoDBSink,
+	"sns":      newSNSSink,
+	"webhook":  newWebhookSink,
+}
+
+// defaultSinks returns rows in the API response and keeps history if it's enabled
+func defaultSinks() string {
+	if historyEnabled() {
+		return "response,s3"
+	}
+
+	return "response"
+}
+
+// sinkList returns names listed in SINKS, serverless passes it as
+// an empty string when it isn't set
+func sinkList() []string {
+	list := getEnv("SINKS", "")
+	if list == "" {
+		list = defaultSinks()
+	}
+
+	return strings.Split(list, ",")
+}
+
+// getSinks returns sinks with given names or ones listed in SINKS variable
+func getSinks(names []string) (map[string]Sink, error) {
+	enabled := map[string]Sink{}
+
+	if len(names) == 0 {
+		names = sinkList()
+	}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		newSink, ok := sinks[name]
+		if !ok {
+			return nil, errors.Errorf("Unknown sink: '%s' (available: %s)", name, strings.Join(sinkNames(), ", "))
+		}
+
+		sink, err := newSink()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Can't setup '%s' sink", name)
+		}
+
+		enabled[name] = sink
+	}
+
+	return enabled, nil
+}
+
+func sinkNames() []string {
+	names := []string{}
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// writeSinks delivers results to all sinks, failed sinks don't stop others
+func writeSinks(enabled map[string]Sink, meta *RunMeta, rows []ResRow) {
+	for name, sink := range enabled {
+		if err := sink.Write(meta, rows); err != nil {
+			fmt.Println(errors.Wrapf(err, "Failed to write results to '%s' sink", name))
+		}
+	}
+}
+
+// sinkEnabled tells if the sink is listed in SINKS or in sinks steps of the pipeline
+func sinkEnabled(name string) bool {
+	names := []string{}
+
+	config, err := loadConfig()
+	if err == nil {
+		for _, step := range config.Pipeline {
+			if step.Step == "sinks" {
+				if len(step.Sinks) == 0 {
+					step.Sinks = sinkList()
+				}
+				names = append(names, step.Sinks...)
+			}
+		}
+	}
+	if err != nil || len(config.Pipeline) == 0 {
+		names = sinkList()
+	}
+
+	for _, n := range names {
+		if strings.TrimSpace(n) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// responseSink marks that rows should be returned in the API response,
+// the response itself is shaped by the Handler
+type responseSink struct{}
+
+func newResponseSink() (Sink, error) {
+	return &responseSink{}, nil
+}
+
+func (s *responseSink) Write(meta *RunMeta, rows []ResRow) error {
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// summaryTopFailures is the number of failure codes reported
+const summaryTopFailures = 5
+
+// FleetSummary is headline numbers of the run for dashboards
+type FleetSummary struct {
+	RunID            string
+	Labels           map[string]string `json:",omitempty"`
+	Instances        int
+	ReachablePercent float64
+	// CompliancePercent is set when the run evaluated pipeline rules
+	CompliancePercent *float64 `json:",omitempty"`
+	TopFailures       []FailureCount
+}
+
+// FailureCount is the number of instances failed with the code
+type FailureCount struct {
+	Code  string
+	Count int
+}
+
+// failureCodes classify row errors, the first matching code wins
+var failureCodes = []struct {
+	code    string
+	pattern string
+}{
+	{"state_changed", "State changed during run"},
+	{"dial_budget", "dial budget"},
+	{"auth", "unable to authenticate"},
+	{"timeout", "timeout"},
+	{"refused", "connection refused"},
+	{"unreachable", "Can't connect to host"},
+	{"no_address", "No hosts to get facts"},
+	{"pending", "Instance is pending"},
+	{"cancelled", "Run cancelled"},
+	{"fact_failed", "Failed to collect"},
+}
+
+func failureCode(err string) string {
+	for _, fc := range failureCodes {
+		if strings.Contains(err, fc.pattern) {
+			return fc.code
+		}
+	}
+
+	return "other"
+}
+
+// summarizeRun computes dashboard numbers of the stored run
+func summarizeRun(run *RunResult) *FleetSummary {
+	summary := &FleetSummary{RunID: run.RunID, Labels: run.Labels, Instances: len(run.Rows), TopFailures: []FailureCount{}}
+
+	reachable, evaluated, compliant := 0, 0, 0
+	failures := map[string]int{}
+	for _, row := range run.Rows {
+		if row.Error == "" && row.State == "" || len(row.Facts) > 0 {
+			reachable++
+		}
+		if row.Error != "" {
+			failures[failureCode(row.Error)]++
+		}
+
+		if len(row.Compliance) > 0 {
+			evaluated++
+			passed := true
+			for _, ok := range row.Compliance {
+				passed = passed && ok
+			}
+			if passed {
+				compliant++
+			}
+		}
+	}
+
+	summary.ReachablePercent = percent(reachable, len(run.Rows))
+	if evaluated > 0 {
+		p := percent(compliant, evaluated)
+		summary.CompliancePercent = &p
+	}
+
+	for code, count := range failures {
+		summary.TopFailures = append(summary.TopFailures, FailureCount{Code: code, Count: count})
+	}
+	sort.Slice(summary.TopFailures, func(i, j int) bool {
+		a, b := summary.TopFailures[i], summary.TopFailures[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Code < b.Code
+	})
+	if len(summary.TopFailures) > summaryTopFailures {
+		summary.TopFailures = summary.TopFailures[:summaryTopFailures]
+	}
+
+	return summary
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return math.Round(float64(part)*1000/float64(total)) / 10
+}
+
+// latestRunID finds the latest run in the history bucket
+func latestRunID() (string, error) {
+	ids, err := listRunIDs()
+	if err != nil {
+		return "", err
+	}
+
+	if len(ids) == 0 {
+		return "", errRunNotFound
+	}
+
+	return ids[len(ids)-1], nil
+}
+
+// listRunIDs returns ids of runs stored in the history bucket, run ids sort
+// in the order runs were started
+func listRunIDs() ([]string, error) {
+	if !historyEnabled() {
+		return nil, errHistoryDisabled
+	}
+
+	bucket := getEnv("HISTORY_BUCKET", "")
+	prefix := getEnv("HISTORY_PREFIX", defaultHistoryPrefix)
+
+	ids := []string{}
+	err := s3.New(awsSession()).ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			ids = append(ids, strings.TrimSuffix(path.Base(aws.StringValue(obj.Key)), ".json"))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can't list runs in s3://%s/%s", bucket, prefix)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// latestRun loads the latest stored run
+func latestRun() (*RunResult, error) {
+	id, err := latestRunID()
+	if err != nil {
+		return nil, err
+	}
+
+	return loadRun(id)
+}
+
+func latestSummary() (*FleetSummary, error) {
+	run, err := latestRun()
+	if err != nil {
+		return nil, err
+	}
+
+	return summarizeRun(run), nil
+}
+
+// handleSummary serves GET /summary
+func handleSummary(request events.APIGatewayProxyRequest) (Response, error) {
+	summary, err := latestSummary()
+	if err == errHistoryDisabled {
+		return errorResponse(404, err)
+	}
+	if err == errRunNotFound {
+		return errorResponse(404, errors.Errorf("No runs stored yet"))
+	}
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	return jsonResponse(200, summary)
+}
+
+// isWidgetEvent tells if the function is invoked by CloudWatch custom widget
+func isWidgetEvent(event json.RawMessage) bool {
+	widget := struct {
+		WidgetContext json.RawMessage `json:"widgetContext"`
+	}{}
+
+	return json.Unmarshal(event, &widget) == nil && len(widget.WidgetContext) > 0
+}
+
+// handleWidget renders the latest run summary as CloudWatch custom widget markdown
+func (h *Handler) handleWidget(ctx context.Context) (interface{}, error) {
+	summary, err := latestSummary()
+	if err == errRunNotFound {
+		return map[string]string{"markdown": "No runs stored yet"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{
+		fmt.Sprintf("**Run** %s: %d instance(s), %.1f%% reachable", summary.RunID, summary.Instances, summary.ReachablePercent),
+	}
+	if summary.CompliancePercent != nil {
+		lines[0] += fmt.Sprintf(", %.1f%% compliant", *summary.CompliancePercent)
+	}
+
+	if len(summary.TopFailures) > 0 {
+		lines = append(lines, "", "| Failure | Instances |", "|---|---|")
+		for _, f := range summary.TopFailures {
+			lines = append(lines, fmt.Sprintf("| %s | %d |", f.Code, f.Count))
+		}
+	}
+
+	return map[string]string{"markdown": strings.Join(lines, "\n")}, nil
+}
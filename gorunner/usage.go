@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// goroutineSampleInterval is how often the number of goroutines is sampled
+const goroutineSampleInterval = 50 * time.Millisecond
+
+// apiCalls counts AWS API requests sent by all sessions of the process
+var apiCalls int64
+
+// countAPICall is a send handler of AWS sessions, retries are counted too
+func countAPICall(r *request.Request) {
+	atomic.AddInt64(&apiCalls, 1)
+}
+
+// ResourceUsage is consumed by the run from its start till facts are collected
+type ResourceUsage struct {
+	SSHBytesSent     int64
+	SSHBytesReceived int64
+	SSHSessions      int64
+	APICalls         int64
+	PeakGoroutines   int64
+}
+
+// usageRecorder measures the run, counters are updated from concurrent goroutines
+type usageRecorder struct {
+	sent     int64
+	received int64
+	sessions int64
+	peak     int64
+
+	apiCallsStart int64
+	done          chan struct{}
+	stopped       chan struct{}
+}
+
+// newUsageRecorder starts sampling goroutines until the recorder is stopped
+func newUsageRecorder() *usageRecorder {
+	u := &usageRecorder{
+		apiCallsStart: atomic.LoadInt64(&apiCalls),
+		peak:          int64(runtime.NumGoroutine()),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	go func() {
+		defer close(u.stopped)
+
+		ticker := time.NewTicker(goroutineSampleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-u.done:
+				return
+			case <-ticker.C:
+				if n := int64(runtime.NumGoroutine()); n > atomic.LoadInt64(&u.peak) {
+					atomic.StoreInt64(&u.peak, n)
+				}
+			}
+		}
+	}()
+
+	return u
+}
+
+// stop stops sampling goroutines, it could be called more than once
+func (u *usageRecorder) stop() {
+	select {
+	case <-u.done:
+	default:
+		close(u.done)
+	}
+	<-u.stopped
+}
+
+// Usage stops sampling and returns the usage so far
+func (u *usageRecorder) Usage() *ResourceUsage {
+	u.stop()
+
+	return &ResourceUsage{
+		SSHBytesSent:     atomic.LoadInt64(&u.sent),
+		SSHBytesReceived: atomic.LoadInt64(&u.received),
+		SSHSessions:      atomic.LoadInt64(&u.sessions),
+		APICalls:         atomic.LoadInt64(&apiCalls) - u.apiCallsStart,
+		PeakGoroutines:   atomic.LoadInt64(&u.peak),
+	}
+}
+
+// sessionOpened counts ssh sessions, u may be nil
+func (u *usageRecorder) sessionOpened() {
+	if u != nil {
+		atomic.AddInt64(&u.sessions, 1)
+	}
+}
+
+// conn counts bytes transferred over the connection, u may be nil
+func (u *usageRecorder) conn(c net.Conn) net.Conn {
+	if u == nil {
+		return c
+	}
+
+	return &countingConn{Conn: c, usage: u}
+}
+
+type countingConn struct {
+	net.Conn
+	usage *usageRecorder
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.usage.received, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.usage.sent, int64(n))
+	return n, err
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codedeploy"
+	"github.com/aws/aws-sdk-go/service/codepipeline"
+	"github.com/pkg/errors"
+)
+
+const (
+	verifyVersionFact   = "version"
+	verifyServicePrefix = "service:"
+)
+
+// Deployment describes the expected state of deployed instances
+type Deployment struct {
+	// TargetTags select deployed instances
+	TargetTags map[string]string `json:"target_tags"`
+	// Version is checked by running the command on every instance
+	Version *struct {
+		Command  string `json:"command"`
+		Expected string `json:"expected"`
+	} `json:"version"`
+	// Services map systemd units to their expected state, e.g. {"app": "active"}
+	Services map[string]string `json:"services"`
+}
+
+// CheckResult is a single verification check
+type CheckResult struct {
+	Check    string
+	Expected string
+	Actual   string
+	Passed   bool
+}
+
+// InstanceVerification is a verification result of the instance
+type InstanceVerification struct {
+	InstanceId string
+	Name       string
+	Passed     bool
+	Error      string `json:",omitempty"`
+	Checks     []CheckResult
+}
+
+// Verification is a result of the deployment verification
+type Verification struct {
+	RunID     string
+	Passed    bool
+	Instances []InstanceVerification
+}
+
+func parseDeployment(descriptor string) (*Deployment, error) {
+	d := &Deployment{}
+	if err := json.Unmarshal([]byte(descriptor), d); err != nil {
+		return nil, errors.Wrap(err, "Can't parse deployment descriptor")
+	}
+
+	if len(d.TargetTags) == 0 {
+		return nil, errors.Errorf("Deployment descriptor should have target_tags")
+	}
+
+	if d.Version == nil && len(d.Services) == 0 {
+		return nil, errors.Errorf("Deployment descriptor should check version or services")
+	}
+
+	return d, nil
+}
+
+// facts returns commands checking the deployment
+func (d *Deployment) facts() map[string]string {
+	facts := map[string]string{}
+	if d.Version != nil {
+		facts[verifyVersionFact] = d.Version.Command
+	}
+
+	// is-active exits with non-zero status for inactive units
+	for unit := range d.Services {
+		facts[verifyServicePrefix+unit] = fmt.Sprintf("systemctl is-active %s || true", shellQuote(unit))
+	}
+
+	return facts
+}
+
+// verifyDeployment collects facts from target instances and compares them with
+// expected values. Deployment without target instances doesn't pass.
+func (h *Handler) verifyDeployment(ctx context.Context, d *Deployment) (*Verification, error) {
+	result, err := h.deps.Worker(ctx, RunOptions{Facts: d.facts(), Tags: d.TargetTags})
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Verification{
+		RunID:     result.RunID,
+		Passed:    len(result.Rows) > 0,
+		Instances: []InstanceVerification{},
+	}
+
+	for _, row := range result.Rows {
+		iv := InstanceVerification{
+			InstanceId: row.InstanceId,
+			Name:       row.Name,
+			Passed:     row.Error == "",
+			Error:      row.Error,
+			Checks:     []CheckResult{},
+		}
+
+		if d.Version != nil {
+			iv.Checks = append(iv.Checks, newCheckResult(verifyVersionFact, d.Version.Expected, row.Facts[verifyVersionFact]))
+		}
+
+		for unit, expected := range d.Services {
+			iv.Checks = append(iv.Checks, newCheckResult(verifyServicePrefix+unit, expected, row.Facts[verifyServicePrefix+unit]))
+		}
+
+		for _, check := range iv.Checks {
+			iv.Passed = iv.Passed && check.Passed
+		}
+
+		v.Passed = v.Passed && iv.Passed
+		v.Instances = append(v.Instances, iv)
+	}
+
+	return v, nil
+}
+
+func newCheckResult(check, expected, actual string) CheckResult {
+	return CheckResult{
+		Check:    check,
+		Expected: expected,
+		Actual:   actual,
+		Passed:   actual == expected,
+	}
+}
+
+// shellQuote wraps the string into single quotes for remote shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// handleVerify serves POST /verify with deployment descriptor in the body
+func (h *Handler) handleVerify(ctx context.Context, request events.APIGatewayProxyRequest) (Response, error) {
+	d, err := parseDeployment(request.Body)
+	if err != nil {
+		return errorResponse(400, err)
+	}
+
+	v, err := h.verifyDeployment(ctx, d)
+	if err != nil {
+		return errorResponse(500, err)
+	}
+
+	return jsonResponse(200, v)
+}
+
+// isCodePipelineEvent tells if the function is invoked as CodePipeline action
+func isCodePipelineEvent(event json.RawMessage) (events.CodePipelineEvent, bool) {
+	cpEvent := events.CodePipelineEvent{}
+	if err := json.Unmarshal(event, &cpEvent); err != nil {
+		return cpEvent, false
+	}
+
+	return cpEvent, cpEvent.CodePipelineJob.ID != ""
+}
+
+// handleCodePipeline verifies deployment described in action UserParameters
+// and reports the job result back to CodePipeline
+func (h *Handler) handleCodePipeline(ctx context.Context, cpEvent events.CodePipelineEvent) error {
+	job := cpEvent.CodePipelineJob
+	svc := codepipeline.New(awsSession())
+
+	fail := func(err error) error {
+		_, perr := svc.PutJobFailureResult(&codepipeline.PutJobFailureResultInput{
+			JobId: aws.String(job.ID),
+			FailureDetails: &codepipeline.FailureDetails{
+				Type:    aws.String(codepipeline.FailureTypeJobFailed),
+				Message: aws.String(truncate(err.Error(), 5000)),
+			},
+		})
+		return errors.Wrapf(perr, "Can't report CodePipeline job %s failure", job.ID)
+	}
+
+	d, err := parseDeployment(job.Data.ActionConfiguration.Configuration.UserParameters)
+	if err != nil {
+		return fail(err)
+	}
+
+	v, err := h.verifyDeployment(ctx, d)
+	if err != nil {
+		return fail(err)
+	}
+
+	if !v.Passed {
+		return fail(errors.Errorf("Deployment verification failed: %s", v.failedInstances()))
+	}
+
+	_, err = svc.PutJobSuccessResult(&codepipeline.PutJobSuccessResultInput{
+		JobId: aws.String(job.ID),
+	})
+
+	return errors.Wrapf(err, "Can't report CodePipeline job %s success", job.ID)
+}
+
+// codeDeployHookEvent is sent to CodeDeploy lifecycle hook functions
+type codeDeployHookEvent struct {
+	DeploymentID                  string `json:"DeploymentId"`
+	LifecycleEventHookExecutionID string `json:"LifecycleEventHookExecutionId"`
+}
+
+func isCodeDeployHookEvent(event json.RawMessage) (codeDeployHookEvent, bool) {
+	hookEvent := codeDeployHookEvent{}
+	if err := json.Unmarshal(event, &hookEvent); err != nil {
+		return hookEvent, false
+	}
+
+	return hookEvent, hookEvent.LifecycleEventHookExecutionID != ""
+}
+
+// handleCodeDeployHook verifies deployment described in VERIFY_DEPLOYMENT
+// and reports the hook status back to CodeDeploy
+func (h *Handler) handleCodeDeployHook(ctx context.Context, hookEvent codeDeployHookEvent) error {
+	status := codedeploy.LifecycleEventStatusSucceeded
+
+	d, err := parseDeployment(getEnv("VERIFY_DEPLOYMENT", ""))
+	if err == nil {
+		var v *Verification
+		if v, err = h.verifyDeployment(ctx, d); err == nil && !v.Passed {
+			err = errors.Errorf("Deployment verification failed: %s", v.failedInstances())
+		}
+	}
+
+	if err != nil {
+		fmt.Println(errors.Wrapf(err, "CodeDeploy deployment %s", hookEvent.DeploymentID))
+		status = codedeploy.LifecycleEventStatusFailed
+	}
+
+	_, err = codedeploy.New(awsSession()).PutLifecycleEventHookExecutionStatus(&codedeploy.PutLifecycleEventHookExecutionStatusInput{
+		DeploymentId:                  aws.String(hookEvent.DeploymentID),
+		LifecycleEventHookExecutionId: aws.String(hookEvent.LifecycleEventHookExecutionID),
+		Status:                        aws.String(status),
+	})
+
+	return errors.Wrapf(err, "Can't report CodeDeploy deployment %s status", hookEvent.DeploymentID)
+}
+
+func (v *Verification) failedInstances() string {
+	if len(v.Instances) == 0 {
+		return "no target instances found"
+	}
+
+	failed := []string{}
+	for _, iv := range v.Instances {
+		if !iv.Passed {
+			failed = append(failed, iv.InstanceId)
+		}
+	}
+
+	return strings.Join(failed, ", ")
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+
+	return s
+}
@@ -2,186 +2,715 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
-	"fmt"
+	"context"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 )
 
 const (
-	defaultTimeout     = "5"
-	defaultMaxSessions = "10"
-	defaultUsers       = "centos,ec2-user"
-	defaultFacts       = `{"kernel": "uname -rs","release": "cat /etc/redhat-release || cat /etc/*-release"}`
+	defaultTimeout         = "5"
+	defaultMaxSessions     = "10"
+	defaultMaxAttempts     = "1"
+	defaultMaxConnections  = "0"
+	defaultMaxDialAttempts = "0"
+	defaultPendingWarmup   = "0"
+	defaultMaxInstances    = "0"
+	defaultSample          = "first"
+	defaultUsers           = "centos,ec2-user"
+	defaultFacts           = `{"kernel": "uname -rs","release": "cat /etc/redhat-release || cat /etc/*-release"}`
 )
 
 // ResRow contain the results of running commands listed in Facts
 type ResRow struct {
 	InstanceId string
 	Name       string
-	IPs        []string
+	Source     string
+	Account    string
+	Region     string
+	// AvailabilityZone, ImageId and LaunchTemplate (id:version) are set for instances described with EC2 API
+	AvailabilityZone string `json:",omitempty"`
+	ImageId          string `json:",omitempty"`
+	LaunchTemplate   string `json:",omitempty"`
+	// VpcId, SubnetId and AutoScalingGroup place EC2 instances in the network topology
+	VpcId            string `json:",omitempty"`
+	SubnetId         string `json:",omitempty"`
+	AutoScalingGroup string `json:",omitempty"`
+	IPs              []string
+	Attempts         int
+	// StartedAt and CompletedAt are times of the first and the last connection attempts
+	StartedAt   *time.Time `json:",omitempty"`
+	CompletedAt *time.Time `json:",omitempty"`
+	// Team is the value of TEAM_TAG tag
+	Team  string `json:",omitempty"`
+	Error string `json:",omitempty"`
+	// State is set when the instance was stopped or terminated during the run
+	// or discovered in a state listed in INSTANCE_STATES which doesn't allow to connect
+	State string `json:",omitempty"`
+
+	Facts     FactValues
+	Collected map[string]interface{} `json:",omitempty"`
+
+	// CachedFacts lists IMMUTABLE_FACTS reused from instances with the same AMI
+	CachedFacts []string `json:",omitempty"`
+	// FactHashes are hashes of commands of IMMUTABLE_FACTS, the cache is
+	// seeded only with values of unchanged commands
+	FactHashes map[string]string `json:",omitempty"`
+
+	// Variant and CandidateFacts are set when CANDIDATE_FACTS are rolled out
+	Variant        string     `json:",omitempty"`
+	CandidateFacts FactValues `json:",omitempty"`
+
+	// Compliance contains results of pipeline rules
+	Compliance map[string]bool `json:",omitempty"`
+
+	// Session is set with SESSION_FINGERPRINT
+	Session *SessionInfo `json:",omitempty"`
+}
+
+// FactValues maps fact labels to collected values, the output encoder
+// orders labels according to FACT_ORDER
+type FactValues map[string]string
+
+// RunMeta describes the run
+type RunMeta struct {
+	RunID       string
+	StartedAt   time.Time
+	CompletedAt time.Time
+	Duration    float64
+	Summary     map[string]int
+	DialLatency *LatencyHistogram `json:",omitempty"`
+	Usage       *ResourceUsage    `json:",omitempty"`
+
+	// Labels tie the run to change tickets, e.g. {"ticket": "OPS-1234"}
+	Labels map[string]string `json:",omitempty"`
+
+	// AccountErrors lists accounts which failed to be discovered
+	AccountErrors []AccountError `json:",omitempty"`
+
+	// StaleDiscovery is set when discovery failed and cached instances were used
+	StaleDiscovery *StaleDiscovery `json:",omitempty"`
+
+	// AddressReport is set with ADDRESS_REPORT
+	AddressReport *AddressReport `json:",omitempty"`
+
+	// ReadOnly is set when every command of the run passed STRICT_READONLY checks
+	ReadOnly bool `json:",omitempty"`
+
+	// JobID and Cancelled are set for SNS jobs, cancelled runs contain partial results
+	JobID     string `json:",omitempty"`
+	Cancelled bool   `json:",omitempty"`
 
+	// Filters and Hint are set when no instances matched
+	Filters *RunFilters `json:",omitempty"`
+	Hint    string      `json:",omitempty"`
+}
+
+// RunFilters echoes settings which scoped the run
+type RunFilters struct {
+	Discovery   []string
+	Regions     []string          `json:",omitempty"`
+	InstanceIDs []string          `json:",omitempty"`
+	Tags        map[string]string `json:",omitempty"`
+	Selector    string            `json:",omitempty"`
+}
+
+// RunResult contains the run description and results for every instance
+type RunResult struct {
+	RunMeta
+	Rows []ResRow
+	// Groups nest rows under regions and availability zones with GROUP_BY
+	Groups []ResGroup `json:",omitempty"`
+}
+
+// RunOptions overrides settings of a single run
+type RunOptions struct {
+	// Facts are collected instead of FACTS
 	Facts map[string]string
+	// InstanceIDs limits the run to the given instances
+	InstanceIDs []string
+	// Tags limits the run to instances having all the tags
+	Tags map[string]string
+	// Selector limits the run to instances matching the target expression, SELECTOR is used by default
+	Selector string
+	// Labels are stored with results along with RUN_LABELS
+	Labels map[string]string
+	// Hosts replace discovery, HOSTS are used by default
+	Hosts []string
+	// RefreshInventory clears the inventory cache before discovery
+	RefreshInventory bool
+	// JobID is the id of the SNS job
+	JobID string
 }
 
-// Worker is a wrapper for business logic
-func Worker() (resTable []ResRow, err error) {
-	startTime := time.Now()
+// parseLabels reads comma separated key=value pairs
+func parseLabels(value string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range splitList(value) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.Errorf("Invalid label: '%s' (should be key=value)", pair)
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
 
+	return labels, nil
+}
+
+// runLabels merges RUN_LABELS with labels of the run, the latter win
+func runLabels(opts RunOptions) (map[string]string, error) {
+	labels, err := parseLabels(getEnv("RUN_LABELS", ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "Can't parse RUN_LABELS")
+	}
+
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	return labels, nil
+}
+
+// Worker is a wrapper for business logic, the run is executed as a pipeline of steps
+func Worker(ctx context.Context, opts RunOptions) (*RunResult, error) {
 	if _, exists := os.LookupEnv("DEBUG"); !exists {
 		log.SetOutput(ioutil.Discard)
 	}
 
-	sshAuths, err := sshAuthSetup()
+	steps, err := getPipeline()
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	facts := getEnv("FACTS", defaultFacts)
-	factsToCollect := map[string]string{}
-	if err = json.Unmarshal([]byte(facts), &factsToCollect); err != nil {
-		return
+	return runPipeline(ctx, opts, steps)
+}
+
+// sshRunner keeps ssh settings shared by all hosts of the run
+type sshRunner struct {
+	auths []*ssh.ClientConfig
+
+	// maxDialAttempts caps connection attempts per host across all retries,
+	// 0 means no limit
+	maxDialAttempts int
+
+	// verboseLog logs every connection attempt instead of per-host summaries
+	verboseLog bool
+
+	// dialLatency records durations of successful connections
+	dialLatency *latencyRecorder
+
+	// commandLimiter caps simultaneous remote commands across all hosts and
+	// interleaves commands of different hosts, nil means no limit
+	commandLimiter *commandScheduler
+
+	// dialLimiter caps simultaneous ssh handshakes, nil means no limit
+	dialLimiter chan struct{}
+
+	// usage counts traffic and sessions of the run, nil means they are not counted
+	usage *usageRecorder
+
+	// timedOut contains addresses which timed out during the run
+	timedOut *addressBlacklist
+
+	// addressFamily orders addresses of hosts, see ADDRESS_FAMILY
+	addressFamily string
+
+	// dialNamer adds DNS names hosts are dialed by first, nil means addresses only
+	dialNamer *dialNamer
+
+	// resolver resolves dialed names, nil means the system resolver
+	resolver *net.Resolver
+
+	// relay connects to hosts of relay source, nil if RELAY_HOST is not set
+	relay *relayDialer
+
+	// readOnly rejects hosts with commands failing STRICT_READONLY checks, e.g. from gorunner:facts tag
+	readOnly bool
+}
+
+// addressBlacklist is shared by all hosts of the run, so retries don't pay
+// the timeout of a dead address again
+type addressBlacklist struct {
+	sync.Mutex
+	addrs map[string]bool
+}
+
+func (b *addressBlacklist) add(address string) {
+	b.Lock()
+	b.addrs[address] = true
+	b.Unlock()
+}
+
+// filter drops blacklisted addresses, all of them are kept if none is left
+func (b *addressBlacklist) filter(hosts []string, port string) []string {
+	b.Lock()
+	defer b.Unlock()
+
+	alive := []string{}
+	for _, host := range hosts {
+		if !b.addrs[net.JoinHostPort(host, port)] {
+			alive = append(alive, host)
+		}
+	}
+	if len(alive) == 0 {
+		return hosts
 	}
 
-	instances, err := getInstances()
+	return alive
+}
+
+func newSSHRunner(auths []*ssh.ClientConfig, maxConnections, maxCommands, maxDialAttempts int) *sshRunner {
+	r := &sshRunner{
+		auths:           auths,
+		maxDialAttempts: maxDialAttempts,
+		dialLatency:     &latencyRecorder{},
+		timedOut:        &addressBlacklist{addrs: map[string]bool{}},
+		addressFamily:   defaultAddressFamily,
+	}
+	if maxCommands > 0 {
+		r.commandLimiter = newCommandScheduler(maxCommands)
+	}
+	if maxConnections > 0 {
+		r.dialLimiter = make(chan struct{}, maxConnections)
+	}
+
+	return r
+}
+
+// findInstances looks up instances by ids when all discovery sources support it,
+// otherwise the whole inventory is discovered and filtered
+func findInstances(opts RunOptions) ([]*InstanceInfo, []AccountError, *StaleDiscovery, error) {
+	if len(opts.InstanceIDs) > 0 {
+		instances, accountErrors, ok, err := lookupInstances(opts.InstanceIDs)
+		if err != nil || ok {
+			return instances, accountErrors, nil, err
+		}
+	}
+
+	instances, accountErrors, stale, err := getInstances(opts.RefreshInventory)
 	if err != nil {
-		return
+		return nil, nil, nil, err
+	}
+
+	if len(opts.InstanceIDs) > 0 {
+		instances = filterInstanceIDs(instances, opts.InstanceIDs)
+	}
+
+	return instances, accountErrors, stale, nil
+}
+
+// filterInstanceIDs keeps instances with given ids only
+func filterInstanceIDs(instances []*InstanceInfo, ids []string) []*InstanceInfo {
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	filtered := []*InstanceInfo{}
+	for _, inst := range instances {
+		if wanted[inst.id] {
+			filtered = append(filtered, inst)
+		}
+	}
+
+	return filtered
+}
+
+// filterTags keeps instances having all the tags, values may contain
+// * and ? wildcards like EC2 tag filters
+func filterTags(instances []*InstanceInfo, tags map[string]string) []*InstanceInfo {
+	patterns := tagPatterns(tags)
+
+	filtered := []*InstanceInfo{}
+	for _, inst := range instances {
+		if inst.matchTags(patterns) {
+			filtered = append(filtered, inst)
+		}
+	}
+
+	return filtered
+}
+
+// excludeTags drops instances having all the tags, the number of dropped instances is returned
+func excludeTags(instances []*InstanceInfo, tags map[string]string) ([]*InstanceInfo, int) {
+	if len(tags) == 0 {
+		return instances, 0
+	}
+
+	patterns := tagPatterns(tags)
+
+	kept := []*InstanceInfo{}
+	for _, inst := range instances {
+		if inst.matchTags(patterns) {
+			log.Printf("%s is excluded with EXCLUDE_TAGS", inst.id)
+			continue
+		}
+		kept = append(kept, inst)
+	}
+
+	return kept, len(instances) - len(kept)
+}
+
+// getNamePatterns returns NAME_INCLUDE and NAME_EXCLUDE expressions, nil if they aren't set
+func getNamePatterns() (include, exclude *regexp.Regexp, err error) {
+	patterns := []*regexp.Regexp{nil, nil}
+	for i, name := range []string{"NAME_INCLUDE", "NAME_EXCLUDE"} {
+		if value := getEnv(name, ""); value != "" {
+			if patterns[i], err = regexp.Compile(value); err != nil {
+				return nil, nil, errors.Wrapf(err, "Can't parse %s", name)
+			}
+		}
+	}
+
+	return patterns[0], patterns[1], nil
+}
+
+// filterNames keeps instances with names matching include and not matching exclude,
+// nil patterns match everything. The number of dropped instances is returned.
+func filterNames(instances []*InstanceInfo, include, exclude *regexp.Regexp) ([]*InstanceInfo, int) {
+	kept := []*InstanceInfo{}
+	for _, inst := range instances {
+		if (include != nil && !include.MatchString(inst.name)) || (exclude != nil && exclude.MatchString(inst.name)) {
+			log.Printf("%s is skipped by its name '%s'", inst.id, inst.name)
+			continue
+		}
+		kept = append(kept, inst)
+	}
+
+	return kept, len(instances) - len(kept)
+}
+
+// getAgeLimits returns MIN_AGE_MINUTES and MAX_AGE_MINUTES, 0 means no limit
+func getAgeLimits() (min, max time.Duration, err error) {
+	limits := []time.Duration{0, 0}
+	for i, name := range []string{"MIN_AGE_MINUTES", "MAX_AGE_MINUTES"} {
+		value := getEnv(name, "0")
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 0 {
+			return 0, 0, errors.Errorf("Invalid %s: '%s'", name, value)
+		}
+		limits[i] = time.Duration(minutes) * time.Minute
+	}
+
+	if limits[1] > 0 && limits[0] > limits[1] {
+		return 0, 0, errors.Errorf("MIN_AGE_MINUTES should not exceed MAX_AGE_MINUTES")
+	}
+
+	return limits[0], limits[1], nil
+}
+
+// filterAge drops instances launched less than min or more than max ago,
+// instances without launch time are kept. The number of dropped instances is returned.
+func filterAge(instances []*InstanceInfo, min, max time.Duration, now time.Time) ([]*InstanceInfo, int) {
+	if min <= 0 && max <= 0 {
+		return instances, 0
+	}
+
+	kept := []*InstanceInfo{}
+	for _, inst := range instances {
+		launched := inst.launchTime()
+		if launched.IsZero() {
+			kept = append(kept, inst)
+			continue
+		}
+
+		age := now.Sub(launched)
+		if (min > 0 && age < min) || (max > 0 && age > max) {
+			log.Printf("%s is skipped, launched %v ago", inst.id, age.Round(time.Minute))
+			continue
+		}
+		kept = append(kept, inst)
+	}
+
+	return kept, len(instances) - len(kept)
+}
+
+// getLifecycles returns INSTANCE_LIFECYCLE, empty means any lifecycle
+func getLifecycles() ([]string, error) {
+	lifecycles := splitList(getEnv("INSTANCE_LIFECYCLE", ""))
+	for _, lifecycle := range lifecycles {
+		if !validLifecycle(lifecycle) {
+			return nil, errors.Errorf("Unknown lifecycle in INSTANCE_LIFECYCLE: '%s' (available: %s)", lifecycle, strings.Join(instanceLifecycles, ", "))
+		}
+	}
+
+	return lifecycles, nil
+}
+
+// filterLifecycle drops EC2 instances of other lifecycles, instances of other
+// sources are kept. The number of dropped instances is returned.
+func filterLifecycle(instances []*InstanceInfo, lifecycles []string) ([]*InstanceInfo, int) {
+	wanted := map[string]bool{}
+	for _, lifecycle := range lifecycles {
+		wanted[lifecycle] = true
+	}
+
+	kept := []*InstanceInfo{}
+	for _, inst := range instances {
+		lifecycle := inst.lifecycle()
+		if lifecycle != "" && !wanted[lifecycle] {
+			log.Printf("%s is skipped, it's %s instance", inst.id, lifecycle)
+			continue
+		}
+		kept = append(kept, inst)
+	}
+
+	return kept, len(instances) - len(kept)
+}
+
+// getSampling returns MAX_INSTANCES (0 means no cap) and SAMPLE strategy
+func getSampling() (int, string, error) {
+	value := getEnv("MAX_INSTANCES", defaultMaxInstances)
+	maxInstances, err := strconv.Atoi(value)
+	if err != nil || maxInstances < 0 {
+		return 0, "", errors.Errorf("Invalid MAX_INSTANCES: '%s'", value)
+	}
+
+	sample := getEnv("SAMPLE", defaultSample)
+	if sample != "first" && sample != "random" {
+		return 0, "", errors.Errorf("Unknown SAMPLE: '%s' (available: first, random)", sample)
+	}
+
+	return maxInstances, sample, nil
+}
+
+// sampleInstances keeps max instances, the first ones in discovery order or random ones.
+// The number of dropped instances is returned.
+func sampleInstances(instances []*InstanceInfo, max int, sample string) ([]*InstanceInfo, int) {
+	if len(instances) <= max {
+		return instances, 0
+	}
+
+	if sample == "random" {
+		shuffled := append([]*InstanceInfo{}, instances...)
+		rand.New(rand.NewSource(time.Now().UnixNano())).Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		instances = shuffled
+	}
+
+	log.Printf("Contacting %v of %v instance(s), MAX_INSTANCES is reached", max, len(instances))
+
+	return instances[:max], len(instances) - max
+}
+
+func tagPatterns(tags map[string]string) map[string]*regexp.Regexp {
+	patterns := map[string]*regexp.Regexp{}
+	for k, v := range tags {
+		pattern := regexp.QuoteMeta(v)
+		pattern = strings.Replace(pattern, `\*`, ".*", -1)
+		pattern = strings.Replace(pattern, `\?`, ".", -1)
+		patterns[k] = regexp.MustCompile("^" + pattern + "$")
 	}
 
-	maxSessions, _ := strconv.Atoi(getEnv("MAX_SESSIONS", defaultMaxSessions))
+	return patterns
+}
+
+// matchTags tells if the instance has all the tags matching patterns
+func (inst *InstanceInfo) matchTags(patterns map[string]*regexp.Regexp) bool {
+	for k, re := range patterns {
+		if value, ok := inst.tags[k]; !ok || !re.MatchString(value) {
+			return false
+		}
+	}
+
+	return true
+}
 
-	fmt.Printf("Collecting facts (%s) for %v instances(s)...\n", facts, len(instances))
+// errRunCancelled is reported by instances which were not contacted before the run was cancelled
+var errRunCancelled = errors.New("Run cancelled before the instance was contacted")
 
+// dispatch collects facts from all instances at once,
+// no new instances are contacted once ctx is cancelled
+func dispatch(ctx context.Context, instances []*InstanceInfo, maxSessions int, commands map[string]string, enabledCollectors map[string]Collector, runner *sshRunner) {
 	// concurrency control
 	limiter := make(chan int, maxSessions)
 	var wg sync.WaitGroup
 
-	// dispatch all at once
 	for i := range instances {
 		wg.Add(1)
-		go processFact(i, limiter, factsToCollect, &wg, sshAuths, instances[i])
+		go processFact(ctx, i, limiter, commands, enabledCollectors, &wg, runner, instances[i])
 	}
 
 	wg.Wait()
-
-	endTime := time.Now()
-	diff := endTime.Sub(startTime)
-
-	resTable = formatResult(instances, factsToCollect)
-
-	fmt.Printf("\nProcessed %v instance(s) for %v seconds\n", len(instances), diff.Seconds())
-
-	return
 }
 
-func processFact(jobID int, limiter chan int, factsToCollect map[string]string, wg *sync.WaitGroup, auths []*ssh.ClientConfig, instance *InstanceInfo) {
+func processFact(ctx context.Context, jobID int, limiter chan int, factsToCollect map[string]string, enabledCollectors map[string]Collector, wg *sync.WaitGroup, runner *sshRunner, instance *InstanceInfo) {
 	defer wg.Done()
-	limiter <- jobID // block the control until some other goroutine reads from this channel
+
+	// block the control until some other goroutine reads from this channel
+	select {
+	case limiter <- jobID:
+	case <-ctx.Done():
+		if instance.attempts == 0 {
+			instance.err = errRunCancelled
+		}
+		return
+	}
 
 	// mutate instance
-	instance.facts, instance.err = GetFacts(instance.addrs, factsToCollect, auths)
-	if instance.err != nil {
-		log.Println(instance.err)
+	if instance.attempts == 0 {
+		instance.startedAt = time.Now()
 	}
+	instance.attempts++
+	commands := instance.commands(factsToCollect)
+	if runner.readOnly {
+		if err := checkReadOnly(commands); err != nil {
+			instance.facts, instance.err = nil, err
+			instance.collectedAt = time.Now()
+			<-limiter
+			return
+		}
+	}
+	if len(commands) == 0 && len(instance.cachedFacts) > 0 {
+		// all facts are cached, the instance isn't contacted
+		instance.facts, instance.err = map[string]string{}, nil
+	} else if instance.err = authorizeInstance(instance); instance.err == nil {
+		instance.facts, instance.err = runner.GetFacts(ctx, instance, commands)
+	}
+	if instance.facts != nil {
+		for label, value := range instance.cachedFacts {
+			instance.facts[label] = value
+		}
+	}
+	instance.collectedAt = time.Now()
+
+	parseSession(instance)
+	parseCollected(instance, enabledCollectors)
 
 	<-limiter // just read to unblock the limiter
 }
 
 // GetFacts collects facts from the map
-func GetFacts(hostAddrs []string, factsToCollect map[string]string, auths []*ssh.ClientConfig) (map[string]string, error) {
+func (r *sshRunner) GetFacts(ctx context.Context, instance *InstanceInfo, factsToCollect map[string]string) (facts map[string]string, err error) {
+	hostAddrs := orderAddrs(r.dialTargets(instance), r.addressFamily)
+	auths := r.authsFor(instance)
 	if len(hostAddrs) == 0 {
 		return nil, errors.Errorf("No hosts to get facts")
 	}
 
+	// addresses which timed out earlier in the run are not probed again
+	// while the host has other addresses
+	dialAddrs := r.timedOut.filter(hostAddrs, instance.port())
+
 	//TODO:
-	// try to implement .Dial() to all hostAddrs in parallel,
-	// but be aware of maximum failed attempts
+	// try to implement .Dial() to all hostAddrs in parallel
 	conStr := ""
+	attempts := newAttemptLog(instance.id, r.verboseLog)
+	defer func() { attempts.flush(conStr, facts, err) }()
+
+	retryable := false
+	budgetExceeded := false
+	dead := map[string]bool{}
 	var client *ssh.Client
-	for i := 0; i < len(auths) && conStr == ""; i++ {
+	for i := 0; i < len(auths) && conStr == "" && !budgetExceeded; i++ {
 		auth := auths[i]
-		for _, host := range hostAddrs {
-			log.Printf("Trying %s@%s... \n", auth.User, host)
+		for _, host := range dialAddrs {
+			// fast-fail: the address didn't accept tcp connection for previous user
+			if dead[host] {
+				continue
+			}
+
+			if r.maxDialAttempts > 0 && instance.dialAttempts >= r.maxDialAttempts {
+				budgetExceeded = true
+				break
+			}
+			instance.dialAttempts++
+
+			attempts.trying(auth.User + "@" + host)
+
+			address := net.JoinHostPort(host, instance.port())
 
 			var err error
-			if client, err = ssh.Dial("tcp", host+":22", auth); err == nil {
+			if client, err = r.dial(instance, address, auth); err == nil {
 				conStr = auth.User + "@" + host
+				instance.user = auth.User
 				break
 			}
 
-			log.Println(errors.Wrap(err, "Failed to connect "+auth.User+"@"+host))
+			attempts.failed(auth.User+"@"+host, err)
+			retryable = retryable || !isAuthError(err)
+
+			// tcp level errors are not wrapped by ssh.Dial, handshake errors are
+			if netErr, ok := err.(net.Error); ok {
+				dead[host] = true
+				if netErr.Timeout() {
+					r.timedOut.add(address)
+				}
+			}
 		}
 	}
 
 	if conStr == "" {
-		return nil, errors.Errorf("Can't connect to host with addresses: %v", hostAddrs)
+		if budgetExceeded {
+			return nil, errors.Errorf("Can't connect to host with addresses: %v, dial budget of %v attempts is exceeded", hostAddrs, r.maxDialAttempts)
+		}
+
+		err := errors.Errorf("Can't connect to host with addresses: %v", hostAddrs)
+		if retryable {
+			return nil, retryableError{err}
+		}
+		return nil, err
 	}
 
 	// no dead connections left on errors
 	defer client.Close()
 
 	type remoteCmd struct {
-		session *ssh.Session
-		stdout  *bytes.Buffer
-		stderr  *bytes.Buffer
-		cmd     string
-		err     error
+		stdout *bytes.Buffer
+		stderr *bytes.Buffer
+		err    error
 	}
 
-	commands := map[string]remoteCmd{}
+	commands := map[string]*remoteCmd{}
+	var wg sync.WaitGroup
 
-	// Create a command sessions: one session per command
+	// run in parallel: one session per command
 	for name, cmd := range factsToCollect {
-		session, err := client.NewSession()
-		if err != nil {
-			// DANGER: we are running out of resources
-			return nil, errors.Wrap(err, "Can't allocate session for "+conStr)
+		c := &remoteCmd{
+			stdout: &bytes.Buffer{},
+			stderr: &bytes.Buffer{},
 		}
+		commands[name] = c
 
-		commands[name] = remoteCmd{
-			cmd:     cmd,
-			session: session,
-			stdout:  &bytes.Buffer{},
-			stderr:  &bytes.Buffer{},
-		}
-		session.Stdout = commands[name].stdout
-		session.Stderr = commands[name].stderr
-
-		// start in parallel
-		if err := session.Start(cmd); err != nil {
-			return nil, errors.Wrap(err, "Can't start command: '"+cmd+"' at "+conStr)
-		}
+		wg.Add(1)
+		go func(cmd string) {
+			defer wg.Done()
+			c.err = r.runCommand(ctx, client, conStr, cmd, c.stdout, c.stderr)
+		}(cmd)
 	}
 
-	facts := map[string]string{}
+	wg.Wait()
+
+	facts = map[string]string{}
 
 	combErr := errors.Errorf("can't collect all facts for %s", conStr)
 	hasErrors := false
 	for name, c := range commands {
-		if err := c.session.Wait(); err != nil {
-			combErr = errors.Wrapf(combErr, "Failed to collect '%s' fact: %s (@err %s)", name, err.Error(), c.stderr)
+		if c.err != nil {
+			if isRetryable(c.err) {
+				return nil, c.err
+			}
+
+			combErr = errors.Wrapf(combErr, "Failed to collect '%s' fact: %s (@err %s)", name, c.err.Error(), c.stderr)
 			hasErrors = true
 		} else {
 			facts[name] = strings.TrimSpace(c.stdout.String())
 		}
-
-		c.session.Close()
 	}
 
-	log.Printf("...[%s] found facts: %v", conStr, facts)
+	attempts.found(conStr, facts)
 
 	if !hasErrors {
 		combErr = nil
@@ -190,153 +719,197 @@ func GetFacts(hostAddrs []string, factsToCollect map[string]string, auths []*ssh
 	return facts, combErr
 }
 
-func sshAuthSetup() ([]*ssh.ClientConfig, error) {
-	sshKey := os.Getenv("SSH_KEY")
-	sshKeyPath := os.Getenv("SSH_KEY_PATH")
-	sshAuthSock := os.Getenv("SSH_AUTH_SOCK")
-	timeout, _ := strconv.Atoi(getEnv("TIMEOUT", defaultTimeout))
-
-	if sshKey == "" && sshKeyPath == "" && sshAuthSock == "" {
-		return nil, errors.Errorf("You should provide ssh key or launch SSH agent")
+// dial connects to the address once the dial limiter allows it,
+// time spent waiting for the limiter is not counted in dial latency.
+// Hosts of relay source are dialed through the relay.
+func (r *sshRunner) dial(instance *InstanceInfo, address string, auth *ssh.ClientConfig) (*ssh.Client, error) {
+	if r.dialLimiter != nil {
+		r.dialLimiter <- struct{}{}
+		defer func() { <-r.dialLimiter }()
 	}
 
-	var authMethod ssh.AuthMethod
-	if sshKey != "" || sshKeyPath != "" {
-		if sshKey == "" {
-			f, err := os.Open(sshKeyPath)
-			if err != nil {
-				return nil, err
-			}
-
-			defer f.Close()
-
-			b, err := ioutil.ReadAll(f)
-			if err != nil {
-				return nil, errors.Wrap(err, "Can't open ssh key file")
-			}
-
-			sshKey = string(b)
+	// same as ssh.Dial, but traffic of the connection is counted
+	dialStart := time.Now()
+	var conn net.Conn
+	var err error
+	if instance.source == relaySource {
+		if r.relay == nil {
+			return nil, errors.Errorf("RELAY_HOST is not set")
 		}
-
-		key, err := ssh.ParsePrivateKey([]byte(sshKey))
-		if err != nil {
-			return nil, err
-		}
-
-		authMethod = ssh.PublicKeys(key)
+		conn, err = r.relay.dial(address)
 	} else {
-		agentConn, err := net.Dial("unix", sshAuthSock)
-		if err != nil {
-			return nil, errors.Wrap(err, "Can't open connection to SSH agent: "+sshAuthSock)
-		}
-
-		agentClient := agent.NewClient(agentConn)
-		authMethod = ssh.PublicKeysCallback(agentClient.Signers)
+		conn, err = (&net.Dialer{Timeout: auth.Timeout, Resolver: r.resolver}).Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(r.usage.conn(conn), address, auth)
+	if err != nil {
+		return nil, err
 	}
+	r.dialLatency.Observe(time.Since(dialStart))
 
-	auths := []*ssh.ClientConfig{}
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-	users := strings.Split(getEnv("USERS", defaultUsers), ",")
-	for i := 0; i < len(users); i++ {
-		users[i] = strings.TrimSpace(users[i])
+// dialTargets returns addresses of the instance preceded by its DNS name of DIAL_NAME
+func (r *sshRunner) dialTargets(instance *InstanceInfo) []string {
+	if r.dialNamer == nil {
+		return instance.addrs
 	}
 
-	for _, user := range users {
-		// safe copy
-		config := &ssh.ClientConfig{
-			User: user,
-			Auth: []ssh.AuthMethod{
-				authMethod,
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-			Timeout:         time.Second * time.Duration(timeout),
-		}
-
-		auths = append(auths, config)
+	name := r.dialNamer.name(instance)
+	if name == "" {
+		return instance.addrs
 	}
 
-	return auths, nil
+	return append([]string{name}, instance.addrs...)
 }
 
-// InstanceInfo conatains host addresses, collected facts and AWS description
-type InstanceInfo struct {
-	description *ec2.Instance
-	addrs       []string
-	facts       map[string]string
-	err         error
-}
+// authsFor returns ssh settings of the instance, static hosts may set their own
+// user which is tried with every key
+func (r *sshRunner) authsFor(instance *InstanceInfo) []*ssh.ClientConfig {
+	if instance.sshUser == "" || len(r.auths) == 0 {
+		return r.auths
+	}
 
-// getInstances finds and describes (aws describe) all running instances
-func getInstances() ([]*InstanceInfo, error) {
-	s := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+	auths := []*ssh.ClientConfig{}
+	for _, config := range r.auths {
+		if config.User != r.auths[0].User {
+			break
+		}
+		auth := *config
+		auth.User = instance.sshUser
+		auths = append(auths, &auth)
+	}
 
-	// Create new EC2 client
-	ec2Svc := ec2.New(s)
+	return auths
+}
 
-	params := &ec2.DescribeInstancesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("instance-state-name"),
-				Values: []*string{aws.String("running"), aws.String("pending")},
-			},
-		},
+// runCommand runs the command in a new session once the command scheduler allows it,
+// commands still waiting for the scheduler are not started once ctx is cancelled
+func (r *sshRunner) runCommand(ctx context.Context, client *ssh.Client, conStr, cmd string, stdout, stderr *bytes.Buffer) error {
+	if r.commandLimiter != nil {
+		if err := r.commandLimiter.acquire(ctx, conStr); err != nil {
+			return errors.Wrap(err, "Can't start command: '"+cmd+"' at "+conStr)
+		}
+		defer r.commandLimiter.release(conStr)
 	}
 
-	instances, err := ec2Svc.DescribeInstances(params)
+	session, err := client.NewSession()
 	if err != nil {
-		return nil, errors.Wrap(err, "Can't fetch ec2 instances list")
+		// DANGER: we are running out of resources
+		return retryableError{errors.Wrap(err, "Can't allocate session for "+conStr)}
 	}
+	defer session.Close()
+	r.usage.sessionOpened()
 
-	instancesInfo := []*InstanceInfo{}
+	session.Stdout = stdout
+	session.Stderr = stderr
 
-	for _, reservation := range instances.Reservations {
-		for _, instance := range reservation.Instances {
-			iInfo := &InstanceInfo{}
+	if err := session.Start(cmd); err != nil {
+		return retryableError{errors.Wrap(err, "Can't start command: '"+cmd+"' at "+conStr)}
+	}
 
-			iInfo.description = instance
-			iInfo.addrs = []string{}
+	return session.Wait()
+}
 
-			if instance.PrivateIpAddress != nil && *instance.PrivateIpAddress != "" {
-				iInfo.addrs = append(iInfo.addrs, *instance.PrivateIpAddress)
-			}
+func sshAuthSetup() ([]*ssh.ClientConfig, error) {
+	timeout := getTimeout()
 
-			if instance.PublicIpAddress != nil && *instance.PublicIpAddress != "" {
-				iInfo.addrs = append(iInfo.addrs, *instance.PublicIpAddress)
+	authMethods, err := getAuthMethods()
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := getHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	auths := []*ssh.ClientConfig{}
+
+	// every key is tried with every user, all keys of the user go first
+	for _, user := range getUsers() {
+		for _, authMethod := range authMethods {
+			// safe copy
+			config := &ssh.ClientConfig{
+				User: user,
+				Auth: []ssh.AuthMethod{
+					authMethod,
+				},
+				HostKeyCallback: hostKeyCallback,
+				Timeout:         time.Second * time.Duration(timeout),
 			}
 
-			instancesInfo = append(instancesInfo, iInfo)
+			auths = append(auths, config)
 		}
 	}
 
-	log.Printf("AWS: found %v instance(s) in running or pending state...", len(instancesInfo))
+	return auths, nil
+}
+
+func getTimeout() int {
+	timeout, _ := strconv.Atoi(getEnv("TIMEOUT", defaultTimeout))
+	return timeout
+}
 
-	return instancesInfo, nil
+func getUsers() []string {
+	users := strings.Split(getEnv("USERS", defaultUsers), ",")
+	for i := 0; i < len(users); i++ {
+		users[i] = strings.TrimSpace(users[i])
+	}
+
+	return users
 }
 
 func formatResult(instances []*InstanceInfo, factsToCollect map[string]string) (resTable []ResRow) {
+	resTable = []ResRow{}
+	teamTag := getEnv("TEAM_TAG", "")
+
 	for _, inst := range instances {
 		row := ResRow{
-			Facts: make(map[string]string),
+			Facts: FactValues{},
 		}
 
-		if inst.description.InstanceId != nil {
-			row.InstanceId = *inst.description.InstanceId
+		row.InstanceId = inst.id
+		row.Name = inst.name
+		row.Source = inst.source
+		row.Account = inst.account
+		row.Region = inst.region
+		row.AvailabilityZone = inst.availabilityZone()
+		row.ImageId = inst.imageID()
+		row.LaunchTemplate = inst.launchTemplate()
+		row.AutoScalingGroup = inst.tags[asgNameTag]
+		row.VpcId, row.SubnetId = inst.network()
+		row.IPs = inst.addrs
+		row.Attempts = inst.attempts
+		if !inst.startedAt.IsZero() {
+			startedAt, completedAt := inst.startedAt.UTC(), inst.collectedAt.UTC()
+			row.StartedAt, row.CompletedAt = &startedAt, &completedAt
 		}
-
-		for _, tag := range inst.description.Tags {
-			if *tag.Key == "Name" {
-				row.Name = *tag.Value
-				break
-			}
+		if teamTag != "" {
+			row.Team = inst.tags[teamTag]
 		}
-
-		row.IPs = inst.addrs
+		if inst.err != nil {
+			row.Error = inst.err.Error()
+		}
+		row.State = inst.stateChanged
+		if inst.offline() {
+			row.State = inst.state()
+		}
+		row.Collected = inst.collected
+		row.CachedFacts = inst.cachedLabels()
+		row.FactHashes = inst.factHashes
+		row.Variant = inst.variant
+		row.Session = inst.session
 
 		unkRes := ""
 		if inst.facts != nil {
-			for k := range factsToCollect {
+			for k := range inst.withTagFacts(factsToCollect) {
+				if strings.HasPrefix(k, collectorPrefix) {
+					continue
+				}
+
 				res := unkRes
 				if fact, ok := inst.facts[k]; ok {
 					res = fact
@@ -345,8 +918,50 @@ func formatResult(instances []*InstanceInfo, factsToCollect map[string]string) (
 			}
 		}
 
+		if inst.facts != nil && len(inst.candidateFacts) > 0 {
+			row.CandidateFacts = FactValues{}
+			for k := range inst.candidateFacts {
+				row.CandidateFacts[k] = inst.facts[candidatePrefix+k]
+			}
+		}
+
 		resTable = append(resTable, row)
 	}
 
 	return
 }
+
+func summarize(instances []*InstanceInfo, enabledCollectors map[string]Collector) map[string]int {
+	summary := map[string]int{
+		"instances": len(instances),
+		"failed":    0,
+		"retried":   0,
+	}
+
+	for _, inst := range instances {
+		switch {
+		case inst.offline():
+			summary["offline"]++
+		case inst.stateChanged != "":
+			summary["state_changed"]++
+		case inst.err != nil:
+			summary["failed"]++
+		}
+		if inst.attempts > 1 {
+			summary["retried"]++
+		}
+		if inst.variant == variantCandidate {
+			summary["candidates"]++
+		}
+		if len(inst.cachedFacts) > 0 {
+			summary["fact_cache_hits"]++
+		}
+		if inst.session.anomalous() {
+			summary["session_anomalies"]++
+		}
+	}
+
+	summarizeCollected(summary, instances, enabledCollectors)
+
+	return summary
+}